@@ -7,6 +7,7 @@ require (
 	github.com/fogleman/gg v1.3.0
 	github.com/joho/godotenv v1.5.1
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	github.com/rs/xid v1.6.0
 	github.com/rs/zerolog v1.35.1
 )
 
@@ -14,7 +15,6 @@ require (
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/rs/xid v1.6.0 // indirect
 	golang.org/x/image v0.38.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
 )
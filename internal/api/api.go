@@ -3,40 +3,68 @@ package api
 import (
 	"bytes"
 	"context"
+	"errors"
 	"image"
 	"image/jpeg"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
+	"github.com/SongStitch/song-stitch/internal/clients"
 	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/clients/listenbrainz"
 	"github.com/SongStitch/song-stitch/internal/collages"
 	"github.com/SongStitch/song-stitch/internal/config"
 )
 
+// elementsFunc sends the elements of a collage to the channel
+type elementsFunc func(
+	context.Context,
+	collages.ElementOptions,
+	collages.DisplayOptions,
+	chan<- collages.CollageElement,
+) error
+
+// lastfmElements returns the function fetching the elements of the collage type
+// from Last.fm
+func lastfmElements(method lastfm.Method) elementsFunc {
+	switch method {
+	case lastfm.MethodArtist:
+		return collages.GetElementsForArtist
+	case lastfm.MethodTrack:
+		return collages.GetElementsForTrack
+	case lastfm.MethodTag:
+		return collages.GetElementsForTag
+	case lastfm.MethodRecent:
+		return collages.GetElementsForRecent
+	case lastfm.MethodLoved:
+		return collages.GetElementsForLoved
+	default:
+		return collages.GetElementsForAlbum
+	}
+}
+
 func generateCollage(
 	ctx context.Context,
 	request *CollageRequest,
 ) (image.Image, *bytes.Buffer, error) {
-	config := config.GetConfig()
+	return generateCollageFrom(ctx, request, lastfmElements(request.Method))
+}
 
+// generateCollageFrom renders the collage for the request with the elements from
+// getElements
+func generateCollageFrom(
+	ctx context.Context,
+	request *CollageRequest,
+	getElements elementsFunc,
+) (image.Image, *bytes.Buffer, error) {
 	count := request.Rows * request.Columns
-
-	var imageSize string
-	var imageDimension int
-	if count > config.ImageSizeCutoffs.Medium {
-		imageSize = "small"
-		imageDimension = 3
-	} else if count > config.ImageSizeCutoffs.Large {
-		imageSize = "medium"
-		imageDimension = 64
-	} else if count > config.ImageSizeCutoffs.ExtraLarge {
-		imageSize = "large"
-		imageDimension = 174
-	} else {
-		imageSize = "extralarge"
-		imageDimension = 300
+	if request.AutoSize {
+		count = maxImagesForMethod(request.Method)
 	}
+	imageSize, imageDimension := tileSize(request, request.Rows, request.Columns, count)
 
 	displayOptions := collages.DisplayOptions{
 		ArtistName:     request.DisplayArtist,
@@ -54,50 +82,151 @@ func generateCollage(
 		Rows:           request.Rows,
 		Columns:        request.Columns,
 		TextLocation:   request.TextLocation,
+		Sort:           request.Sort,
+		Fit:            request.Fit,
+	}
+	if err := collages.FitPixelBudget(&displayOptions); err != nil {
+		return nil, nil, err
+	}
+
+	usernames := request.Usernames
+	if len(usernames) == 0 {
+		usernames = []string{request.Username}
+	}
+	elementOptions := collages.ElementOptions{
+		Source:        request.Source,
+		Usernames:     usernames,
+		Period:        request.Period,
+		Count:         count,
+		ImageSize:     imageSize,
+		Aggregation:   request.Aggregation,
+		Dedupe:        request.Dedupe,
+		MinPlays:      request.MinPlays,
+		MergeEditions: request.MergeEditions,
+		Filter: collages.Filter{
+			Exclude:     request.Exclude,
+			OnlyArtists: request.OnlyArtists,
+			Tag:         request.Tag,
+		},
+	}
+
+	// the grid is resized once the number of items meeting the minimum playcount
+	// is known, which happens before any artwork is fetched
+	var fitOnce sync.Once
+	fitted := make(chan collages.DisplayOptions, 1)
+	fit := func(rows, columns int) {
+		fitOnce.Do(func() {
+			options := displayOptions
+			options.Rows, options.Columns = rows, columns
+			_, options.ImageDimension = tileSize(request, rows, columns, rows*columns)
+			fitted <- options
+		})
+	}
+	resizeGrid := request.AutoSize || request.MinPlays > 0
+	if resizeGrid {
+		elementOptions.Fit = func(n int) (int, string) {
+			rows, columns := request.Rows, request.Columns
+			if request.AutoSize {
+				rows, columns = fitGrid(n, count)
+			} else {
+				rows, columns = shrinkGrid(n, rows, columns)
+			}
+			fit(rows, columns)
+			size, _ := tileSize(request, rows, columns, rows*columns)
+			return rows * columns, size
+		}
 	}
 
 	jobChan := make(chan collages.CollageElement, 100)
 	logger := zerolog.Ctx(ctx)
+	var fetchErr error
 	go func() {
-		var err error
-		switch request.Method {
-		case lastfm.MethodAlbum:
-			err = collages.GetElementsForAlbum(
-				ctx,
-				request.Username,
-				request.Period,
-				count,
-				imageSize,
-				displayOptions,
-				jobChan,
-			)
-		case lastfm.MethodArtist:
-			err = collages.GetElementsForArtist(
-				ctx,
-				request.Username,
-				request.Period,
-				count,
-				imageSize,
-				displayOptions,
-				jobChan,
-			)
-		case lastfm.MethodTrack:
-			err = collages.GetElementsForTrack(
-				ctx,
-				request.Username,
-				request.Period,
-				count,
-				imageSize,
-				displayOptions,
-				jobChan,
-			)
-		}
-		if err != nil {
-			logger.Error().Err(err).Msg("failed to fetch image data")
-		}
+		fetchErr = getElements(ctx, elementOptions, displayOptions, jobChan)
+		// keep the requested grid if the items were never fetched
+		fit(request.Rows, request.Columns)
 		close(jobChan)
 	}()
-	return collages.CreateCollage(ctx, displayOptions, jobChan)
+	if resizeGrid {
+		displayOptions = <-fitted
+		logger.Info().
+			Int("rows", displayOptions.Rows).
+			Int("columns", displayOptions.Columns).
+			Msg("Grid resized to fit items")
+	}
+	collage, buffer, err := collages.CreateCollage(ctx, displayOptions, jobChan)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the collage is only created once the job channel is closed, so the fetch
+	// has finished
+	if fetchErr != nil {
+		return nil, nil, fetchErr
+	}
+	return collage, buffer, nil
+}
+
+// tileSize returns the image size to fetch and the tile dimension for a grid of
+// rows by columns. Tiles fill the requested width or height, so the collage is
+// only resized once, otherwise smaller images are used for larger collages.
+func tileSize(request *CollageRequest, rows int, columns int, count int) (string, int) {
+	if (request.Width > 0 || request.Height > 0) && rows > 0 && columns > 0 {
+		width, height := int(request.Width), int(request.Height) // #nosec G115
+		dimension := max((width+columns-1)/columns, (height+rows-1)/rows)
+		return clients.ImageSizeForDimension(dimension), dimension
+	}
+
+	config := config.GetConfig()
+	size := clients.ImageSizeExtraLarge
+	switch {
+	case count > config.ImageSizeCutoffs.Medium:
+		size = clients.ImageSizeSmall
+	case count > config.ImageSizeCutoffs.Large:
+		size = clients.ImageSizeMedium
+	case count > config.ImageSizeCutoffs.ExtraLarge:
+		size = clients.ImageSizeLarge
+	}
+	return size, clients.ImageDimension(size)
+}
+
+func maxImagesForMethod(method lastfm.Method) int {
+	config := config.GetConfig()
+	switch method {
+	case lastfm.MethodAlbum:
+		return config.MaxImages.Albums
+	case lastfm.MethodArtist, lastfm.MethodTag:
+		return config.MaxImages.Artists
+	default:
+		return config.MaxImages.Tracks
+	}
+}
+
+// fitGrid returns the largest grid of at most limit tiles that n items fill
+// completely. Grids are kept close to square, with no more than twice as many
+// columns as rows.
+func fitGrid(n, limit int) (int, int) {
+	n = min(n, limit)
+	bestRows, bestColumns := 1, 1
+	for rows := 1; rows*rows <= n; rows++ {
+		columns := min(n/rows, 2*rows)
+		size, bestSize := rows*columns, bestRows*bestColumns
+		if size > bestSize || (size == bestSize && columns-rows < bestColumns-bestRows) {
+			bestRows, bestColumns = rows, columns
+		}
+	}
+	return bestRows, bestColumns
+}
+
+// shrinkGrid drops the rows, and for a single row the columns, that n items would
+// leave empty
+func shrinkGrid(n, rows, columns int) (int, int) {
+	if n <= 0 {
+		return rows, columns
+	}
+	rows = min(rows, (n+columns-1)/columns)
+	if rows == 1 {
+		columns = min(columns, n)
+	}
+	return rows, columns
 }
 
 func Collage(w http.ResponseWriter, r *http.Request) {
@@ -114,6 +243,9 @@ func Collage(w http.ResponseWriter, r *http.Request) {
 
 	logger.Info().
 		Str("username", request.Username).
+		Str("source", string(request.Source)).
+		Strs("usernames", request.Usernames).
+		Str("aggregate", string(request.Aggregation)).
 		Int("rows", request.Rows).
 		Int("columns", request.Columns).
 		Str("period", string(request.Period)).
@@ -128,8 +260,25 @@ func Collage(w http.ResponseWriter, r *http.Request) {
 		Bool("boldfont", request.BoldFont).
 		Bool("grayscale", request.Grayscale).
 		Bool("webp", request.Webp).
+		Bool("png", request.Png).
+		Uint("dpi", request.DPI).
+		Str("sort", string(request.Sort)).
+		Str("fit", string(request.Fit)).
+		Int("frames", len(request.Animate)).
+		Bool("dedupe", request.Dedupe).
+		Strs("exclude", request.Exclude).
+		Strs("onlyartist", request.OnlyArtists).
+		Str("tag", request.Tag).
+		Int("minplays", request.MinPlays).
+		Bool("autosize", request.AutoSize).
+		Bool("mergeeditions", request.MergeEditions).
 		Msg("Generating collage")
 
+	if len(request.Animate) > 0 {
+		animation(w, r, request)
+		return
+	}
+
 	image, buffer, err := generateCollage(ctx, request)
 	if ctx.Err() != nil {
 		logger.Warn().Err(ctx.Err()).Msg("Context cancelled")
@@ -138,54 +287,171 @@ func Collage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err != nil {
-		switch err {
-		case lastfm.ErrUserNotFound:
-			logger.Warn().Err(err).Str("username", request.Username).Msg("User not found")
-			http.Error(w, "User not found", http.StatusNotFound)
-		case lastfm.ErrTooManyImages:
-			logger.Warn().
-				Err(err).
-				Str("method", string(request.Method)).
-				Int("rows", request.Rows).
-				Int("columns", request.Columns).
-				Msg("Too many images requested for the collage type")
-			http.Error(
-				w,
-				"Requested collage size is too large for the collage type",
-				http.StatusBadRequest,
-			)
-		default:
-			logger.Error().Err(err).Msg("Error occurred generating collage")
+		writeCollageError(w, r, request, err)
+		return
+	}
+
+	writeCollage(w, r, image, buffer, request)
+}
+
+// writeImage serves the pre-encoded WebP buffer if requested, otherwise encodes the image as a JPEG
+func writeImage(
+	w http.ResponseWriter,
+	r *http.Request,
+	image image.Image,
+	buffer *bytes.Buffer,
+	webp bool,
+) {
+	if webp {
+		w.Header().Set("Content-Type", "image/webp")
+		w.Write(buffer.Bytes())
+	} else {
+		w.Header().Set("Content-Type", "image/jpeg")
+		err := jpeg.Encode(w, image, nil)
+		if err != nil {
+			zerolog.Ctx(r.Context()).Error().Err(err).Msg("Error occurred encoding collage")
 			http.Error(
 				w,
 				"An error occurred processing your request",
 				http.StatusInternalServerError,
 			)
+			return
 		}
-		return
 	}
+}
 
-	if ctx.Err() != nil {
-		logger.Warn().Err(ctx.Err()).Msg("Context cancelled")
-		// 499 is the http status code for client closed request
-		http.Error(w, "Context cancelled", 499)
-		return
+// writeCollageError maps an error from generating a collage to an HTTP response
+func writeCollageError(
+	w http.ResponseWriter,
+	r *http.Request,
+	request *CollageRequest,
+	err error,
+) {
+	logger := zerolog.Ctx(r.Context())
+	switch {
+	case errors.Is(err, lastfm.ErrUserNotFound), errors.Is(err, listenbrainz.ErrUserNotFound):
+		logger.Warn().Err(err).Str("username", request.Username).Msg("User not found")
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, lastfm.ErrTooManyImages):
+		logger.Warn().
+			Err(err).
+			Str("method", string(request.Method)).
+			Int("rows", request.Rows).
+			Int("columns", request.Columns).
+			Msg("Too many images requested for the collage type")
+		http.Error(
+			w,
+			"Requested collage size is too large for the collage type",
+			http.StatusBadRequest,
+		)
+	case errors.Is(err, collages.ErrCollageTooLarge):
+		logger.Warn().
+			Err(err).
+			Int("rows", request.Rows).
+			Int("columns", request.Columns).
+			Uint("width", request.Width).
+			Uint("height", request.Height).
+			Msg("Collage is over the pixel budget")
+		http.Error(w, "Requested collage size is too large to render", http.StatusBadRequest)
+	default:
+		if writeServiceError(w, logger, err) {
+			return
+		}
+		logger.Error().Err(err).Msg("Error occurred generating collage")
+		http.Error(
+			w,
+			"An error occurred processing your request",
+			http.StatusInternalServerError,
+		)
 	}
+}
+
+// seconds clients are asked to wait before retrying when rate limited or the
+// service is unavailable
+const retryAfter = "60"
 
-	if request.Webp && request.Grayscale {
-		request.Webp = false
+// writeServiceError writes the response for an error from the service providing
+// the listening history, returning false if the error isn't one of those errors
+func writeServiceError(w http.ResponseWriter, logger *zerolog.Logger, err error) bool {
+	switch {
+	case errors.Is(err, lastfm.ErrPrivateProfile):
+		logger.Warn().Err(err).Msg("User's listening history is private")
+		http.Error(w, "User's listening history is private", http.StatusForbidden)
+	case errors.Is(err, lastfm.ErrRateLimited):
+		logger.Warn().Err(err).Msg("Rate limited by the service")
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+	case errors.Is(err, lastfm.ErrServiceUnavailable):
+		logger.Warn().Err(err).Msg("Service unavailable")
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(
+			w,
+			"Last.fm is temporarily unavailable, try again later",
+			http.StatusServiceUnavailable,
+		)
+	case errors.Is(err, listenbrainz.ErrNoStatistics):
+		logger.Warn().Err(err).Msg("No ListenBrainz statistics")
+		http.Error(
+			w,
+			"ListenBrainz hasn't calculated statistics for the user yet",
+			http.StatusNotFound,
+		)
+	default:
+		return false
 	}
+	return true
+}
 
-	if request.Webp {
-		w.Header().Set("Content-Type", "image/webp")
-		w.Write(buffer.Bytes())
-	} else {
-		w.Header().Set("Content-Type", "image/jpeg")
-		err = jpeg.Encode(w, image, nil)
+// animation renders a collage for each requested period and serves them as the
+// frames of an animated GIF, or an animated WebP if requested. Frames are only
+// rendered for the Last.fm periods, a frame per week of a date range would need
+// the weekly chart endpoints and isn't supported.
+func animation(w http.ResponseWriter, r *http.Request, request *CollageRequest) {
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx)
+
+	frames := make([]image.Image, 0, len(request.Animate))
+	for _, period := range request.Animate {
+		frameRequest := *request
+		frameRequest.Period = period
+		// frames are encoded together once they have all been rendered
+		frameRequest.Webp = false
+		frame, _, err := generateCollage(ctx, &frameRequest)
+		if ctx.Err() != nil {
+			logger.Warn().Err(ctx.Err()).Msg("Context cancelled")
+			// 499 is the http status code for client closed request
+			http.Error(w, "Context cancelled", 499)
+			return
+		}
 		if err != nil {
-			logger.Error().Err(err).Msg("Error occurred encoding collage")
-			http.Error(w, "An error occurred processing your request", http.StatusInternalServerError)
+			writeCollageError(w, r, &frameRequest, err)
 			return
 		}
+		frames = append(frames, frame)
+	}
+
+	start := time.Now()
+	delay := time.Duration(request.FrameDelay) * time.Millisecond
+	buffer := new(bytes.Buffer)
+	contentType := "image/gif"
+	var err error
+	if request.Webp && !request.Grayscale {
+		contentType = "image/webp"
+		err = collages.EncodeAnimatedWebp(buffer, frames, delay, collages.CompressionQuality)
+	} else {
+		err = collages.EncodeAnimatedGif(buffer, frames, delay)
 	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Error occurred encoding animated collage")
+		http.Error(w, "An error occurred processing your request", http.StatusInternalServerError)
+		return
+	}
+	logger.Info().
+		Dur("duration", time.Since(start)).
+		Int("frames", len(frames)).
+		Str("contentType", contentType).
+		Msg("Animated collage encoded")
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buffer.Bytes())
 }
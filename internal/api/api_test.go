@@ -0,0 +1,130 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SongStitch/song-stitch/internal/clients"
+	"github.com/SongStitch/song-stitch/internal/clients/listenbrainz"
+)
+
+func TestTileSize(t *testing.T) {
+	testCases := map[string]struct {
+		request   CollageRequest
+		size      string
+		dimension int
+	}{
+		"fills width": {
+			request:   CollageRequest{Rows: 50, Columns: 50, Width: 3000},
+			size:      clients.ImageSizeMedium,
+			dimension: 60,
+		},
+		"fills height": {
+			request:   CollageRequest{Rows: 2, Columns: 4, Width: 400, Height: 1000},
+			size:      clients.ImageSizeHuge,
+			dimension: 500,
+		},
+		"rounds up": {
+			request:   CollageRequest{Rows: 3, Columns: 3, Width: 1000},
+			size:      clients.ImageSizeHuge,
+			dimension: 334,
+		},
+		"larger than any source": {
+			request:   CollageRequest{Rows: 1, Columns: 1, Width: 3000},
+			size:      clients.ImageSizeHuge,
+			dimension: 3000,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			request := tc.request
+			size, dimension := tileSize(
+				&request,
+				request.Rows,
+				request.Columns,
+				request.Rows*request.Columns,
+			)
+			if size != tc.size || dimension != tc.dimension {
+				t.Errorf(
+					"expected %s at %dpx, got %s at %dpx",
+					tc.size,
+					tc.dimension,
+					size,
+					dimension,
+				)
+			}
+		})
+	}
+}
+
+func TestFitGrid(t *testing.T) {
+	testCases := map[string]struct {
+		n, limit      int
+		rows, columns int
+	}{
+		"no items":           {n: 0, limit: 100, rows: 1, columns: 1},
+		"fewer than limit":   {n: 10, limit: 100, rows: 3, columns: 3},
+		"more than limit":    {n: 200, limit: 50, rows: 5, columns: 10},
+		"prime":              {n: 13, limit: 100, rows: 3, columns: 4},
+		"square":             {n: 16, limit: 100, rows: 4, columns: 4},
+		"twice as wide":      {n: 18, limit: 100, rows: 3, columns: 6},
+		"no more than twice": {n: 3, limit: 100, rows: 1, columns: 2},
+		"one item":           {n: 1, limit: 100, rows: 1, columns: 1},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			rows, columns := fitGrid(tc.n, tc.limit)
+			if rows != tc.rows || columns != tc.columns {
+				t.Errorf("expected %dx%d, got %dx%d", tc.rows, tc.columns, rows, columns)
+			}
+		})
+	}
+}
+
+func TestShrinkGrid(t *testing.T) {
+	testCases := map[string]struct {
+		n, rows, columns int
+		expectedRows     int
+		expectedColumns  int
+	}{
+		"no items":             {n: 0, rows: 3, columns: 3, expectedRows: 3, expectedColumns: 3},
+		"full":                 {n: 9, rows: 3, columns: 3, expectedRows: 3, expectedColumns: 3},
+		"more than the grid":   {n: 20, rows: 3, columns: 3, expectedRows: 3, expectedColumns: 3},
+		"last row partly full": {n: 7, rows: 3, columns: 3, expectedRows: 3, expectedColumns: 3},
+		"empty rows dropped":   {n: 5, rows: 4, columns: 4, expectedRows: 2, expectedColumns: 4},
+		"prime":                {n: 2, rows: 3, columns: 3, expectedRows: 1, expectedColumns: 2},
+		"wide single row":      {n: 3, rows: 2, columns: 8, expectedRows: 1, expectedColumns: 3},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			rows, columns := shrinkGrid(tc.n, tc.rows, tc.columns)
+			if rows != tc.expectedRows || columns != tc.expectedColumns {
+				t.Errorf(
+					"expected %dx%d, got %dx%d",
+					tc.expectedRows,
+					tc.expectedColumns,
+					rows,
+					columns,
+				)
+			}
+		})
+	}
+}
+
+func TestWriteCollageErrorListenBrainz(t *testing.T) {
+	testCases := map[error]int{
+		listenbrainz.ErrUserNotFound:                            http.StatusNotFound,
+		listenbrainz.ErrNoStatistics:                            http.StatusNotFound,
+		fmt.Errorf("wrapped: %w", listenbrainz.ErrNoStatistics): http.StatusNotFound,
+	}
+	for err, status := range testCases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/collage", nil)
+		writeCollageError(w, r, &CollageRequest{}, err)
+		if w.Code != status {
+			t.Errorf("%v: expected status %d, got %d", err, status, w.Code)
+		}
+	}
+}
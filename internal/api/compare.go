@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/collages"
+)
+
+func Compare(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("Received compare request")
+
+	request, err := ParseCompareQueryValues(r.URL.Query())
+	if err != nil {
+		logger.Warn().Err(err).Msg("Request was invalid")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Info().
+		Str("user1", request.Username1).
+		Str("user2", request.Username2).
+		Str("method", string(request.Method)).
+		Str("period", string(request.Period)).
+		Int("count", request.Count).
+		Int("rows", request.Rows).
+		Int("columns", request.Columns).
+		Msg("Generating comparison")
+
+	options := collages.CompareOptions{
+		Username1: request.Username1,
+		Username2: request.Username2,
+		Method:    request.Method,
+		Period:    request.Period,
+		Count:     request.Count,
+		Rows:      request.Rows,
+		Columns:   request.Columns,
+		FontSize:  float64(request.FontSize),
+		BoldFont:  request.BoldFont,
+		PlayCount: request.PlayCount,
+		Webp:      request.Webp,
+	}
+	collage, buffer, err := collages.CreateComparison(ctx, options)
+	if ctx.Err() != nil {
+		logger.Warn().Err(ctx.Err()).Msg("Context cancelled")
+		// 499 is the http status code for client closed request
+		http.Error(w, "Context cancelled", 499)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, lastfm.ErrUserNotFound):
+			logger.Warn().Err(err).Msg("User not found")
+			http.Error(w, "User not found", http.StatusNotFound)
+		case errors.Is(err, lastfm.ErrTooManyImages):
+			logger.Warn().Err(err).Int("count", request.Count).Msg("Too many items requested")
+			http.Error(
+				w,
+				"Requested count is too large for the collage type",
+				http.StatusBadRequest,
+			)
+		case errors.Is(err, collages.ErrCollageTooLarge):
+			logger.Warn().
+				Err(err).
+				Int("rows", request.Rows).
+				Int("columns", request.Columns).
+				Msg("Comparison is over the pixel budget")
+			http.Error(w, "Requested comparison size is too large to render", http.StatusBadRequest)
+		default:
+			if writeServiceError(w, logger, err) {
+				return
+			}
+			logger.Error().Err(err).Msg("Error occurred generating comparison")
+			http.Error(
+				w,
+				"An error occurred processing your request",
+				http.StatusInternalServerError,
+			)
+		}
+		return
+	}
+
+	writeImage(w, r, collage, buffer, request.Webp)
+}
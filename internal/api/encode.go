@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+// the image encoders write the segments or chunks the density belongs in right
+// after the header, which is always the same length
+const (
+	// the start of image marker
+	jpegHeaderLength = 2
+	// the signature and the IHDR chunk
+	pngHeaderLength = 8 + 25
+)
+
+// collageContentType returns the content type a collage is served as
+func collageContentType(request *CollageRequest) string {
+	switch {
+	case request.Webp && !request.Grayscale:
+		return "image/webp"
+	case request.Png:
+		return "image/png"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// encodeCollage encodes the collage as a PNG or JPEG, with the DPI if one was
+// requested so the collage is printed at the right size
+func encodeCollage(w io.Writer, img image.Image, request *CollageRequest) error {
+	if request.Png {
+		if request.DPI > 0 {
+			w = &insertWriter{w: w, at: pngHeaderLength, insert: pngDensity(request.DPI)}
+		}
+		return png.Encode(w, img)
+	}
+	if request.DPI > 0 {
+		w = &insertWriter{w: w, at: jpegHeaderLength, insert: jfifDensity(request.DPI)}
+	}
+	return jpeg.Encode(w, img, nil)
+}
+
+// writeCollage serves the collage in the format of the request
+func writeCollage(
+	w http.ResponseWriter,
+	r *http.Request,
+	img image.Image,
+	buffer *bytes.Buffer,
+	request *CollageRequest,
+) {
+	contentType := collageContentType(request)
+	if contentType == "image/webp" {
+		writeImage(w, r, img, buffer, true)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if err := encodeCollage(w, img, request); err != nil {
+		zerolog.Ctx(r.Context()).Error().Err(err).Msg("Error occurred encoding collage")
+		http.Error(
+			w,
+			"An error occurred processing your request",
+			http.StatusInternalServerError,
+		)
+	}
+}
+
+// jfifDensity returns a JFIF APP0 segment with the density in dots per inch
+func jfifDensity(dpi uint) []byte {
+	density := uint16(min(dpi, math.MaxUint16)) // #nosec G115
+	segment := []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 1}
+	segment = binary.BigEndian.AppendUint16(segment, density)
+	segment = binary.BigEndian.AppendUint16(segment, density)
+	// no thumbnail
+	return append(segment, 0, 0)
+}
+
+// pngDensity returns a pHYs chunk with the density, which PNG stores in pixels
+// per metre
+func pngDensity(dpi uint) []byte {
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+	chunk := binary.BigEndian.AppendUint32(nil, 9)
+	chunk = append(chunk, 'p', 'H', 'Y', 's')
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	// the unit is the metre
+	chunk = append(chunk, 1)
+	// the checksum covers the chunk type and data
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// insertWriter writes insert once at bytes into the stream
+type insertWriter struct {
+	w       io.Writer
+	at      int
+	insert  []byte
+	written int
+}
+
+func (iw *insertWriter) Write(p []byte) (int, error) {
+	if iw.insert == nil {
+		return iw.w.Write(p)
+	}
+	head := min(iw.at-iw.written, len(p))
+	n, err := iw.w.Write(p[:head])
+	iw.written += n
+	if err != nil || iw.written < iw.at {
+		return n, err
+	}
+	if _, err := iw.w.Write(iw.insert); err != nil {
+		return n, err
+	}
+	iw.insert = nil
+	m, err := iw.w.Write(p[head:])
+	return n + m, err
+}
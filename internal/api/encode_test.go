@@ -0,0 +1,63 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// oneByteWriter writes a byte at a time, to check the density is inserted at
+// the right place however the encoder writes
+type oneByteWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *oneByteWriter) Write(p []byte) (int, error) {
+	for i := range p {
+		w.buf.WriteByte(p[i])
+	}
+	return len(p), nil
+}
+
+func TestEncodeCollageDPI(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+
+	t.Run("jpeg", func(t *testing.T) {
+		w := &oneByteWriter{}
+		if err := encodeCollage(w, img, &CollageRequest{DPI: 300}); err != nil {
+			t.Fatalf("unable to encode collage: %v", err)
+		}
+		data := w.buf.Bytes()
+		if !bytes.Equal(data[2:4], []byte{0xff, 0xe0}) || string(data[6:11]) != "JFIF\x00" {
+			t.Fatalf("expected a JFIF segment after the start of image, got % x", data[:20])
+		}
+		if unit, x := data[13], binary.BigEndian.Uint16(data[14:]); unit != 1 || x != 300 {
+			t.Errorf("expected 300 dots per inch, got %d in unit %d", x, unit)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("unable to decode collage: %v", err)
+		}
+	})
+
+	t.Run("png", func(t *testing.T) {
+		w := &oneByteWriter{}
+		if err := encodeCollage(w, img, &CollageRequest{Png: true, DPI: 300}); err != nil {
+			t.Fatalf("unable to encode collage: %v", err)
+		}
+		data := w.buf.Bytes()
+		if string(data[37:41]) != "pHYs" {
+			t.Fatalf("expected a pHYs chunk after the IHDR chunk, got % x", data[33:50])
+		}
+		// 300 dots per inch is 11811 pixels per metre
+		if x := binary.BigEndian.Uint32(data[41:]); x != 11811 {
+			t.Errorf("expected 11811 pixels per metre, got %d", x)
+		}
+		// the decoder checks the checksum of every chunk
+		if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("unable to decode collage: %v", err)
+		}
+	})
+}
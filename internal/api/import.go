@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/collages"
+	"github.com/SongStitch/song-stitch/internal/scrobbles"
+)
+
+// maximum size of an uploaded scrobble export, a decade of Spotify history is
+// around 50MB
+const maxImportSize = 64 << 20
+
+// readUploadedScrobbles parses the scrobble export from either a multipart form
+// field named "file" or the raw request body
+func readUploadedScrobbles(
+	w http.ResponseWriter,
+	r *http.Request,
+) ([]scrobbles.Scrobble, scrobbles.Format, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", err
+		}
+		defer file.Close()
+		body = file
+	}
+	return scrobbles.Parse(body)
+}
+
+// ImportCollage generates a collage from an uploaded scrobble export rather than
+// the user's Last.fm history
+func ImportCollage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("Received import request")
+
+	request, err := ParseImportQueryValues(r.URL.Query())
+	if err != nil {
+		logger.Warn().Err(err).Msg("Request was invalid")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	history, format, err := readUploadedScrobbles(w, r)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Uploaded scrobbles were invalid")
+		http.Error(w, "Unable to read the uploaded scrobbles", http.StatusBadRequest)
+		return
+	}
+	total := len(history)
+	history = scrobbles.InRange(history, request.From, request.To)
+	if len(history) == 0 {
+		logger.Warn().Int("total", total).Msg("No scrobbles in the requested range")
+		http.Error(w, "No scrobbles found in the requested range", http.StatusBadRequest)
+		return
+	}
+
+	logger.Info().
+		Str("format", string(format)).
+		Int("scrobbles", len(history)).
+		Int("total", total).
+		Time("from", request.From).
+		Time("to", request.To).
+		Int("rows", request.Rows).
+		Int("columns", request.Columns).
+		Str("method", string(request.Method)).
+		Str("sort", string(request.Sort)).
+		Strs("exclude", request.Exclude).
+		Strs("onlyartist", request.OnlyArtists).
+		Str("tag", request.Tag).
+		Int("minplays", request.MinPlays).
+		Bool("autosize", request.AutoSize).
+		Bool("mergeeditions", request.MergeEditions).
+		Msg("Generating imported collage")
+
+	getElements := func(
+		ctx context.Context,
+		options collages.ElementOptions,
+		_ collages.DisplayOptions,
+		jobChan chan<- collages.CollageElement,
+	) error {
+		return collages.GetElementsForImport(ctx, history, request.Method, options, jobChan)
+	}
+	image, buffer, err := generateCollageFrom(ctx, &request.CollageRequest, getElements)
+	if ctx.Err() != nil {
+		logger.Warn().Err(ctx.Err()).Msg("Context cancelled")
+		// 499 is the http status code for client closed request
+		http.Error(w, "Context cancelled", 499)
+		return
+	}
+	if err != nil {
+		writeCollageError(w, r, &request.CollageRequest, err)
+		return
+	}
+
+	writeCollage(w, r, image, buffer, &request.CollageRequest)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/clients/listenbrainz"
+	"github.com/SongStitch/song-stitch/internal/collages"
+	"github.com/SongStitch/song-stitch/internal/jobs"
+)
+
+// jobTimeout bounds a queued collage, which isn't cancelled by a client leaving
+const jobTimeout = 5 * time.Minute
+
+// SubmitJob validates the collage request and queues it, for collages that take
+// longer than a client should wait on a single request. The parameters are the
+// same as for a collage, in the query or a form body.
+func SubmitJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("Received job request")
+
+	if err := r.ParseForm(); err != nil {
+		logger.Warn().Err(err).Msg("Request was invalid")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request, err := ParseQueryValues(r.Form)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Request was invalid")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.Animate) > 0 {
+		http.Error(w, "animated collages can't be queued", http.StatusBadRequest)
+		return
+	}
+
+	id, err := jobs.GetQueue().Submit(ctx, func(ctx context.Context) (jobs.Result, error) {
+		ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+		defer cancel()
+		return collageResult(ctx, request)
+	})
+	if err != nil {
+		logger.Warn().Err(err).Msg("Job queue is full")
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, "Too many collages queued, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	logger.Info().
+		Str("job", id).
+		Str("username", request.Username).
+		Int("rows", request.Rows).
+		Int("columns", request.Columns).
+		Str("method", string(request.Method)).
+		Msg("Collage queued")
+
+	job, _ := jobs.GetQueue().Get(id)
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, r, http.StatusAccepted, job)
+}
+
+// collageResult generates the collage and encodes it as it would be served
+func collageResult(ctx context.Context, request *CollageRequest) (jobs.Result, error) {
+	image, buffer, err := generateCollage(ctx, request)
+	if err != nil {
+		return jobs.Result{}, err
+	}
+	contentType := collageContentType(request)
+	if contentType == "image/webp" {
+		return jobs.Result{ContentType: contentType, Data: buffer.Bytes()}, nil
+	}
+	encoded := new(bytes.Buffer)
+	if err := encodeCollage(encoded, image, request); err != nil {
+		return jobs.Result{}, err
+	}
+	return jobs.Result{ContentType: contentType, Data: encoded.Bytes()}, nil
+}
+
+// JobStatus reports whether the job is queued, running, done or failed
+func JobStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.GetQueue().Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, job)
+}
+
+// JobResult serves the collage of a finished job, or the error it failed with
+func JobResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.GetQueue().Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	switch job.Status {
+	case jobs.StatusDone:
+		w.Header().Set("Content-Type", job.Result.ContentType)
+		w.Write(job.Result.Data)
+	case jobs.StatusFailed:
+		writeJobError(w, r, job)
+	default:
+		http.Error(w, "Job has not finished", http.StatusConflict)
+	}
+}
+
+// writeJobError writes the response for a failed job. The request isn't kept with
+// the job, so failures are logged with the job ID, which was logged with the
+// request when it was queued.
+func writeJobError(w http.ResponseWriter, r *http.Request, job jobs.Job) {
+	logger := zerolog.Ctx(r.Context()).With().Str("job", job.ID).Logger()
+	err := job.Err
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		logger.Warn().Err(err).Msg("Job timed out")
+		http.Error(w, "The collage took too long to generate", http.StatusGatewayTimeout)
+	case errors.Is(err, lastfm.ErrUserNotFound), errors.Is(err, listenbrainz.ErrUserNotFound):
+		logger.Warn().Err(err).Msg("User not found")
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, lastfm.ErrTooManyImages):
+		logger.Warn().Err(err).Msg("Too many images requested for the collage type")
+		http.Error(
+			w,
+			"Requested collage size is too large for the collage type",
+			http.StatusBadRequest,
+		)
+	case errors.Is(err, collages.ErrCollageTooLarge):
+		logger.Warn().Err(err).Msg("Collage is over the pixel budget")
+		http.Error(w, "Requested collage size is too large to render", http.StatusBadRequest)
+	default:
+		if writeServiceError(w, &logger, err) {
+			return
+		}
+		logger.Error().Err(err).Msg("Error occurred generating collage")
+		http.Error(
+			w,
+			"An error occurred processing your request",
+			http.StatusInternalServerError,
+		)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zerolog.Ctx(r.Context()).Error().Err(err).Msg("Error occurred encoding response")
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/jobs"
+)
+
+// finishedJob runs the work on a new job queue and waits for it to finish
+func finishedJob(t *testing.T, work jobs.Work) string {
+	t.Helper()
+	jobs.Init(1, 1, time.Minute, 1024)
+	id, err := jobs.GetQueue().Submit(context.Background(), work)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if job, ok := jobs.GetQueue().Wait(ctx, id); !ok || job.Finished.IsZero() {
+		t.Fatalf("expected job %s to finish, got %+v", id, job)
+	}
+	return id
+}
+
+func failingJob(err error) jobs.Work {
+	return func(ctx context.Context) (jobs.Result, error) {
+		return jobs.Result{}, err
+	}
+}
+
+func jobRequest(path string, id string) *http.Request {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.SetPathValue("id", id)
+	return request
+}
+
+func TestJobStatus(t *testing.T) {
+	id := finishedJob(t, failingJob(lastfm.ErrUserNotFound))
+
+	w := httptest.NewRecorder()
+	JobStatus(w, jobRequest("/jobs/"+id, id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var job jobs.Job
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if job.ID != id || job.Status != jobs.StatusFailed {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if job.Error != lastfm.ErrUserNotFound.Error() {
+		t.Errorf("expected error %q, got %q", lastfm.ErrUserNotFound, job.Error)
+	}
+
+	w = httptest.NewRecorder()
+	JobStatus(w, jobRequest("/jobs/missing", "missing"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestJobResult(t *testing.T) {
+	testCases := map[string]struct {
+		work        jobs.Work
+		status      int
+		contentType string
+		body        string
+	}{
+		"done": {
+			work: func(ctx context.Context) (jobs.Result, error) {
+				return jobs.Result{ContentType: "image/png", Data: []byte("collage")}, nil
+			},
+			status:      http.StatusOK,
+			contentType: "image/png",
+			body:        "collage",
+		},
+		"timed out": {
+			work:   failingJob(context.DeadlineExceeded),
+			status: http.StatusGatewayTimeout,
+		},
+		"user not found": {
+			work:   failingJob(lastfm.ErrUserNotFound),
+			status: http.StatusNotFound,
+			body:   "User not found",
+		},
+		"too many images": {
+			work:   failingJob(lastfm.ErrTooManyImages),
+			status: http.StatusBadRequest,
+			body:   "too large for the collage type",
+		},
+		"rate limited": {
+			work:   failingJob(lastfm.ErrRateLimited),
+			status: http.StatusTooManyRequests,
+		},
+		"failed": {
+			work:   failingJob(errors.New("failed")),
+			status: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			id := finishedJob(t, tc.work)
+			w := httptest.NewRecorder()
+			JobResult(w, jobRequest("/jobs/"+id+"/result", id))
+			if w.Code != tc.status {
+				t.Errorf("expected status %d, got %d", tc.status, w.Code)
+			}
+			if tc.contentType != "" && w.Header().Get("Content-Type") != tc.contentType {
+				t.Errorf("expected %s, got %s", tc.contentType, w.Header().Get("Content-Type"))
+			}
+			if !strings.Contains(w.Body.String(), tc.body) {
+				t.Errorf("expected body %q, got %q", tc.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestJobResultUnfinished(t *testing.T) {
+	jobs.Init(1, 1, time.Minute, 1024)
+	release := make(chan struct{})
+	defer close(release)
+	id, err := jobs.GetQueue().Submit(
+		context.Background(),
+		func(ctx context.Context) (jobs.Result, error) {
+			<-release
+			return jobs.Result{}, nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	JobResult(w, jobRequest("/jobs/"+id+"/result", id))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestSubmitJob(t *testing.T) {
+	// the only worker is kept busy and the queue is full behind it, so no collage
+	// is ever generated
+	jobs.Init(1, 1, time.Minute, 1024)
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	defer close(release)
+	block := func(ctx context.Context) (jobs.Result, error) {
+		started <- struct{}{}
+		<-release
+		return jobs.Result{}, nil
+	}
+	if _, err := jobs.GetQueue().Submit(context.Background(), block); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-started
+	if _, err := jobs.GetQueue().Submit(context.Background(), block); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := map[string]struct {
+		query  string
+		status int
+	}{
+		"invalid":  {query: "username=user&method=invalid", status: http.StatusBadRequest},
+		"animated": {query: "username=user&animate=7day,1month", status: http.StatusBadRequest},
+		"queue full": {
+			query:  "username=user&rows=3&columns=3",
+			status: http.StatusServiceUnavailable,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			SubmitJob(w, httptest.NewRequest(http.MethodPost, "/jobs?"+tc.query, nil))
+			if w.Code != tc.status {
+				t.Errorf("expected status %d, got %d: %s", tc.status, w.Code, w.Body)
+			}
+			if tc.status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+				t.Error("expected a Retry-After header")
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/collages"
+)
+
+// maximum size of an uploaded mosaic target image
+const maxUploadSize = 10 << 20
+
+// maximum pixels of an uploaded mosaic target image once decoded, as a small
+// compressed image can declare a huge size
+const maxUploadPixels = 40_000_000
+
+var ErrUploadTooLarge = errors.New("uploaded image is too large")
+
+// readUploadedImage decodes the target image from either a multipart form
+// field named "image" or the raw request body
+func readUploadedImage(w http.ResponseWriter, r *http.Request) (image.Image, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return decodeUploadedImage(file)
+	}
+	return decodeUploadedImage(r.Body)
+}
+
+// decodeUploadedImage decodes the image if its declared size is within the
+// pixel limit
+func decodeUploadedImage(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 ||
+		config.Width > maxUploadPixels/config.Height {
+		return nil, ErrUploadTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+func Mosaic(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("Received mosaic request")
+
+	request, err := ParseMosaicQueryValues(r.URL.Query())
+	if err != nil {
+		logger.Warn().Err(err).Msg("Request was invalid")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var target image.Image
+	if r.Method == http.MethodPost {
+		target, err = readUploadedImage(w, r)
+		if errors.Is(err, ErrUploadTooLarge) {
+			logger.Warn().Err(err).Msg("Uploaded image was too large")
+			http.Error(w, "The uploaded image is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			logger.Warn().Err(err).Msg("Uploaded image was invalid")
+			http.Error(w, "Unable to read the uploaded image", http.StatusBadRequest)
+			return
+		}
+	} else {
+		target, err = collages.GetAvatar(ctx, request.Username)
+		if err != nil {
+			switch {
+			case errors.Is(err, lastfm.ErrUserNotFound):
+				logger.Warn().Err(err).Str("username", request.Username).Msg("User not found")
+				http.Error(w, "User not found", http.StatusNotFound)
+			case errors.Is(err, collages.ErrNoAvatar):
+				logger.Warn().Err(err).Str("username", request.Username).Msg("User has no avatar")
+				http.Error(
+					w,
+					"User has no avatar, upload an image to use instead",
+					http.StatusBadRequest,
+				)
+			default:
+				if writeServiceError(w, logger, err) {
+					return
+				}
+				logger.Error().Err(err).Msg("Error occurred fetching avatar")
+				http.Error(
+					w,
+					"An error occurred processing your request",
+					http.StatusInternalServerError,
+				)
+			}
+			return
+		}
+	}
+
+	logger.Info().
+		Str("username", request.Username).
+		Str("period", string(request.Period)).
+		Int("count", request.Count).
+		Int("rows", request.Rows).
+		Int("columns", request.Columns).
+		Int("tilesize", request.TileSize).
+		Int("blend", request.Blend).
+		Bool("upload", r.Method == http.MethodPost).
+		Msg("Generating mosaic")
+
+	options := collages.MosaicOptions{
+		Username:      request.Username,
+		Period:        request.Period,
+		Count:         request.Count,
+		Rows:          request.Rows,
+		Columns:       request.Columns,
+		TileDimension: request.TileSize,
+		Blend:         request.Blend,
+		Width:         request.Width,
+		Height:        request.Height,
+		Grayscale:     request.Grayscale,
+		Webp:          request.Webp,
+	}
+	mosaic, buffer, err := collages.CreateMosaic(ctx, target, options)
+	if ctx.Err() != nil {
+		logger.Warn().Err(ctx.Err()).Msg("Context cancelled")
+		// 499 is the http status code for client closed request
+		http.Error(w, "Context cancelled", 499)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, lastfm.ErrUserNotFound):
+			logger.Warn().Err(err).Str("username", request.Username).Msg("User not found")
+			http.Error(w, "User not found", http.StatusNotFound)
+		case errors.Is(err, lastfm.ErrTooManyImages):
+			logger.Warn().Err(err).Int("count", request.Count).Msg("Too many covers requested")
+			http.Error(w, "Requested cover count is too large", http.StatusBadRequest)
+		case errors.Is(err, collages.ErrNoMosaicTiles):
+			logger.Warn().Err(err).Str("username", request.Username).Msg("No covers for mosaic")
+			http.Error(w, "No album covers found for the user", http.StatusNotFound)
+		case errors.Is(err, collages.ErrCollageTooLarge):
+			logger.Warn().
+				Err(err).
+				Int("rows", request.Rows).
+				Int("columns", request.Columns).
+				Int("tilesize", request.TileSize).
+				Msg("Mosaic is over the pixel budget")
+			http.Error(w, "Requested mosaic size is too large to render", http.StatusBadRequest)
+		default:
+			if writeServiceError(w, logger, err) {
+				return
+			}
+			logger.Error().Err(err).Msg("Error occurred generating mosaic")
+			http.Error(
+				w,
+				"An error occurred processing your request",
+				http.StatusInternalServerError,
+			)
+		}
+		return
+	}
+
+	writeImage(w, r, mosaic, buffer, request.Webp && !request.Grayscale)
+}
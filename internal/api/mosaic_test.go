@@ -0,0 +1,39 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngDeclaring returns a small PNG whose header declares the width and height
+func pngDeclaring(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("unable to encode png: %v", err)
+	}
+	data := buf.Bytes()
+	// the IHDR chunk follows the 8 byte signature, its data after the length and type
+	ihdr := data[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	binary.BigEndian.PutUint32(data[8+8+13:], crc32.ChecksumIEEE(data[8+4:8+8+13]))
+	return data
+}
+
+func TestDecodeUploadedImage(t *testing.T) {
+	if _, err := decodeUploadedImage(bytes.NewReader(pngDeclaring(t, 4, 4))); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err := decodeUploadedImage(bytes.NewReader(pngDeclaring(t, 50000, 50000)))
+	if err != ErrUploadTooLarge {
+		t.Errorf("expected %v, got %v", ErrUploadTooLarge, err)
+	}
+	if _, err := decodeUploadedImage(bytes.NewReader([]byte("not an image"))); err == nil {
+		t.Error("expected an error decoding an invalid image")
+	}
+}
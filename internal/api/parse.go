@@ -4,17 +4,35 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/collages"
+	"github.com/SongStitch/song-stitch/internal/config"
 )
 
 type CollageRequest struct {
 	Method        lastfm.Method
+	Source        collages.Source
 	TextLocation  lastfm.TextLocation
 	Username      string
+	Usernames     []string
+	Aggregation   collages.Aggregation
 	Period        lastfm.Period
+	Sort          collages.SortOrder
+	Fit           collages.FitMode
+	Animate       []lastfm.Period
+	FrameDelay    int
+	Dedupe        bool
+	Exclude       []string
+	OnlyArtists   []string
+	Tag           string
+	MinPlays      int
+	AutoSize      bool
+	MergeEditions bool
 	Height        uint
 	Width         uint
 	Rows          int
@@ -27,10 +45,54 @@ type CollageRequest struct {
 	BoldFont      bool
 	Grayscale     bool
 	Webp          bool
+	Png           bool
+	// dots per inch written into a JPEG or PNG collage, zero leaves it out
+	DPI uint
 }
 
 var ErrInvalidValue = errors.New("invalid value")
 
+// maximum number of users that can be combined into a group collage
+const maxGroupUsernames = 10
+
+// minimum playcount used by autosize when minplays isn't given
+const defaultAutoSizeMinPlays = 2
+
+// maximum number of frames in an animated collage, each is a full collage
+const maxAnimateFrames = 6
+
+// maximum number of values in a filter list
+const maxFilterValues = 50
+
+// maximum dots per inch written into a collage
+const maxDPI = 2400
+
+// maxDimension returns the largest width or height a collage can be requested at
+func maxDimension() uint64 {
+	if cfg := config.GetConfig(); cfg != nil && cfg.Render.MaxDimension > 0 {
+		return uint64(cfg.Render.MaxDimension)
+	}
+	return 3000
+}
+
+// parseList splits a comma separated list, dropping empty values
+func parseList(value string, max int) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	values := []string{}
+	for v := range strings.SplitSeq(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) > max {
+		return nil, fmt.Errorf("at most %d values can be given: %w", max, ErrInvalidValue)
+	}
+	return values, nil
+}
+
 func parseIntWithDefault(value string, d int) (int, error) {
 	if value == "" {
 		return d, nil
@@ -86,10 +148,9 @@ func parseBoolWithDefault(value string, d bool) (bool, error) {
 	}
 }
 
-func ParseQueryValues(query url.Values) (*CollageRequest, error) {
-	params := &CollageRequest{}
-
-	// Convert keys to lowercase as they should be case insensitive
+// normaliseQuery converts keys to lowercase as they should be case insensitive,
+// and keeps only the first trimmed value for each key
+func normaliseQuery(query url.Values) url.Values {
 	q := make(url.Values, len(query))
 	for key, value := range query {
 		k := strings.ToLower(key)
@@ -99,6 +160,18 @@ func ParseQueryValues(query url.Values) (*CollageRequest, error) {
 			q.Set(k, strings.TrimSpace(value[0]))
 		}
 	}
+	return q
+}
+
+func ParseQueryValues(query url.Values) (*CollageRequest, error) {
+	return parseQueryValues(query, true)
+}
+
+// parseQueryValues parses the collage options, the username is only optional for
+// collages of imported scrobbles
+func parseQueryValues(query url.Values, requireUsername bool) (*CollageRequest, error) {
+	params := &CollageRequest{}
+	q := normaliseQuery(query)
 
 	{
 		method := q.Get("method")
@@ -113,6 +186,28 @@ func ParseQueryValues(query url.Values) (*CollageRequest, error) {
 		}
 	}
 
+	{
+		source := q.Get("source")
+		if source == "" {
+			params.Source = collages.SourceLastfm
+		} else {
+			source, err := collages.GetSourceFromStr(source)
+			if err != nil {
+				return nil, err
+			}
+			params.Source = source
+		}
+		// scrobbles and loved tracks are only fetched from Last.fm
+		if params.Source != collages.SourceLastfm && params.Method.IsTimeline() {
+			return nil, fmt.Errorf(
+				"method %s isn't supported with source %s: %w",
+				params.Method,
+				params.Source,
+				lastfm.ErrInvalidMethod,
+			)
+		}
+	}
+
 	{
 		textLocation := q.Get("textlocation")
 		if textLocation == "" {
@@ -126,14 +221,52 @@ func ParseQueryValues(query url.Values) (*CollageRequest, error) {
 		}
 	}
 
+	{
+		usernames := q.Get("usernames")
+		if usernames != "" {
+			for username := range strings.SplitSeq(usernames, ",") {
+				username = strings.TrimSpace(username)
+				if username == "" {
+					continue
+				}
+				if !slices.Contains(params.Usernames, username) {
+					params.Usernames = append(params.Usernames, username)
+				}
+			}
+			if len(params.Usernames) > maxGroupUsernames {
+				return nil, fmt.Errorf(
+					"at most %d usernames can be combined: %w",
+					maxGroupUsernames,
+					ErrInvalidValue,
+				)
+			}
+		}
+	}
+
 	{
 		username := q.Get("username")
-		if username == "" {
+		if requireUsername && username == "" && len(params.Usernames) == 0 {
 			return nil, errors.New("username is required")
 		}
+		if username == "" {
+			username = strings.Join(params.Usernames, ",")
+		}
 		params.Username = username
 	}
 
+	{
+		aggregate := q.Get("aggregate")
+		if aggregate == "" {
+			params.Aggregation = collages.AggregationSum
+		} else {
+			aggregation, err := collages.GetAggregationFromStr(aggregate)
+			if err != nil {
+				return nil, err
+			}
+			params.Aggregation = aggregation
+		}
+	}
+
 	{
 		period := q.Get("period")
 		if period == "" {
@@ -147,9 +280,165 @@ func ParseQueryValues(query url.Values) (*CollageRequest, error) {
 		}
 	}
 
+	{
+		sort := q.Get("sort")
+		if sort == "" {
+			params.Sort = collages.SortRank
+		} else {
+			sort, err := collages.GetSortOrderFromStr(sort)
+			if err != nil {
+				return nil, err
+			}
+			params.Sort = sort
+		}
+	}
+
+	{
+		fit := q.Get("fit")
+		if fit == "" {
+			params.Fit = collages.FitContain
+		} else {
+			fit, err := collages.GetFitModeFromStr(fit)
+			if err != nil {
+				return nil, err
+			}
+			params.Fit = fit
+		}
+	}
+
+	{
+		animate := q.Get("animate")
+		if animate != "" {
+			for p := range strings.SplitSeq(animate, ",") {
+				period, err := lastfm.GetPeriodFromStr(strings.TrimSpace(p))
+				if err != nil {
+					return nil, fmt.Errorf("invalid animate: %w", err)
+				}
+				if slices.Contains(params.Animate, period) {
+					return nil, fmt.Errorf(
+						"animate period %s is repeated: %w",
+						period,
+						ErrInvalidValue,
+					)
+				}
+				params.Animate = append(params.Animate, period)
+			}
+			if len(params.Animate) > maxAnimateFrames {
+				return nil, fmt.Errorf(
+					"at most %d animate periods can be given: %w",
+					maxAnimateFrames,
+					ErrInvalidValue,
+				)
+			}
+		}
+	}
+
+	{
+		minPlays := q.Get("minplays")
+		value, err := parseIntWithDefaultAndRange(minPlays, 0, 0, 1000000)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minplays: %w", err)
+		}
+		params.MinPlays = value
+	}
+
+	{
+		autoSize := q.Get("autosize")
+		value, err := parseBoolWithDefault(autoSize, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid autosize: %w", err)
+		}
+		params.AutoSize = value
+		// without a threshold the grid would be padded with albums played once
+		if value && params.MinPlays == 0 {
+			params.MinPlays = defaultAutoSizeMinPlays
+		}
+	}
+
+	// every frame of an animation must have the same grid
+	if len(params.Animate) > 0 && (params.MinPlays > 0 || params.AutoSize) {
+		return nil, fmt.Errorf(
+			"minplays and autosize can't be used with animate: %w",
+			ErrInvalidValue,
+		)
+	}
+
+	if params.Method.IsTimeline() {
+		if params.MinPlays > 0 || params.AutoSize {
+			return nil, fmt.Errorf(
+				"minplays and autosize can't be used with method %s: %w",
+				params.Method,
+				ErrInvalidValue,
+			)
+		}
+		if len(params.Usernames) > 0 {
+			return nil, fmt.Errorf(
+				"usernames can't be combined for method %s: %w",
+				params.Method,
+				ErrInvalidValue,
+			)
+		}
+		if len(params.Animate) > 0 {
+			return nil, fmt.Errorf(
+				"animate can't be used with method %s: %w",
+				params.Method,
+				ErrInvalidValue,
+			)
+		}
+	}
+
+	{
+		exclude := q.Get("exclude")
+		values, err := parseList(exclude, maxFilterValues)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude: %w", err)
+		}
+		params.Exclude = values
+	}
+
+	{
+		onlyArtist := q.Get("onlyartist")
+		values, err := parseList(onlyArtist, maxFilterValues)
+		if err != nil {
+			return nil, fmt.Errorf("invalid onlyartist: %w", err)
+		}
+		params.OnlyArtists = values
+	}
+
+	{
+		params.Tag = q.Get("tag")
+	}
+
+	{
+		mergeEditions := q.Get("mergeeditions")
+		value, err := parseBoolWithDefault(mergeEditions, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mergeeditions: %w", err)
+		}
+		params.MergeEditions = value
+	}
+
+	{
+		dedupe := q.Get("dedupe")
+		value, err := parseBoolWithDefault(dedupe, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dedupe: %w", err)
+		}
+		params.Dedupe = value
+	}
+
+	{
+		delay := q.Get("delay")
+		value, err := parseIntWithDefaultAndRange(delay, 2000, 100, 10000)
+		if err != nil {
+			return nil, fmt.Errorf("invalid delay: %w", err)
+		}
+		params.FrameDelay = value
+	}
+
 	{
 		height := q.Get("height")
-		value, err := parseUintWithDefaultAndRange(height, 0, 0, 3000)
+		value, err := parseUintWithDefaultAndRange(height, 0, 0, maxDimension())
 		if err != nil {
 			return nil, fmt.Errorf("invalid height: %w", err)
 		}
@@ -158,7 +447,7 @@ func ParseQueryValues(query url.Values) (*CollageRequest, error) {
 
 	{
 		width := q.Get("width")
-		value, err := parseUintWithDefaultAndRange(width, 0, 0, 3000)
+		value, err := parseUintWithDefaultAndRange(width, 0, 0, maxDimension())
 		if err != nil {
 			return nil, fmt.Errorf("invalid width: %w", err)
 		}
@@ -255,5 +544,392 @@ func ParseQueryValues(query url.Values) (*CollageRequest, error) {
 		params.Webp = value
 	}
 
+	{
+		png := q.Get("png")
+		value, err := parseBoolWithDefault(png, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid png: %w", err)
+		}
+		if value && params.Webp {
+			return nil, fmt.Errorf("png and webp can't both be used: %w", ErrInvalidValue)
+		}
+		params.Png = value
+	}
+
+	{
+		dpi := q.Get("dpi")
+		value, err := parseUintWithDefaultAndRange(dpi, 0, 1, maxDPI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dpi: %w", err)
+		}
+		params.DPI = value
+	}
+
+	return params, nil
+}
+
+type ImportRequest struct {
+	CollageRequest
+	// scrobbles are kept from within [From, To), a zero time leaves that end open
+	From time.Time
+	To   time.Time
+}
+
+const importDateLayout = "2006-01-02"
+
+func ParseImportQueryValues(query url.Values) (*ImportRequest, error) {
+	request, err := parseQueryValues(query, false)
+	if err != nil {
+		return nil, err
+	}
+	params := &ImportRequest{CollageRequest: *request}
+	q := normaliseQuery(query)
+
+	switch params.Method {
+	case lastfm.MethodAlbum, lastfm.MethodArtist, lastfm.MethodTrack:
+	default:
+		return nil, fmt.Errorf(
+			"method must be album, artist or track: %w",
+			lastfm.ErrInvalidMethod,
+		)
+	}
+	if len(params.Usernames) > 0 || len(params.Animate) > 0 {
+		return nil, fmt.Errorf(
+			"usernames and animate can't be used with an import: %w",
+			ErrInvalidValue,
+		)
+	}
+
+	{
+		from := q.Get("from")
+		if from != "" {
+			value, err := time.Parse(importDateLayout, from)
+			if err != nil {
+				return nil, fmt.Errorf("invalid from: %w", ErrInvalidValue)
+			}
+			params.From = value
+		}
+	}
+
+	{
+		to := q.Get("to")
+		if to != "" {
+			value, err := time.Parse(importDateLayout, to)
+			if err != nil {
+				return nil, fmt.Errorf("invalid to: %w", ErrInvalidValue)
+			}
+			// the to date is inclusive
+			params.To = value.AddDate(0, 0, 1)
+		}
+	}
+
+	if !params.From.IsZero() && !params.To.IsZero() && !params.From.Before(params.To) {
+		return nil, fmt.Errorf("from must not be after to: %w", ErrInvalidValue)
+	}
+
+	return params, nil
+}
+
+type MosaicRequest struct {
+	Username  string
+	Period    lastfm.Period
+	Height    uint
+	Width     uint
+	Count     int
+	Rows      int
+	Columns   int
+	TileSize  int
+	Blend     int
+	Grayscale bool
+	Webp      bool
+}
+
+// maximum width or height of a mosaic before resizing
+const maxMosaicDimension = 6000
+
+func ParseMosaicQueryValues(query url.Values) (*MosaicRequest, error) {
+	params := &MosaicRequest{}
+	q := normaliseQuery(query)
+
+	{
+		username := q.Get("username")
+		if username == "" {
+			return nil, errors.New("username is required")
+		}
+		params.Username = username
+	}
+
+	{
+		period := q.Get("period")
+		if period == "" {
+			params.Period = lastfm.PeriodOverall
+		} else {
+			period, err := lastfm.GetPeriodFromStr(period)
+			if err != nil {
+				return nil, err
+			}
+			params.Period = period
+		}
+	}
+
+	{
+		height := q.Get("height")
+		value, err := parseUintWithDefaultAndRange(height, 0, 0, maxDimension())
+		if err != nil {
+			return nil, fmt.Errorf("invalid height: %w", err)
+		}
+		params.Height = value
+	}
+
+	{
+		width := q.Get("width")
+		value, err := parseUintWithDefaultAndRange(width, 0, 0, maxDimension())
+		if err != nil {
+			return nil, fmt.Errorf("invalid width: %w", err)
+		}
+		params.Width = value
+	}
+
+	{
+		count := q.Get("count")
+		value, err := parseIntWithDefaultAndRange(count, 100, 1, 1000)
+		if err != nil {
+			return nil, fmt.Errorf("invalid count: %w", err)
+		}
+		params.Count = value
+	}
+
+	{
+		rows := q.Get("rows")
+		value, err := parseIntWithDefaultAndRange(rows, 40, 1, 200)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rows: %w", err)
+		}
+		params.Rows = value
+	}
+
+	{
+		columns := q.Get("columns")
+		value, err := parseIntWithDefaultAndRange(columns, 40, 1, 200)
+		if err != nil {
+			return nil, fmt.Errorf("invalid columns: %w", err)
+		}
+		params.Columns = value
+	}
+
+	{
+		tileSize := q.Get("tilesize")
+		value, err := parseIntWithDefaultAndRange(tileSize, 48, 8, 300)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tile size: %w", err)
+		}
+		params.TileSize = value
+	}
+
+	if params.Rows*params.TileSize > maxMosaicDimension ||
+		params.Columns*params.TileSize > maxMosaicDimension {
+		return nil, fmt.Errorf(
+			"mosaic must be at most %dpx in each dimension: %w",
+			maxMosaicDimension,
+			ErrInvalidValue,
+		)
+	}
+
+	{
+		blend := q.Get("blend")
+		value, err := parseIntWithDefaultAndRange(blend, 0, 0, 100)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blend: %w", err)
+		}
+		params.Blend = value
+	}
+
+	{
+		grayscale := q.Get("grayscale")
+		value, err := parseBoolWithDefault(grayscale, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid grayscale: %w", err)
+		}
+		params.Grayscale = value
+	}
+
+	{
+		webp := q.Get("webp")
+		value, err := parseBoolWithDefault(webp, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webp: %w", err)
+		}
+		params.Webp = value
+	}
+
+	return params, nil
+}
+
+type PosterRequest struct {
+	Username string
+	Period   lastfm.Period
+	Webp     bool
+}
+
+func ParsePosterQueryValues(query url.Values) (*PosterRequest, error) {
+	params := &PosterRequest{}
+	q := normaliseQuery(query)
+
+	{
+		username := q.Get("username")
+		if username == "" {
+			return nil, errors.New("username is required")
+		}
+		params.Username = username
+	}
+
+	{
+		period := q.Get("period")
+		if period == "" {
+			params.Period = lastfm.PeriodTwelveMonths
+		} else {
+			period, err := lastfm.GetPeriodFromStr(period)
+			if err != nil {
+				return nil, err
+			}
+			params.Period = period
+		}
+	}
+
+	{
+		webp := q.Get("webp")
+		value, err := parseBoolWithDefault(webp, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webp: %w", err)
+		}
+		params.Webp = value
+	}
+
+	return params, nil
+}
+
+type CompareRequest struct {
+	Username1 string
+	Username2 string
+	Method    lastfm.Method
+	Period    lastfm.Period
+	Count     int
+	Rows      int
+	Columns   int
+	FontSize  int
+	BoldFont  bool
+	PlayCount bool
+	Webp      bool
+}
+
+func ParseCompareQueryValues(query url.Values) (*CompareRequest, error) {
+	params := &CompareRequest{}
+	q := normaliseQuery(query)
+
+	{
+		user1 := q.Get("user1")
+		user2 := q.Get("user2")
+		if user1 == "" || user2 == "" {
+			return nil, errors.New("user1 and user2 are required")
+		}
+		params.Username1 = user1
+		params.Username2 = user2
+	}
+
+	{
+		method := q.Get("method")
+		if method == "" {
+			params.Method = lastfm.MethodAlbum
+		} else {
+			method, err := lastfm.GetMethodFromStr(method)
+			if err != nil {
+				return nil, err
+			}
+			if method != lastfm.MethodAlbum && method != lastfm.MethodArtist {
+				return nil, fmt.Errorf(
+					"method must be album or artist: %w",
+					lastfm.ErrInvalidMethod,
+				)
+			}
+			params.Method = method
+		}
+	}
+
+	{
+		period := q.Get("period")
+		if period == "" {
+			params.Period = lastfm.PeriodOverall
+		} else {
+			period, err := lastfm.GetPeriodFromStr(period)
+			if err != nil {
+				return nil, err
+			}
+			params.Period = period
+		}
+	}
+
+	{
+		count := q.Get("count")
+		value, err := parseIntWithDefaultAndRange(count, 100, 1, 1000)
+		if err != nil {
+			return nil, fmt.Errorf("invalid count: %w", err)
+		}
+		params.Count = value
+	}
+
+	{
+		rows := q.Get("rows")
+		value, err := parseIntWithDefaultAndRange(rows, 4, 1, 10)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rows: %w", err)
+		}
+		params.Rows = value
+	}
+
+	{
+		columns := q.Get("columns")
+		value, err := parseIntWithDefaultAndRange(columns, 2, 1, 5)
+		if err != nil {
+			return nil, fmt.Errorf("invalid columns: %w", err)
+		}
+		params.Columns = value
+	}
+
+	{
+		size := q.Get("fontsize")
+		value, err := parseIntWithDefaultAndRange(size, 12, 8, 30)
+		if err != nil {
+			return nil, fmt.Errorf("invalid font size: %w", err)
+		}
+		params.FontSize = value
+	}
+
+	{
+		boldfont := q.Get("boldfont")
+		value, err := parseBoolWithDefault(boldfont, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boldfont: %w", err)
+		}
+		params.BoldFont = value
+	}
+
+	{
+		playcount := q.Get("playcount")
+		value, err := parseBoolWithDefault(playcount, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid playcount: %w", err)
+		}
+		params.PlayCount = value
+	}
+
+	{
+		webp := q.Get("webp")
+		value, err := parseBoolWithDefault(webp, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webp: %w", err)
+		}
+		params.Webp = value
+	}
+
 	return params, nil
 }
@@ -4,17 +4,24 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/SongStitch/song-stitch/internal/api"
 	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/collages"
 )
 
 func TestParseQueryValues(t *testing.T) {
 	defaultExpected := api.CollageRequest{
 		Username:      "testuser",
 		Method:        lastfm.MethodAlbum,
+		Source:        collages.SourceLastfm,
 		TextLocation:  lastfm.LocationTopLeft,
 		Period:        lastfm.PeriodSevenDays,
+		Sort:          collages.SortRank,
+		Fit:           collages.FitContain,
+		Aggregation:   collages.AggregationSum,
+		FrameDelay:    2000,
 		Height:        0,
 		Width:         0,
 		Rows:          3,
@@ -42,10 +49,107 @@ func TestParseQueryValues(t *testing.T) {
 			query:        url.Values{"username": []string{"testuser"}},
 			expectedFunc: func(c *api.CollageRequest) {},
 		},
+		"group usernames": {
+			query: url.Values{
+				"usernames": []string{"alice, bob,alice,carol"},
+				"aggregate": []string{"borda"},
+			},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "alice,bob,carol"
+				c.Usernames = []string{"alice", "bob", "carol"}
+				c.Aggregation = collages.AggregationBorda
+			},
+		},
+		"invalid aggregate": {
+			query:   url.Values{"usernames": []string{"a,b"}, "aggregate": []string{"mean"}},
+			wantErr: true,
+		},
 		"invalid method": {
 			query:   url.Values{"username": []string{"test"}, "method": []string{"invalid"}},
 			wantErr: true,
 		},
+		"tag method": {
+			query: url.Values{"username": []string{"test"}, "method": []string{"tag"}},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Method = lastfm.MethodTag
+			},
+		},
+		"recent method with dedupe": {
+			query: url.Values{
+				"username": []string{"test"},
+				"method":   []string{"recent"},
+				"dedupe":   []string{"true"},
+			},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Method = lastfm.MethodRecent
+				c.Dedupe = true
+			},
+		},
+		"loved method": {
+			query: url.Values{"username": []string{"test"}, "method": []string{"loved"}},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Method = lastfm.MethodLoved
+			},
+		},
+		"listenbrainz source": {
+			query: url.Values{"username": []string{"test"}, "source": []string{"listenbrainz"}},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Source = collages.SourceListenBrainz
+			},
+		},
+		"librefm source": {
+			query: url.Values{"username": []string{"test"}, "source": []string{"librefm"}},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Source = collages.SourceLibrefm
+			},
+		},
+		"listenbrainz source with loved method": {
+			query: url.Values{
+				"username": []string{"test"},
+				"source":   []string{"listenbrainz"},
+				"method":   []string{"loved"},
+			},
+			wantErr: true,
+		},
+		"recent method with usernames": {
+			query:   url.Values{"usernames": []string{"a,b"}, "method": []string{"recent"}},
+			wantErr: true,
+		},
+		"filters": {
+			query: url.Values{
+				"username":   []string{"test"},
+				"exclude":    []string{"Some Podcast, ,Guilty Pleasure"},
+				"onlyartist": []string{"Radiohead"},
+				"tag":        []string{"shoegaze"},
+			},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Exclude = []string{"Some Podcast", "Guilty Pleasure"}
+				c.OnlyArtists = []string{"Radiohead"}
+				c.Tag = "shoegaze"
+			},
+		},
+		"autosize with default minplays": {
+			query: url.Values{"username": []string{"test"}, "autosize": []string{"true"}},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.AutoSize = true
+				c.MinPlays = 2
+			},
+		},
+		"minplays with recent method": {
+			query: url.Values{
+				"username": []string{"test"},
+				"method":   []string{"recent"},
+				"minplays": []string{"5"},
+			},
+			wantErr: true,
+		},
 		"valid method and text location": {
 			query: url.Values{
 				"username":     []string{"test"},
@@ -58,6 +162,58 @@ func TestParseQueryValues(t *testing.T) {
 				c.Method = lastfm.MethodTrack
 			},
 		},
+		"colour sort": {
+			query: url.Values{"username": []string{"test"}, "sort": []string{"colour"}},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Sort = collages.SortColour
+			},
+		},
+		"invalid sort": {
+			query:   url.Values{"username": []string{"test"}, "sort": []string{"size"}},
+			wantErr: true,
+		},
+		"animated periods": {
+			query: url.Values{
+				"username": []string{"test"},
+				"animate":  []string{"7day, 1month,overall"},
+				"delay":    []string{"500"},
+			},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Animate = []lastfm.Period{
+					lastfm.PeriodSevenDays,
+					lastfm.PeriodOneMonth,
+					lastfm.PeriodOverall,
+				}
+				c.FrameDelay = 500
+			},
+		},
+		"repeated animate period": {
+			query:   url.Values{"username": []string{"test"}, "animate": []string{"7day,7day"}},
+			wantErr: true,
+		},
+		"every animate period": {
+			query: url.Values{
+				"username": []string{"test"},
+				"animate":  []string{"7day,1month,3month,6month,12month,overall"},
+			},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Animate = []lastfm.Period{
+					lastfm.PeriodSevenDays,
+					lastfm.PeriodOneMonth,
+					lastfm.PeriodThreeMonths,
+					lastfm.PeriodSixMonths,
+					lastfm.PeriodTwelveMonths,
+					lastfm.PeriodOverall,
+				}
+			},
+		},
+		"invalid animate period": {
+			query:   url.Values{"username": []string{"test"}, "animate": []string{"7day,2week"}},
+			wantErr: true,
+		},
 		"invalid height": {
 			query:   url.Values{"username": []string{"test"}, "height": []string{"invalid"}},
 			wantErr: true,
@@ -66,6 +222,41 @@ func TestParseQueryValues(t *testing.T) {
 			query:   url.Values{"username": []string{"test"}, "height": []string{"3001"}},
 			wantErr: true,
 		},
+		"smart fit": {
+			query: url.Values{"username": []string{"test"}, "fit": []string{"smart"}},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Fit = collages.FitSmart
+			},
+		},
+		"invalid fit": {
+			query:   url.Values{"username": []string{"test"}, "fit": []string{"stretch"}},
+			wantErr: true,
+		},
+		"dpi": {
+			query: url.Values{
+				"username": []string{"test"},
+				"png":      []string{"true"},
+				"dpi":      []string{"300"},
+			},
+			expectedFunc: func(c *api.CollageRequest) {
+				c.Username = "test"
+				c.Png = true
+				c.DPI = 300
+			},
+		},
+		"dpi exceeds maximum": {
+			query:   url.Values{"username": []string{"test"}, "dpi": []string{"2401"}},
+			wantErr: true,
+		},
+		"png and webp": {
+			query: url.Values{
+				"username": []string{"test"},
+				"png":      []string{"true"},
+				"webp":     []string{"true"},
+			},
+			wantErr: true,
+		},
 		"valid custom dimensions": {
 			query: url.Values{
 				"username": []string{"test"},
@@ -166,3 +357,115 @@ func TestParseQueryValues(t *testing.T) {
 		})
 	}
 }
+
+func TestParseMosaicQueryValues(t *testing.T) {
+	tests := map[string]struct {
+		query    url.Values
+		expected *api.MosaicRequest
+		wantErr  bool
+	}{
+		"defaults": {
+			query: url.Values{"username": []string{"test"}},
+			expected: &api.MosaicRequest{
+				Username: "test",
+				Period:   lastfm.PeriodOverall,
+				Count:    100,
+				Rows:     40,
+				Columns:  40,
+				TileSize: 48,
+			},
+		},
+		"missing username": {
+			query:   url.Values{},
+			wantErr: true,
+		},
+		"mosaic too large": {
+			query: url.Values{
+				"username": []string{"test"},
+				"rows":     []string{"100"},
+				"tilesize": []string{"100"},
+			},
+			wantErr: true,
+		},
+		"invalid blend": {
+			query:   url.Values{"username": []string{"test"}, "blend": []string{"101"}},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, err := api.ParseMosaicQueryValues(tc.query)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("result mismatch:\nExpected: %+v\nGot:      %+v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseImportQueryValues(t *testing.T) {
+	tests := map[string]struct {
+		query   url.Values
+		from    time.Time
+		to      time.Time
+		wantErr bool
+	}{
+		"no username or range": {
+			query: url.Values{},
+		},
+		"date range": {
+			query: url.Values{"from": []string{"2023-01-01"}, "to": []string{"2023-12-31"}},
+			from:  time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		"invalid date": {
+			query:   url.Values{"from": []string{"01/01/2023"}},
+			wantErr: true,
+		},
+		"reversed range": {
+			query:   url.Values{"from": []string{"2024-01-01"}, "to": []string{"2023-01-01"}},
+			wantErr: true,
+		},
+		"tag method": {
+			query:   url.Values{"method": []string{"tag"}},
+			wantErr: true,
+		},
+		"usernames": {
+			query:   url.Values{"usernames": []string{"a,b"}},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, err := api.ParseImportQueryValues(tc.query)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !result.From.Equal(tc.from) || !result.To.Equal(tc.to) {
+				t.Errorf(
+					"expected range %v to %v, got %v to %v",
+					tc.from,
+					tc.to,
+					result.From,
+					result.To,
+				)
+			}
+		})
+	}
+}
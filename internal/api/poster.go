@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/collages"
+)
+
+func Poster(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("Received poster request")
+
+	request, err := ParsePosterQueryValues(r.URL.Query())
+	if err != nil {
+		logger.Warn().Err(err).Msg("Request was invalid")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Info().
+		Str("username", request.Username).
+		Str("period", string(request.Period)).
+		Bool("webp", request.Webp).
+		Msg("Generating poster")
+
+	options := collages.PosterOptions{
+		Username: request.Username,
+		Period:   request.Period,
+		Webp:     request.Webp,
+	}
+	poster, buffer, err := collages.CreatePoster(ctx, options)
+	if ctx.Err() != nil {
+		logger.Warn().Err(ctx.Err()).Msg("Context cancelled")
+		// 499 is the http status code for client closed request
+		http.Error(w, "Context cancelled", 499)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, lastfm.ErrUserNotFound) {
+			logger.Warn().Err(err).Str("username", request.Username).Msg("User not found")
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if writeServiceError(w, logger, err) {
+			return
+		}
+		logger.Error().Err(err).Msg("Error occurred generating poster")
+		http.Error(w, "An error occurred processing your request", http.StatusInternalServerError)
+		return
+	}
+
+	writeImage(w, r, poster, buffer, request.Webp)
+}
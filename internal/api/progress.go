@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/progress"
+)
+
+// progressInterval limits how often events are sent, as every tile is an update
+const progressInterval = 250 * time.Millisecond
+
+// progressWait is how long to wait for the collage request, which is usually sent
+// just after the client starts following it
+const progressWait = 10 * time.Second
+
+// CollageProgress streams the progress of the collage request with the id as
+// server-sent events, ending once the collage has been sent or the progress is no
+// longer kept
+func CollageProgress(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx)
+
+	id, err := xid.FromString(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	tracker, ok := progress.Find(ctx, id, progressWait)
+	if !ok {
+		http.Error(w, "collage request not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// stop proxies from buffering the events
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	for {
+		p, changed := tracker.Snapshot()
+		data, err := json.Marshal(p)
+		if err != nil {
+			logger.Error().Err(err).Msg("Unable to encode progress")
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+		if p.Done {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tracker.Expired():
+			return
+		case <-changed:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tracker.Expired():
+			return
+		case <-time.After(progressInterval):
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/SongStitch/song-stitch/internal/progress"
+)
+
+func TestCollageProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(CollageProgress))
+	t.Cleanup(server.Close)
+	id := xid.New()
+
+	events := make(chan string)
+	go func() {
+		res, err := http.Get(server.URL + "?id=" + id.String())
+		if err != nil {
+			events <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		events <- string(body)
+	}()
+
+	// the collage request can arrive before or after the client follows it
+	render := func(w http.ResponseWriter, r *http.Request) {
+		progress.FromContext(r.Context()).SetTotal(4)
+	}
+	collage := progress.Handler("requestid")(http.HandlerFunc(render))
+	request := httptest.NewRequest(http.MethodGet, "/collage?requestid="+id.String(), nil)
+	collage.ServeHTTP(httptest.NewRecorder(), request)
+
+	select {
+	case body := <-events:
+		if !strings.Contains(body, `"total":4`) || !strings.Contains(body, `"done":true`) {
+			t.Errorf("expected the stream to end once the collage is done, got %q", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to end")
+	}
+}
+
+func TestCollageProgressNotFound(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	request := httptest.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		"/collage/progress?id="+xid.New().String(),
+		nil,
+	)
+	w := httptest.NewRecorder()
+	CollageProgress(w, request)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
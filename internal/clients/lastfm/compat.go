@@ -0,0 +1,174 @@
+package lastfm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+// Service is an API compatible with the Last.fm API
+type Service string
+
+const (
+	ServiceLastfm Service = "lastfm"
+	// Libre.fm, or a self-hosted GNU FM server
+	ServiceLibrefm Service = "librefm"
+)
+
+// maximum size of a response from a compatible service, which is read in full
+const maxCompatResponseSize = 16 << 20
+
+func (s Service) credentials() (string, string) {
+	cfg := config.GetConfig()
+	if s == ServiceLibrefm {
+		return cfg.Librefm.Endpoint, cfg.Librefm.APIKey
+	}
+	return cfg.Lastfm.Endpoint, cfg.Lastfm.APIKey
+}
+
+// request fetches the url from the service. Responses from services other than
+// Last.fm are checked for errors reported with a 200 status and normalised to
+// the shapes Last.fm returns.
+func (s Service) request(ctx context.Context, url string) (io.ReadCloser, error) {
+	if s == ServiceLastfm {
+		return doRequest(ctx, url)
+	}
+	return withRetries(ctx, func() (io.ReadCloser, error) {
+		return s.requestOnce(ctx, url)
+	})
+}
+
+func (s Service) requestOnce(ctx context.Context, url string) (io.ReadCloser, error) {
+	logger := zerolog.Ctx(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+
+	start := time.Now()
+	res, err := defaultHTTPClient.Do(req)
+	logger.Info().
+		Dur("duration", time.Since(start)).
+		Int("status", statusCodeOrZero(res)).
+		Str("service", string(s)).
+		Msg("Last.fm compatible request completed")
+	if err != nil {
+		return nil, cleanError(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxCompatResponseSize))
+	if err != nil {
+		return nil, cleanError(err)
+	}
+	var data any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		// a 404 without an error response is a missing endpoint, not a missing user
+		if res.StatusCode != http.StatusOK {
+			return nil, statusCodeError(string(s), res.StatusCode)
+		}
+		return nil, fmt.Errorf("%s invalid response: %w", s, err)
+	}
+	if err := responseError(data); err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, statusCodeError(string(s), res.StatusCode)
+	}
+
+	normalised, err := json.Marshal(stringifyNumbers(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(normalised)), nil
+}
+
+// responseError returns the error in the response, which is either
+// {"error": 6, "message": "..."} or {"error": {"code": 6, "#text": "..."}}
+func responseError(data any) error {
+	object, ok := data.(map[string]any)
+	if !ok {
+		return nil
+	}
+	value, ok := object["error"]
+	if !ok {
+		return nil
+	}
+
+	code, message := value, object["message"]
+	if nested, ok := value.(map[string]any); ok {
+		code, message = nested["code"], nested["#text"]
+	}
+	apiErr := &APIError{Message: fmt.Sprint(message)}
+	apiErr.Code, _ = strconv.Atoi(fmt.Sprint(code))
+	return apiErr
+}
+
+// stringifyNumbers converts numbers to strings, as Last.fm returns every value
+// as a string while GNU FM returns counts as numbers
+func stringifyNumbers(data any) any {
+	switch value := data.(type) {
+	case json.Number:
+		return value.String()
+	case map[string]any:
+		for key, v := range value {
+			value[key] = stringifyNumbers(v)
+		}
+	case []any:
+		for i, v := range value {
+			value[i] = stringifyNumbers(v)
+		}
+	}
+	return data
+}
+
+// List is a list in a Last.fm compatible response. GNU FM returns a list with a
+// single item as the item itself, and an empty list as a string.
+type List[T any] []T
+
+func (l *List[T]) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0:
+		*l = nil
+		return nil
+	case data[0] == '[':
+		var items []T
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		*l = items
+		return nil
+	case data[0] == '{':
+		var item T
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+		*l = List[T]{item}
+		return nil
+	default:
+		*l = nil
+		return nil
+	}
+}
+
+// ParseTotalPages returns the number of pages in the response, treating a missing
+// count as a single page
+func ParseTotalPages(attr LastfmUser) (int, error) {
+	if attr.TotalPages == "" {
+		return 1, nil
+	}
+	return strconv.Atoi(attr.TotalPages)
+}
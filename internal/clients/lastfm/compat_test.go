@@ -0,0 +1,87 @@
+package lastfm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompatResponse(t *testing.T) {
+	type topAlbums struct {
+		TopAlbums struct {
+			Attr   LastfmUser `json:"@attr"`
+			Albums List[struct {
+				Name      string `json:"name"`
+				Playcount string `json:"playcount"`
+			}] `json:"album"`
+		} `json:"topalbums"`
+	}
+
+	testCases := map[string]struct {
+		response   string
+		albums     []string
+		totalPages int
+		err        error
+	}{
+		"list": {
+			response: `{"topalbums": {"@attr": {"totalPages": 2}, "album": [
+				{"name": "Kid A", "playcount": 12},
+				{"name": "Amnesiac", "playcount": "3"}
+			]}}`,
+			albums:     []string{"Kid A:12", "Amnesiac:3"},
+			totalPages: 2,
+		},
+		"single item": {
+			response:   `{"topalbums": {"album": {"name": "Kid A", "playcount": 12}}}`,
+			albums:     []string{"Kid A:12"},
+			totalPages: 1,
+		},
+		"empty": {
+			response:   `{"topalbums": {"#text": "\n", "@attr": {"totalPages": "0"}}}`,
+			totalPages: 0,
+		},
+		"user not found": {
+			response: `{"error": {"code": 6, "#text": "No user with that name"}}`,
+			err:      ErrUserNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var data any
+			decoder := json.NewDecoder(bytes.NewReader([]byte(tc.response)))
+			decoder.UseNumber()
+			if err := decoder.Decode(&data); err != nil {
+				t.Fatal(err)
+			}
+			if err := responseError(data); !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if tc.err != nil {
+				return
+			}
+
+			normalised, err := json.Marshal(stringifyNumbers(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var response topAlbums
+			if err := json.Unmarshal(normalised, &response); err != nil {
+				t.Fatal(err)
+			}
+			var albums []string
+			for _, album := range response.TopAlbums.Albums {
+				albums = append(albums, album.Name+":"+album.Playcount)
+			}
+			if !reflect.DeepEqual(albums, tc.albums) {
+				t.Errorf("expected %v, got %v", tc.albums, albums)
+			}
+			totalPages, err := ParseTotalPages(response.TopAlbums.Attr)
+			if err != nil || totalPages != tc.totalPages {
+				t.Errorf("expected %d pages, got %d (%v)", tc.totalPages, totalPages, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package lastfm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var ErrPrivateProfile = errors.New("user's listening history is private")
+var ErrRateLimited = errors.New("rate limit exceeded")
+var ErrServiceUnavailable = errors.New("service temporarily unavailable")
+var ErrInvalidAPIKey = errors.New("api key invalid or suspended")
+
+// error codes returned in the body of a Last.fm error response
+const (
+	// also returned for an unknown user, the only parameter users control
+	errorCodeInvalidParameters = 6
+	errorCodeOperationFailed   = 8
+	errorCodeInvalidAPIKey     = 10
+	errorCodeServiceOffline    = 11
+	errorCodeTemporaryError    = 16
+	errorCodeLoginRequired     = 17
+	errorCodeSuspendedAPIKey   = 26
+	errorCodeRateLimitExceeded = 29
+)
+
+const (
+	maxErrorResponseSize = 64 << 10
+	maxAttempts          = 3
+)
+
+// retryBackoff is the delay before the first retry, doubled for each retry after it
+var retryBackoff = 500 * time.Millisecond
+
+// APIError is an error returned in the body of a Last.fm response
+type APIError struct {
+	Code    int    `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("lastfm error %d: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the error the code corresponds to, so the code can be checked
+// with errors.Is
+func (e *APIError) Unwrap() error {
+	switch e.Code {
+	case errorCodeInvalidParameters:
+		return ErrUserNotFound
+	case errorCodeLoginRequired:
+		return ErrPrivateProfile
+	case errorCodeRateLimitExceeded:
+		return ErrRateLimited
+	case errorCodeOperationFailed, errorCodeServiceOffline, errorCodeTemporaryError:
+		return ErrServiceUnavailable
+	case errorCodeInvalidAPIKey, errorCodeSuspendedAPIKey:
+		return ErrInvalidAPIKey
+	default:
+		return nil
+	}
+}
+
+// responseStatusError returns the error for a response with a status other than
+// 200, from the error in the body if there is one
+func responseStatusError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorResponseSize))
+	var apiErr APIError
+	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Code != 0 {
+		return &apiErr
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return ErrUserNotFound
+	}
+	return statusCodeError("lastfm", res.StatusCode)
+}
+
+func statusCodeError(service string, statusCode int) error {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%s status code %d: %w", service, statusCode, ErrRateLimited)
+	case statusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%s status code %d: %w", service, statusCode, ErrServiceUnavailable)
+	default:
+		return fmt.Errorf("%s unexpected status code: %d", service, statusCode)
+	}
+}
+
+// withRetries makes the request, retrying errors Last.fm reports as temporary with
+// an exponential backoff
+func withRetries(
+	ctx context.Context,
+	request func() (io.ReadCloser, error),
+) (io.ReadCloser, error) {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		body, err := request()
+		temporary := errors.Is(err, ErrServiceUnavailable) || errors.Is(err, ErrRateLimited)
+		if err == nil || !temporary || attempt == maxAttempts {
+			return body, err
+		}
+
+		// jitter stops the concurrent requests of a collage retrying in lockstep
+		delay := backoff/2 + rand.N(backoff/2)
+		zerolog.Ctx(ctx).Warn().
+			Err(err).
+			Int("attempt", attempt).
+			Dur("delay", delay).
+			Msg("Retrying Last.fm request")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,197 @@
+package lastfm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResponseStatusError(t *testing.T) {
+	testCases := map[string]struct {
+		status   int
+		body     string
+		expected error
+	}{
+		"user not found": {
+			status:   http.StatusNotFound,
+			body:     `{"message": "User not found", "error": 6}`,
+			expected: ErrUserNotFound,
+		},
+		"not found without body": {
+			status:   http.StatusNotFound,
+			expected: ErrUserNotFound,
+		},
+		"private profile": {
+			status:   http.StatusForbidden,
+			body:     `{"message": "Login: User required to be logged in", "error": 17}`,
+			expected: ErrPrivateProfile,
+		},
+		"rate limit": {
+			status:   http.StatusTooManyRequests,
+			body:     `{"message": "Rate Limit Exceeded", "error": 29}`,
+			expected: ErrRateLimited,
+		},
+		"temporary error": {
+			status:   http.StatusInternalServerError,
+			body:     `{"message": "Temporary error", "error": 16}`,
+			expected: ErrServiceUnavailable,
+		},
+		"gateway error": {
+			status:   http.StatusBadGateway,
+			body:     "<html>Bad Gateway</html>",
+			expected: ErrServiceUnavailable,
+		},
+		"suspended key": {
+			status:   http.StatusForbidden,
+			body:     `{"message": "Suspended API key", "error": 26}`,
+			expected: ErrInvalidAPIKey,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			res := &http.Response{
+				StatusCode: tc.status,
+				Body:       io.NopCloser(strings.NewReader(tc.body)),
+			}
+			if err := responseStatusError(res); !errors.Is(err, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+// responses is a round tripper returning the responses in turn, repeating the
+// last once they run out
+type responses struct {
+	statuses []int
+	bodies   []string
+	attempts atomic.Int32
+	// called with the attempt number before it is answered
+	onAttempt func(attempt int)
+}
+
+func (rs *responses) RoundTrip(r *http.Request) (*http.Response, error) {
+	attempt := int(rs.attempts.Add(1))
+	if rs.onAttempt != nil {
+		rs.onAttempt(attempt)
+	}
+	i := min(attempt, len(rs.statuses)) - 1
+	return &http.Response{
+		StatusCode: rs.statuses[i],
+		Body:       io.NopCloser(strings.NewReader(rs.bodies[i])),
+		Request:    r,
+	}, nil
+}
+
+// fakeTransport answers Last.fm requests with the responses for the test, with
+// retries that don't wait long
+func fakeTransport(t *testing.T, rs *responses, backoff time.Duration) {
+	t.Helper()
+	transport, previousBackoff := defaultHTTPClient.Transport, retryBackoff
+	defaultHTTPClient.Transport, retryBackoff = rs, backoff
+	t.Cleanup(func() {
+		defaultHTTPClient.Transport, retryBackoff = transport, previousBackoff
+	})
+}
+
+func TestDoRequestRetries(t *testing.T) {
+	testCases := map[string]struct {
+		statuses []int
+		bodies   []string
+		attempts int32
+		expected error
+	}{
+		"server error retried up to the limit": {
+			statuses: []int{http.StatusServiceUnavailable},
+			bodies:   []string{""},
+			attempts: maxAttempts,
+			expected: ErrServiceUnavailable,
+		},
+		"temporary error retried up to the limit": {
+			statuses: []int{http.StatusInternalServerError},
+			bodies:   []string{`{"message": "Temporary error", "error": 16}`},
+			attempts: maxAttempts,
+			expected: ErrServiceUnavailable,
+		},
+		"rate limit retried until it succeeds": {
+			statuses: []int{http.StatusTooManyRequests, http.StatusOK},
+			bodies:   []string{`{"message": "Rate Limit Exceeded", "error": 29}`, "{}"},
+			attempts: 2,
+		},
+		"user not found not retried": {
+			statuses: []int{http.StatusNotFound},
+			bodies:   []string{`{"message": "User not found", "error": 6}`},
+			attempts: 1,
+			expected: ErrUserNotFound,
+		},
+		"private profile not retried": {
+			statuses: []int{http.StatusForbidden},
+			bodies:   []string{`{"message": "Login: User required to be logged in", "error": 17}`},
+			attempts: 1,
+			expected: ErrPrivateProfile,
+		},
+		"other client error not retried": {
+			statuses: []int{http.StatusBadRequest},
+			bodies:   []string{""},
+			attempts: 1,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			rs := &responses{statuses: tc.statuses, bodies: tc.bodies}
+			fakeTransport(t, rs, time.Millisecond)
+
+			body, err := doRequest(context.Background(), "http://lastfm.test")
+			if body != nil {
+				body.Close()
+			}
+			succeeds := tc.statuses[len(tc.statuses)-1] == http.StatusOK
+			switch {
+			case tc.expected != nil && !errors.Is(err, tc.expected):
+				t.Errorf("expected %v, got %v", tc.expected, err)
+			case succeeds && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case !succeeds && err == nil:
+				t.Error("expected an error")
+			}
+			if attempts := rs.attempts.Load(); attempts != tc.attempts {
+				t.Errorf("expected %d attempts, got %d", tc.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestDoRequestCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the request is cancelled while it waits to retry
+	rs := &responses{
+		statuses:  []int{http.StatusServiceUnavailable},
+		bodies:    []string{""},
+		onAttempt: func(int) { go cancel() },
+	}
+	fakeTransport(t, rs, time.Hour)
+
+	done := make(chan error)
+	go func() {
+		_, err := doRequest(ctx, "http://lastfm.test")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the backoff to stop once the context is cancelled")
+	}
+	if attempts := rs.attempts.Load(); attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts)
+	}
+}
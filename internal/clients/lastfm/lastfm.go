@@ -1,6 +1,7 @@
 package lastfm
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -11,12 +12,15 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
 
 	"github.com/SongStitch/song-stitch/internal/clients"
+	"github.com/SongStitch/song-stitch/internal/clients/limiter"
 	"github.com/SongStitch/song-stitch/internal/config"
+	"github.com/SongStitch/song-stitch/internal/progress"
 )
 
 type LastfmImage struct {
@@ -40,6 +44,13 @@ func getMethodForCollageType(collageType Method) string {
 		return "user.gettopartists"
 	case MethodTrack:
 		return "user.gettoptracks"
+	case MethodTag:
+		// tags are aggregated from the tags of the user's top artists
+		return "user.gettopartists"
+	case MethodRecent:
+		return "user.getrecenttracks"
+	case MethodLoved:
+		return "user.getlovedtracks"
 	default:
 		return ""
 	}
@@ -66,7 +77,8 @@ func cleanError(err error) error {
 
 var (
 	defaultHTTPClient = &http.Client{
-		Timeout: 60 * time.Second,
+		Timeout:   60 * time.Second,
+		Transport: limiter.Transport,
 	}
 
 	apiKeyRedactionRegex = regexp.MustCompile(`([&?])api_key=[^&]+(&|\b)`)
@@ -74,19 +86,47 @@ var (
 	defaultUserAgent = "songstitch/1.0 (+https://songstitch.art)"
 )
 
-func GetLastFmResponse(
+// MaxPageSize is the largest number of items Last.fm returns in a page
+const MaxPageSize = 500
+
+// GetLastFmResponseWithPageSize fetches pages of the given size until the handler
+// reports count items fetched or there are no pages left
+func GetLastFmResponseWithPageSize(
 	ctx context.Context,
 	collageType Method,
 	username string,
 	period Period,
 	count int,
+	pageSize int,
 	handler func(data io.Reader) (fetched int, totalPages int, err error),
 ) error {
-	cfg := config.GetConfig()
-	endpoint := cfg.Lastfm.Endpoint
-	apiKey := cfg.Lastfm.APIKey
+	return GetServiceResponse(
+		ctx,
+		ServiceLastfm,
+		collageType,
+		username,
+		period,
+		count,
+		pageSize,
+		handler,
+	)
+}
 
-	logger := zerolog.Ctx(ctx)
+// GetServiceResponse is GetLastFmResponseWithPageSize for any Last.fm compatible
+// service
+func GetServiceResponse(
+	ctx context.Context,
+	service Service,
+	collageType Method,
+	username string,
+	period Period,
+	count int,
+	pageSize int,
+	handler func(data io.Reader) (fetched int, totalPages int, err error),
+) error {
+	endpoint, apiKey := service.credentials()
+
+	logger := zerolog.Ctx(ctx).With().Str("service", string(service)).Logger()
 	logger.Info().Msg("Fetching Last.fm data")
 
 	method := getMethodForCollageType(collageType)
@@ -94,62 +134,131 @@ func GetLastFmResponse(
 		return fmt.Errorf("unsupported collage type: %v", collageType)
 	}
 
-	const maxPerPage = 500
-
-	totalFetched := 0
-	page := 1
-
-	for count > totalFetched {
-		logger.Info().
-			Int("page", page).
-			Int("totalFetched", totalFetched).
-			Int("count", count).
-			Msg("Fetching Last.fm page")
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid Last.fm endpoint")
+		return fmt.Errorf("invalid lastfm endpoint: %w", err)
+	}
 
-		limit := min(count-totalFetched, maxPerPage)
+	// the page size must stay the same between pages for the page offsets to line
+	// up, so the last page may return more items than are needed
+	fetchPage := func(ctx context.Context, page int) ([]byte, error) {
+		logger.Info().Int("page", page).Int("count", count).Msg("Fetching Last.fm page")
 
-		u, err := url.Parse(endpoint)
-		if err != nil {
-			logger.Error().Err(err).Msg("invalid Last.fm endpoint")
-			return fmt.Errorf("invalid lastfm endpoint: %w", err)
-		}
-
-		q := u.Query()
+		pageURL := *u
+		q := pageURL.Query()
 		q.Set("user", username)
 		q.Set("method", method)
-		q.Set("period", string(period))
-		q.Set("limit", strconv.Itoa(limit))
+		if period != "" {
+			q.Set("period", string(period))
+		}
+		q.Set("limit", strconv.Itoa(pageSize))
 		q.Set("page", strconv.Itoa(page))
 		q.Set("api_key", apiKey)
 		q.Set("format", "json")
-		u.RawQuery = q.Encode()
+		pageURL.RawQuery = q.Encode()
 
-		body, err := doRequest(ctx, u.String())
+		body, err := service.request(ctx, pageURL.String())
 		if err != nil {
-			return err
+			return nil, err
 		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		if err == nil {
+			progress.FromContext(ctx).PageFetched()
+		}
+		return data, err
+	}
 
-		fetched, totalPages, err := func() (int, int, error) {
-			defer body.Close()
-			return handler(body)
-		}()
+	data, err := fetchPage(ctx, 1)
+	if err != nil {
+		return err
+	}
+	totalFetched, totalPages, err := handler(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	// the remaining pages are fetched concurrently once the first reveals how many
+	// there are, and handled in page order
+	page := 2
+	for count > totalFetched && page <= totalPages {
+		// the pages needed if the handler keeps every item, more are fetched in the
+		// next round if it doesn't
+		needed := (count - totalFetched + pageSize - 1) / pageSize
+		lastPage := min(totalPages, page+needed-1)
+		pages, err := fetchPages(ctx, page, lastPage, fetchPage)
 		if err != nil {
 			return err
 		}
-
-		totalFetched = fetched
-
-		if totalPages == page || totalPages == 0 {
-			break
+		for _, data := range pages {
+			totalFetched, _, err = handler(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			if totalFetched >= count {
+				break
+			}
 		}
-
-		page++
+		page = lastPage + 1
 	}
 
 	return nil
 }
 
+// maximum number of pages of a list fetched at once
+const maxConcurrentPages = 4
+
+// fetchPages fetches the pages from first to last inclusive, returning them in
+// page order. The first error stops the remaining requests.
+func fetchPages(
+	ctx context.Context,
+	first int,
+	last int,
+	fetchPage func(ctx context.Context, page int) ([]byte, error),
+) ([][]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pages := make([][]byte, last-first+1)
+	var firstErr error
+	var errOnce sync.Once
+	sem := make(chan struct{}, maxConcurrentPages)
+	var wg sync.WaitGroup
+	for i := range pages {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := ctx.Err(); err != nil {
+				errOnce.Do(func() { firstErr = err })
+				return
+			}
+			data, err := fetchPage(ctx, first+i)
+			if err != nil {
+				// later errors may only be from the cancellation
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			pages[i] = data
+		})
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return pages, nil
+}
+
 func doRequest(ctx context.Context, url string) (io.ReadCloser, error) {
+	return withRetries(ctx, func() (io.ReadCloser, error) {
+		return doRequestOnce(ctx, url)
+	})
+}
+
+func doRequestOnce(ctx context.Context, url string) (io.ReadCloser, error) {
 	logger := zerolog.Ctx(ctx)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
@@ -168,13 +277,9 @@ func doRequest(ctx context.Context, url string) (io.ReadCloser, error) {
 		return nil, cleanError(err)
 	}
 
-	if res.StatusCode == http.StatusNotFound {
-		return nil, ErrUserNotFound
-	}
-
 	if res.StatusCode != http.StatusOK {
-		res.Body.Close()
-		return nil, fmt.Errorf("lastfm unexpected status code: %d", res.StatusCode)
+		defer res.Body.Close()
+		return nil, responseStatusError(res)
 	}
 	return res.Body, nil
 }
@@ -186,6 +291,142 @@ func statusCodeOrZero(res *http.Response) int {
 	return res.StatusCode
 }
 
+type UserInfo struct {
+	Name       string        `json:"name"`
+	RealName   string        `json:"realname"`
+	URL        string        `json:"url"`
+	Country    string        `json:"country"`
+	Playcount  string        `json:"playcount"`
+	Images     []LastfmImage `json:"image"`
+	Registered struct {
+		Unixtime string `json:"unixtime"`
+	} `json:"registered"`
+}
+
+type GetUserInfoResponse struct {
+	User UserInfo `json:"user"`
+}
+
+func GetUserInfo(ctx context.Context, username string) (UserInfo, error) {
+	cfg := config.GetConfig()
+	endpoint := cfg.Lastfm.Endpoint
+	apiKey := cfg.Lastfm.APIKey
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("invalid lastfm endpoint: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("user", username)
+	q.Set("method", "user.getinfo")
+	q.Set("api_key", apiKey)
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	body, err := doRequest(ctx, u.String())
+	if err != nil {
+		return UserInfo{}, err
+	}
+	defer body.Close()
+
+	var response GetUserInfoResponse
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return UserInfo{}, err
+	}
+	return response.User, nil
+}
+
+type GetRecentTracksTotalResponse struct {
+	RecentTracks struct {
+		Attr LastfmUser `json:"@attr"`
+	} `json:"recenttracks"`
+}
+
+// GetScrobbleCount returns the number of scrobbles the user has made since from
+func GetScrobbleCount(ctx context.Context, username string, from time.Time) (int, error) {
+	cfg := config.GetConfig()
+	endpoint := cfg.Lastfm.Endpoint
+	apiKey := cfg.Lastfm.APIKey
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lastfm endpoint: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("user", username)
+	q.Set("method", "user.getrecenttracks")
+	q.Set("limit", "1")
+	if !from.IsZero() {
+		q.Set("from", strconv.FormatInt(from.Unix(), 10))
+	}
+	q.Set("api_key", apiKey)
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	body, err := doRequest(ctx, u.String())
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	var response GetRecentTracksTotalResponse
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(response.RecentTracks.Attr.Total)
+}
+
+type ArtistTag struct {
+	Name string `json:"name"`
+	// relative weight of the tag for the artist, from 0 to 100
+	Count int `json:"count"`
+}
+
+type GetArtistTopTagsResponse struct {
+	TopTags struct {
+		Tags []ArtistTag `json:"tag"`
+	} `json:"toptags"`
+}
+
+// GetArtistTopTags returns the most applied tags for the artist, looked up by MBID
+// when known and by name otherwise
+func GetArtistTopTags(ctx context.Context, artistName string, mbid string) ([]ArtistTag, error) {
+	cfg := config.GetConfig()
+	endpoint := cfg.Lastfm.Endpoint
+	apiKey := cfg.Lastfm.APIKey
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lastfm endpoint: %w", err)
+	}
+
+	q := u.Query()
+	if mbid != "" {
+		q.Set("mbid", mbid)
+	} else {
+		q.Set("artist", artistName)
+		q.Set("autocorrect", "1")
+	}
+	q.Set("method", "artist.gettoptags")
+	q.Set("api_key", apiKey)
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	body, err := doRequest(ctx, u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var response GetArtistTopTagsResponse
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return response.TopTags.Tags, nil
+}
+
 type GetTrackInfoResponse struct {
 	Track struct {
 		Album struct {
@@ -243,13 +484,11 @@ func GetTrackInfo(
 		return clients.TrackInfo{}, err
 	}
 
-	for _, image := range response.Track.Album.Images {
-		if image.Size == imageSize {
-			return clients.TrackInfo{
-				AlbumName: response.Track.Album.AlbumName,
-				ImageUrl:  image.Link,
-			}, nil
-		}
+	if link := ImageForSize(response.Track.Album.Images, imageSize); link != "" {
+		return clients.TrackInfo{
+			AlbumName: response.Track.Album.AlbumName,
+			ImageUrl:  link,
+		}, nil
 	}
 
 	return clients.TrackInfo{}, errors.New("no image found for requested size")
@@ -462,7 +701,11 @@ func fetchArtistImageFromWikipedia(ctx context.Context, artistName string) (stri
 }
 
 // fetchArtistImageFromDeezer fetches an artist image from the Deezer search API as a fallback.
-func fetchArtistImageFromDeezer(ctx context.Context, artistName string) (string, error) {
+func fetchArtistImageFromDeezer(
+	ctx context.Context,
+	artistName string,
+	imageSize string,
+) (string, error) {
 	artistName = strings.TrimSpace(artistName)
 	if artistName == "" {
 		return "", nil
@@ -535,13 +778,21 @@ func fetchArtistImageFromDeezer(ctx context.Context, artistName string) (string,
 
 	valid := isValidUrl(url)
 	if valid {
-		url = strings.Replace(url, "1000x1000", "300x300", 1)
+		// the XL picture is only needed for tiles larger than 300px
+		if imageSize != clients.ImageSizeHuge {
+			url = strings.Replace(url, "1000x1000", "300x300", 1)
+		}
 		return url, nil
 	}
 	return "", nil
 }
 
-func GetImageIdForArtist(ctx context.Context, artistName string, mbid string) (string, error) {
+func GetImageIdForArtist(
+	ctx context.Context,
+	artistName string,
+	mbid string,
+	imageSize string,
+) (string, error) {
 	logger := zerolog.Ctx(ctx).With().Str("artistName", artistName).Str("mbid", mbid).Logger()
 	cfg := config.GetConfig()
 
@@ -571,7 +822,7 @@ func GetImageIdForArtist(ctx context.Context, artistName string, mbid string) (s
 		}
 	}
 
-	deezerURL, err := fetchArtistImageFromDeezer(ctx, artistName)
+	deezerURL, err := fetchArtistImageFromDeezer(ctx, artistName, imageSize)
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -612,3 +863,34 @@ func BuildArtistImageURL(idOrURL string) string {
 
 	return "https://lastfm.freetls.fastly.net/i/u/300x300/" + idOrURL
 }
+
+// OriginalImageURL returns the URL of the image as it was uploaded, which is
+// larger than any of the Last.fm image sizes, by dropping the size from the path
+func OriginalImageURL(link string) string {
+	i := strings.Index(link, "/i/u/")
+	if i < 0 {
+		return link
+	}
+	path := link[i+len("/i/u/"):]
+	size, name, ok := strings.Cut(path, "/")
+	if !ok || !strings.Contains(size, "x") {
+		return link
+	}
+	return link[:i+len("/i/u/")] + name
+}
+
+// ImageForSize returns the link of the image of the size, if there is one.
+// Without a huge image, the original of the extralarge image is used.
+func ImageForSize(images []LastfmImage, imageSize string) string {
+	for _, image := range images {
+		if image.Size == imageSize && image.Link != "" {
+			return image.Link
+		}
+	}
+	if imageSize == clients.ImageSizeHuge {
+		if link := ImageForSize(images, clients.ImageSizeExtraLarge); link != "" {
+			return OriginalImageURL(link)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package lastfm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchPages(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	fetchPage := func(ctx context.Context, page int) ([]byte, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			current := maxInFlight.Load()
+			if n <= current || maxInFlight.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		// later pages finish first to check the pages are put back in order
+		time.Sleep(time.Duration(20-page) * time.Millisecond)
+		return []byte(strconv.Itoa(page)), nil
+	}
+
+	pages, err := fetchPages(context.Background(), 2, 11, fetchPage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result := []string{}
+	for _, page := range pages {
+		result = append(result, string(page))
+	}
+	expected := []string{"2", "3", "4", "5", "6", "7", "8", "9", "10", "11"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+	if n := maxInFlight.Load(); n > maxConcurrentPages {
+		t.Errorf("expected at most %d pages at once, got %d", maxConcurrentPages, n)
+	}
+}
+
+func TestFetchPagesError(t *testing.T) {
+	fetchPage := func(ctx context.Context, page int) ([]byte, error) {
+		if page == 3 {
+			return nil, ErrServiceUnavailable
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return []byte{}, nil
+		}
+	}
+
+	_, err := fetchPages(context.Background(), 2, 9, fetchPage)
+	if !errors.Is(err, ErrServiceUnavailable) {
+		t.Errorf("expected %v, got %v", ErrServiceUnavailable, err)
+	}
+}
+
+func TestOriginalImageURL(t *testing.T) {
+	const original = "https://lastfm.freetls.fastly.net/i/u/2a96cbd8.png"
+	// other images are already the size they were requested at
+	const coverArt = "https://coverartarchive.org/release/mbid/1-500.jpg"
+	testCases := map[string]string{
+		"https://lastfm.freetls.fastly.net/i/u/300x300/2a96cbd8.png": original,
+		original: original,
+		coverArt: coverArt,
+	}
+	for link, expected := range testCases {
+		if got := OriginalImageURL(link); got != expected {
+			t.Errorf("expected %s, got %s", expected, got)
+		}
+	}
+}
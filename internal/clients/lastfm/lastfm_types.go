@@ -1,6 +1,9 @@
 package lastfm
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var ErrTooManyImages = errors.New("too many images requested")
 var ErrUserNotFound = errors.New("user not found")
@@ -39,12 +42,34 @@ func GetPeriodFromStr(s string) (Period, error) {
 	}
 }
 
+// Start returns the beginning of the period relative to now, or the zero time
+// for the overall period
+func (p Period) Start(now time.Time) time.Time {
+	switch p {
+	case PeriodSevenDays:
+		return now.AddDate(0, 0, -7)
+	case PeriodOneMonth:
+		return now.AddDate(0, -1, 0)
+	case PeriodThreeMonths:
+		return now.AddDate(0, -3, 0)
+	case PeriodSixMonths:
+		return now.AddDate(0, -6, 0)
+	case PeriodTwelveMonths:
+		return now.AddDate(-1, 0, 0)
+	default:
+		return time.Time{}
+	}
+}
+
 type Method string
 
 const (
 	MethodAlbum  Method = "album"
 	MethodArtist Method = "artist"
 	MethodTrack  Method = "track"
+	MethodTag    Method = "tag"
+	MethodRecent Method = "recent"
+	MethodLoved  Method = "loved"
 )
 
 func GetMethodFromStr(s string) (Method, error) {
@@ -55,11 +80,23 @@ func GetMethodFromStr(s string) (Method, error) {
 		return MethodArtist, nil
 	case "track":
 		return MethodTrack, nil
+	case "tag":
+		return MethodTag, nil
+	case "recent":
+		return MethodRecent, nil
+	case "loved":
+		return MethodLoved, nil
 	default:
 		return MethodAlbum, ErrInvalidMethod
 	}
 }
 
+// IsTimeline reports whether the method lists scrobbles in the order they happened
+// rather than ranking the user's top items over a period
+func (m Method) IsTimeline() bool {
+	return m == MethodRecent || m == MethodLoved
+}
+
 type TextLocation string
 
 const (
@@ -0,0 +1,58 @@
+package limiter
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+// Transport limits the requests in flight to each host, shared by every client so
+// the limits hold across simultaneous collages. A request holds its slot until the
+// response headers arrive.
+var Transport http.RoundTripper = &hostLimiter{base: http.DefaultTransport}
+
+type hostLimiter struct {
+	base  http.RoundTripper
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func (l *hostLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	sem := l.semaphore(req.URL.Hostname())
+	select {
+	case sem <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	defer func() { <-sem }()
+	return l.base.RoundTrip(req)
+}
+
+func (l *hostLimiter) semaphore(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sem, ok := l.hosts[host]; ok {
+		return sem
+	}
+	if l.hosts == nil {
+		l.hosts = map[string]chan struct{}{}
+	}
+	sem := make(chan struct{}, hostLimit(host))
+	l.hosts[host] = sem
+	return sem
+}
+
+// limit for each host when the config isn't loaded
+const defaultHostLimit = 32
+
+func hostLimit(host string) int {
+	cfg := config.GetConfig()
+	if cfg == nil {
+		return defaultHostLimit
+	}
+	if limit, ok := cfg.Concurrency.Hosts[host]; ok {
+		return limit
+	}
+	return max(cfg.Concurrency.DefaultHost, 1)
+}
@@ -0,0 +1,48 @@
+package limiter
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingTransport struct {
+	inFlight, maxInFlight atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := t.inFlight.Add(1)
+	defer t.inFlight.Add(-1)
+	for {
+		current := t.maxInFlight.Load()
+		if n <= current || t.maxInFlight.CompareAndSwap(current, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return &http.Response{StatusCode: http.StatusOK, Request: req}, nil
+}
+
+func TestHostLimiter(t *testing.T) {
+	base := &countingTransport{}
+	limiter := &hostLimiter{base: base, hosts: map[string]chan struct{}{
+		"example.com": make(chan struct{}, 3),
+	}}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			req, _ := http.NewRequest(http.MethodGet, "https://example.com/image.jpg", nil)
+			if _, err := limiter.RoundTrip(req); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if n := base.maxInFlight.Load(); n != 3 {
+		t.Errorf("expected 3 requests at once, got %d", n)
+	}
+}
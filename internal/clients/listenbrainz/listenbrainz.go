@@ -0,0 +1,199 @@
+package listenbrainz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients/limiter"
+	"github.com/SongStitch/song-stitch/internal/config"
+	"github.com/SongStitch/song-stitch/internal/progress"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+var ErrNoStatistics = errors.New("statistics have not been calculated for the user yet")
+
+type Entity string
+
+const (
+	EntityReleases   Entity = "releases"
+	EntityArtists    Entity = "artists"
+	EntityRecordings Entity = "recordings"
+)
+
+// Range is the time range of the statistics, each ends at the start of the
+// current week, month or year rather than now
+type Range string
+
+const (
+	RangeWeek     Range = "week"
+	RangeMonth    Range = "month"
+	RangeQuarter  Range = "quarter"
+	RangeHalfYear Range = "half_yearly"
+	RangeYear     Range = "year"
+	RangeAllTime  Range = "all_time"
+)
+
+// MaxPageSize is the largest number of items ListenBrainz returns in a request
+const MaxPageSize = 100
+
+var defaultHTTPClient = &http.Client{
+	Timeout:   60 * time.Second,
+	Transport: limiter.Transport,
+}
+
+type Release struct {
+	ArtistName  string   `json:"artist_name"`
+	ArtistMbids []string `json:"artist_mbids"`
+	ReleaseName string   `json:"release_name"`
+	ReleaseMbid string   `json:"release_mbid"`
+	ListenCount int      `json:"listen_count"`
+	// the release the Cover Art Archive has artwork for, which may be another
+	// release in the same release group
+	CaaID          int64  `json:"caa_id"`
+	CaaReleaseMbid string `json:"caa_release_mbid"`
+}
+
+type Artist struct {
+	ArtistName  string   `json:"artist_name"`
+	ArtistMbid  string   `json:"artist_mbid"`
+	ArtistMbids []string `json:"artist_mbids"`
+	ListenCount int      `json:"listen_count"`
+}
+
+type Recording struct {
+	TrackName      string   `json:"track_name"`
+	RecordingMbid  string   `json:"recording_mbid"`
+	ArtistName     string   `json:"artist_name"`
+	ArtistMbids    []string `json:"artist_mbids"`
+	ReleaseName    string   `json:"release_name"`
+	ReleaseMbid    string   `json:"release_mbid"`
+	ListenCount    int      `json:"listen_count"`
+	CaaID          int64    `json:"caa_id"`
+	CaaReleaseMbid string   `json:"caa_release_mbid"`
+}
+
+type Statistics struct {
+	Payload struct {
+		Releases            []Release   `json:"releases"`
+		Artists             []Artist    `json:"artists"`
+		Recordings          []Recording `json:"recordings"`
+		TotalReleaseCount   int         `json:"total_release_count"`
+		TotalArtistCount    int         `json:"total_artist_count"`
+		TotalRecordingCount int         `json:"total_recording_count"`
+	} `json:"payload"`
+}
+
+func (s *Statistics) items() int {
+	return len(s.Payload.Releases) + len(s.Payload.Artists) + len(s.Payload.Recordings)
+}
+
+func (s *Statistics) total() int {
+	return s.Payload.TotalReleaseCount + s.Payload.TotalArtistCount + s.Payload.TotalRecordingCount
+}
+
+// GetUserStatistics fetches pages of the user's top entities until the handler
+// reports count items fetched or there are none left
+func GetUserStatistics(
+	ctx context.Context,
+	entity Entity,
+	username string,
+	timeRange Range,
+	count int,
+	pageSize int,
+	handler func(stats Statistics) (fetched int, err error),
+) error {
+	cfg := config.GetConfig()
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("Fetching ListenBrainz data")
+
+	// the username is a single path segment, which can't be cleaned away
+	if username == "" || username == "." || username == ".." {
+		return ErrUserNotFound
+	}
+	u, err := url.Parse(cfg.ListenBrainz.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid listenbrainz endpoint: %w", err)
+	}
+	u = u.JoinPath("1", "stats", "user", url.PathEscape(username), string(entity))
+
+	pageSize = min(max(pageSize, 1), MaxPageSize)
+	totalFetched := 0
+	offset := 0
+	for count > totalFetched {
+		logger.Info().
+			Int("offset", offset).
+			Int("totalFetched", totalFetched).
+			Int("count", count).
+			Msg("Fetching ListenBrainz page")
+
+		q := url.Values{}
+		q.Set("range", string(timeRange))
+		q.Set("count", strconv.Itoa(pageSize))
+		q.Set("offset", strconv.Itoa(offset))
+		u.RawQuery = q.Encode()
+
+		stats, err := getStatistics(ctx, u.String())
+		if err != nil {
+			return err
+		}
+		progress.FromContext(ctx).PageFetched()
+		totalFetched, err = handler(stats)
+		if err != nil {
+			return err
+		}
+
+		offset += pageSize
+		if stats.items() == 0 || offset >= stats.total() {
+			break
+		}
+	}
+	return nil
+}
+
+func getStatistics(ctx context.Context, url string) (Statistics, error) {
+	logger := zerolog.Ctx(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Statistics{}, err
+	}
+	if token := config.GetConfig().ListenBrainz.Token; token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	start := time.Now()
+	res, err := defaultHTTPClient.Do(req)
+	if err != nil {
+		return Statistics{}, err
+	}
+	defer res.Body.Close()
+
+	logger.Info().
+		Dur("duration", time.Since(start)).
+		Int("status", res.StatusCode).
+		Msg("ListenBrainz request completed")
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Statistics{}, ErrUserNotFound
+	case http.StatusNoContent:
+		// statistics are calculated periodically, so new users have none
+		return Statistics{}, ErrNoStatistics
+	default:
+		return Statistics{}, fmt.Errorf("listenbrainz unexpected status code: %d", res.StatusCode)
+	}
+
+	var stats Statistics
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		return Statistics{}, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,140 @@
+package listenbrainz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+// statisticsServer serves total releases in pages, recording the paths and
+// offsets requested
+func statisticsServer(t *testing.T, total int, paths *[]string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*paths = append(*paths, r.URL.EscapedPath()+"?offset="+r.URL.Query().Get("offset"))
+		switch r.URL.Query().Get("range") {
+		case "missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "pending":
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+		var stats Statistics
+		for i := offset; i < min(offset+count, total); i++ {
+			stats.Payload.Releases = append(stats.Payload.Releases, Release{
+				ReleaseName: fmt.Sprintf("Release %d", i),
+			})
+		}
+		stats.Payload.TotalReleaseCount = total
+		json.NewEncoder(w).Encode(stats)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("LASTFM_ENDPOINT", "http://localhost")
+	t.Setenv("LASTFM_API_KEY", "key")
+	t.Setenv("FANART_API_KEY", "key")
+	t.Setenv("LISTENBRAINZ_ENDPOINT", server.URL)
+	if err := config.Init(); err != nil {
+		t.Fatalf("unable to init config: %v", err)
+	}
+}
+
+func TestGetUserStatistics(t *testing.T) {
+	testCases := map[string]struct {
+		username  string
+		timeRange Range
+		total     int
+		count     int
+		fetched   int
+		paths     []string
+		err       error
+	}{
+		"pages until the count is fetched": {
+			username:  "user",
+			timeRange: RangeWeek,
+			total:     250,
+			count:     150,
+			fetched:   200,
+			paths: []string{
+				"/1/stats/user/user/releases?offset=0",
+				"/1/stats/user/user/releases?offset=100",
+			},
+		},
+		"pages until there are none left": {
+			username:  "user",
+			timeRange: RangeWeek,
+			total:     120,
+			count:     500,
+			fetched:   120,
+			paths: []string{
+				"/1/stats/user/user/releases?offset=0",
+				"/1/stats/user/user/releases?offset=100",
+			},
+		},
+		"username is escaped": {
+			username:  "../../1/user/other",
+			timeRange: RangeWeek,
+			total:     1,
+			count:     1,
+			fetched:   1,
+			paths:     []string{"/1/stats/user/..%2F..%2F1%2Fuser%2Fother/releases?offset=0"},
+		},
+		"username can't leave the path": {
+			username:  "..",
+			timeRange: RangeWeek,
+			err:       ErrUserNotFound,
+		},
+		"user not found": {
+			username:  "user",
+			timeRange: "missing",
+			paths:     []string{"/1/stats/user/user/releases?offset=0"},
+			count:     1,
+			err:       ErrUserNotFound,
+		},
+		"statistics not calculated": {
+			username:  "user",
+			timeRange: "pending",
+			paths:     []string{"/1/stats/user/user/releases?offset=0"},
+			count:     1,
+			err:       ErrNoStatistics,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			paths := []string{}
+			statisticsServer(t, tc.total, &paths)
+
+			fetched := 0
+			err := GetUserStatistics(
+				context.Background(),
+				EntityReleases,
+				tc.username,
+				tc.timeRange,
+				tc.count,
+				MaxPageSize,
+				func(stats Statistics) (int, error) {
+					fetched += len(stats.Payload.Releases)
+					return fetched, nil
+				},
+			)
+			if err != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if fetched != tc.fetched {
+				t.Errorf("expected %d releases, got %d", tc.fetched, fetched)
+			}
+			if fmt.Sprint(paths) != fmt.Sprint(tc.paths) {
+				t.Errorf("expected requests %v, got %v", tc.paths, paths)
+			}
+		})
+	}
+}
@@ -8,3 +8,46 @@ type TrackInfo struct {
 	AlbumName string
 	ImageUrl  string
 }
+
+// Image sizes are named after the Last.fm image sizes, with ImageSizeHuge for
+// tiles larger than Last.fm serves, which are fetched from other sources
+const (
+	ImageSizeSmall      = "small"
+	ImageSizeMedium     = "medium"
+	ImageSizeLarge      = "large"
+	ImageSizeExtraLarge = "extralarge"
+	ImageSizeHuge       = "huge"
+)
+
+// imageSizes are ordered from smallest to largest, with their width in pixels
+var imageSizes = []struct {
+	size      string
+	dimension int
+}{
+	{ImageSizeSmall, 34},
+	{ImageSizeMedium, 64},
+	{ImageSizeLarge, 174},
+	{ImageSizeExtraLarge, 300},
+	{ImageSizeHuge, 640},
+}
+
+// ImageDimension returns the width in pixels of images of the size
+func ImageDimension(size string) int {
+	for _, s := range imageSizes {
+		if s.size == size {
+			return s.dimension
+		}
+	}
+	return ImageDimension(ImageSizeExtraLarge)
+}
+
+// ImageSizeForDimension returns the smallest image size at least as large as the
+// dimension, or the largest size if none are
+func ImageSizeForDimension(dimension int) string {
+	for _, s := range imageSizes {
+		if s.dimension >= dimension {
+			return s.size
+		}
+	}
+	return imageSizes[len(imageSizes)-1].size
+}
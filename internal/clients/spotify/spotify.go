@@ -182,6 +182,7 @@ func (c *SpotifyClient) doTrackRequest(
 	trackName string,
 	artistName string,
 	market string,
+	dimension int,
 ) (clients.TrackInfo, error) {
 	body, err := c.doRequest(
 		ctx,
@@ -202,32 +203,26 @@ func (c *SpotifyClient) doTrackRequest(
 
 	for _, item := range response.SearchResult.Items {
 		if strings.EqualFold(item.Artists[0].Name, artistName) {
-			for _, image := range item.Album.Images {
-				if image.Height == 300 {
-					return clients.TrackInfo{ImageUrl: image.URL, AlbumName: item.Album.Name}, nil
-				}
-			}
-			// if no images 300x300, just return the first image
-			if len(item.Album.Images) > 0 {
-				return clients.TrackInfo{
-					ImageUrl:  item.Album.Images[0].URL,
-					AlbumName: item.Album.Name,
-				}, nil
+			if imageURL := imageForDimension(item.Album.Images, dimension); imageURL != "" {
+				return clients.TrackInfo{ImageUrl: imageURL, AlbumName: item.Album.Name}, nil
 			}
 		}
 	}
 	return clients.TrackInfo{}, fmt.Errorf("track not found in market")
 }
 
+// GetTrackInfo returns the album of the track, with the smallest album image at
+// least dimension pixels wide
 func (c *SpotifyClient) GetTrackInfo(
 	ctx context.Context,
 	trackName string,
 	artistName string,
+	dimension int,
 ) (clients.TrackInfo, error) {
 	logger := zerolog.Ctx(ctx)
 	logger.Info().Str("track", trackName).Str("artist", artistName).Msg("Fetching Spotify data")
 	for _, market := range spotifyMarkets {
-		track, err := c.doTrackRequest(ctx, trackName, artistName, market)
+		track, err := c.doTrackRequest(ctx, trackName, artistName, market, dimension)
 		if err != nil {
 			logger.Warn().
 				Err(err).
@@ -249,6 +244,7 @@ func (c *SpotifyClient) doAlbumRequest(
 	albumName string,
 	artistName string,
 	market string,
+	dimension int,
 ) (clients.AlbumInfo, error) {
 	body, err := c.doRequest(
 		ctx,
@@ -269,29 +265,26 @@ func (c *SpotifyClient) doAlbumRequest(
 
 	for _, item := range response.SearchResult.Items {
 		if strings.EqualFold(item.Artists[0].Name, artistName) {
-			for _, image := range item.Images {
-				if image.Height == 300 {
-					return clients.AlbumInfo{ImageUrl: image.URL}, nil
-				}
-			}
-			// if no images 300x300, just return the first image
-			if len(item.Images) > 0 {
-				return clients.AlbumInfo{ImageUrl: item.Images[0].URL}, nil
+			if imageURL := imageForDimension(item.Images, dimension); imageURL != "" {
+				return clients.AlbumInfo{ImageUrl: imageURL}, nil
 			}
 		}
 	}
 	return clients.AlbumInfo{}, fmt.Errorf("album not found in market")
 }
 
+// GetAlbumInfo returns the smallest image of the album at least dimension pixels
+// wide
 func (c *SpotifyClient) GetAlbumInfo(
 	ctx context.Context,
 	albumName string,
 	artistName string,
+	dimension int,
 ) (clients.AlbumInfo, error) {
 	logger := zerolog.Ctx(ctx)
 	logger.Info().Str("album", albumName).Str("artist", artistName).Msg("Fetching Spotify data")
 	for _, market := range spotifyMarkets {
-		album, err := c.doAlbumRequest(ctx, albumName, artistName, market)
+		album, err := c.doAlbumRequest(ctx, albumName, artistName, market, dimension)
 		if err != nil {
 			logger.Warn().
 				Err(err).
@@ -307,3 +300,26 @@ func (c *SpotifyClient) GetAlbumInfo(
 	}
 	return clients.AlbumInfo{}, fmt.Errorf("album not found in any market")
 }
+
+// imageForDimension returns the smallest image at least dimension pixels wide, or
+// the largest image if none are. Spotify has 64, 300 and 640 pixel album images.
+func imageForDimension(images []Image, dimension int) string {
+	var best *Image
+	for i := range images {
+		image := &images[i]
+		switch {
+		case best == nil:
+			best = image
+		case best.Width < dimension:
+			if image.Width > best.Width {
+				best = image
+			}
+		case image.Width >= dimension && image.Width < best.Width:
+			best = image
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.URL
+}
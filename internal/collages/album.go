@@ -2,10 +2,7 @@ package collages
 
 import (
 	"context"
-	"encoding/json"
-	"io"
-	"strconv"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SongStitch/song-stitch/internal/cache"
@@ -28,121 +25,208 @@ type LastfmAlbum struct {
 	Attr      struct {
 		Rank string `json:"rank"`
 	} `json:"@attr"`
-	AlbumName string               `json:"name"`
-	Images    []lastfm.LastfmImage `json:"image"`
+	AlbumName string                          `json:"name"`
+	Images    lastfm.List[lastfm.LastfmImage] `json:"image"`
 }
 
 type LastfmTopAlbums struct {
 	TopAlbums struct {
-		Attr   lastfm.LastfmUser `json:"@attr"`
-		Albums []LastfmAlbum     `json:"album"`
+		Attr   lastfm.LastfmUser        `json:"@attr"`
+		Albums lastfm.List[LastfmAlbum] `json:"album"`
 	} `json:"topalbums"`
 }
 
 func GetElementsForAlbum(
 	ctx context.Context,
-	username string,
-	period lastfm.Period,
-	count int,
-	imageSize string,
+	options ElementOptions,
 	displayOptions DisplayOptions,
 	jobChan chan<- CollageElement,
 ) error {
 	config := config.GetConfig()
-	if count > config.MaxImages.Albums {
+	if options.Count > config.MaxImages.Albums {
 		return lastfm.ErrTooManyImages
 	}
-	return getAlbums(ctx, username, period, count, imageSize, jobChan)
+	return getAlbums(ctx, options, jobChan)
 }
 
 func getLastfmAlbums(
 	ctx context.Context,
+	source ListeningSource,
 	username string,
 	period lastfm.Period,
 	count int,
+	filter *itemFilter,
+	merge bool,
 ) ([]LastfmAlbum, error) {
 	albums := []LastfmAlbum{}
-	totalPages := 0
-
-	handler := func(data io.Reader) (int, int, error) {
-		var lastfmTopAlbums LastfmTopAlbums
-		err := json.NewDecoder(data).Decode(&lastfmTopAlbums)
-		if err != nil {
-			return 0, 0, err
-		}
-		albums = append(albums, lastfmTopAlbums.TopAlbums.Albums...)
-		if totalPages == 0 {
-			total, err := strconv.Atoi(lastfmTopAlbums.TopAlbums.Attr.TotalPages)
-			if err != nil {
-				return 0, 0, err
-			}
-			totalPages = total
+	handler := func(page []LastfmAlbum) (int, error) {
+		page = filterItems(ctx, filter, page, count-len(albums), describeAlbum)
+		albums = append(albums, page...)
+		// editions can be spread across pages, so the whole list is merged each time
+		if merge {
+			albums = mergeEditions(albums)
 		}
-		return len(albums), totalPages, nil
+		return filter.fetched(len(albums), count), nil
 	}
-	err := lastfm.GetLastFmResponse(ctx, lastfm.MethodAlbum, username, period, count, handler)
+	err := source.TopAlbums(ctx, username, period, count, filteredPageSize(count, filter), handler)
 	if err != nil {
 		return nil, err
 	}
-	return albums, nil
+	return albums[:min(len(albums), count)], nil
+}
+
+// getLastfmAlbumsForUsers fetches the top albums of a single user, or merges the
+// top albums of several users. When merge is set, the editions of each album are
+// combined into one entry.
+func getLastfmAlbumsForUsers(ctx context.Context, options ElementOptions) ([]LastfmAlbum, error) {
+	filter := newItemFilter(options.Filter)
+	source := listeningSource(options.Source)
+	if len(options.Usernames) == 1 {
+		return getLastfmAlbums(
+			ctx,
+			source,
+			options.Usernames[0],
+			options.Period,
+			options.Count,
+			filter,
+			options.MergeEditions,
+		)
+	}
+	fetch := func(username string) ([]LastfmAlbum, error) {
+		return getLastfmAlbums(
+			ctx,
+			source,
+			username,
+			options.Period,
+			options.Count,
+			filter,
+			options.MergeEditions,
+		)
+	}
+	lists, err := fetchForUsers(ctx, options.Usernames, fetch)
+	if err != nil {
+		return nil, err
+	}
+	merged := mergeLists(lists, options.Count, func(album LastfmAlbum) (string, string) {
+		return album.Mbid, albumKey(album)
+	}, func(album *LastfmAlbum) *string {
+		return &album.Playcount
+	}, options.Aggregation)
+	return merged[:min(len(merged), options.Count)], nil
 }
 
 func getAlbums(
 	ctx context.Context,
-	username string,
-	period lastfm.Period,
-	count int,
-	imageSize string,
+	options ElementOptions,
 	jobChan chan<- CollageElement,
 ) error {
-	albums, err := getLastfmAlbums(ctx, username, period, count)
-	if err != nil {
-		return err
+	start := time.Now()
+	var cacheCount int64
+	if options.streamable() {
+		count, err := streamAlbums(ctx, listeningSource(options.Source), options, jobChan)
+		if err != nil {
+			return err
+		}
+		cacheCount = count
+	} else {
+		albums, err := getLastfmAlbumsForUsers(ctx, options)
+		if err != nil {
+			return err
+		}
+		albums = fitItems(&options, albums, func(album LastfmAlbum) int {
+			return parsePlaycount(album.Playcount)
+		})
+		cacheCount = getAlbumElements(ctx, albums, options.ImageSize, jobChan)
 	}
-	cacheCount := 0
 
-	logger := zerolog.Ctx(ctx)
-
-	var wg sync.WaitGroup
-	wg.Add(len(albums))
-	start := time.Now()
-	for i, lastfmAlbum := range albums {
-		go func(i int, lastfmAlbum LastfmAlbum) {
-			defer wg.Done()
-			album := parseLastfmAlbum(ctx, lastfmAlbum, imageSize, &cacheCount)
-
-			img, ext, err := DownloadImageWithRetry(ctx, album.ImageUrl)
-			if err != nil {
-				logger.Error().
-					Err(err).
-					Str("imageUrl", album.ImageUrl).
-					Msg("Error downloading image")
-			}
-			jobChan <- CollageElement{
-				Index:      i,
-				Parameters: album.Parameters(),
-				ImageBytes: img,
-				ImageExt:   ext,
-			}
-
-		}(i, lastfmAlbum)
-	}
-	wg.Wait()
-	logger.Info().
-		Int("cacheCount", cacheCount).
-		Str("username", username).
-		Int("totalCount", count).
+	zerolog.Ctx(ctx).Info().
+		Int64("cacheCount", cacheCount).
+		Strs("usernames", options.Usernames).
+		Int("totalCount", options.Count).
 		Dur("duration", time.Since(start)).
 		Str("method", "album").
 		Msg("Image URLs fetched")
 	return nil
 }
 
+// streamAlbums resolves the artwork for the albums of each page as it arrives,
+// so the collage is drawn while the later pages are still being fetched
+func streamAlbums(
+	ctx context.Context,
+	source ListeningSource,
+	options ElementOptions,
+	jobChan chan<- CollageElement,
+) (int64, error) {
+	var cacheCount int64
+	resolve := albumResolver(ctx, options.ImageSize, jobChan, &cacheCount)
+	pool := newItemPool(options.Count, resolve)
+	filter := newItemFilter(options.Filter)
+	fetched := 0
+	handler := func(page []LastfmAlbum) (int, error) {
+		page = filterItems(ctx, filter, page, options.Count-fetched, describeAlbum)
+		page = page[:min(len(page), options.Count-fetched)]
+		pool.add(page)
+		fetched += len(page)
+		return filter.fetched(fetched, options.Count), nil
+	}
+	err := source.TopAlbums(
+		ctx,
+		options.Usernames[0],
+		options.Period,
+		options.Count,
+		filteredPageSize(options.Count, filter),
+		handler,
+	)
+	pool.wait()
+	return atomic.LoadInt64(&cacheCount), err
+}
+
+// getAlbumElements resolves the artwork for each album and sends it as an element
+// in the same order as the albums, returning the number of image URL cache hits
+func getAlbumElements(
+	ctx context.Context,
+	albums []LastfmAlbum,
+	imageSize string,
+	jobChan chan<- CollageElement,
+) int64 {
+	var cacheCount int64
+	forEachItem(albums, albumResolver(ctx, imageSize, jobChan, &cacheCount))
+	return atomic.LoadInt64(&cacheCount)
+}
+
+// albumResolver returns a function resolving the artwork for the album at index i
+// and sending it as an element
+func albumResolver(
+	ctx context.Context,
+	imageSize string,
+	jobChan chan<- CollageElement,
+	cacheCount *int64,
+) func(i int, album LastfmAlbum) {
+	logger := zerolog.Ctx(ctx)
+	return func(i int, lastfmAlbum LastfmAlbum) {
+		album := parseLastfmAlbum(ctx, lastfmAlbum, imageSize, cacheCount)
+
+		img, ext, err := DownloadImageWithRetry(ctx, album.ImageUrl)
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("imageUrl", album.ImageUrl).
+				Msg("Error downloading image")
+		}
+		jobChan <- CollageElement{
+			Index:      i,
+			Parameters: album.Parameters(),
+			ImageBytes: img,
+			ImageExt:   ext,
+		}
+	}
+}
+
 func parseLastfmAlbum(
 	ctx context.Context,
 	album LastfmAlbum,
 	imageSize string,
-	cacheCount *int,
+	cacheCount *int64,
 ) Album {
 	logger := zerolog.Ctx(ctx)
 	newAlbum := Album{
@@ -156,7 +240,7 @@ func parseLastfmAlbum(
 	imageCache := cache.GetImageUrlCache()
 	if cacheEntry, ok := imageCache.Get(newAlbum.Identifier()); ok {
 		newAlbum.ImageUrl = cacheEntry.Url
-		(*cacheCount)++
+		atomic.AddInt64(cacheCount, 1)
 		return newAlbum
 	}
 	albumInfo, err := getAlbumInfo(ctx, album, imageSize)
@@ -178,16 +262,19 @@ func getAlbumInfo(
 	album LastfmAlbum,
 	imageSize string,
 ) (clients.AlbumInfo, error) {
-	for _, image := range album.Images {
-		if image.Size == imageSize && image.Link != "" {
-			return clients.AlbumInfo{ImageUrl: image.Link}, nil
-		}
+	if link := lastfm.ImageForSize(album.Images, imageSize); link != "" {
+		return clients.AlbumInfo{ImageUrl: link}, nil
 	}
 	client, err := spotify.GetSpotifyClient()
 	if err != nil {
 		return clients.AlbumInfo{}, err
 	}
-	albumInfo, err := client.GetAlbumInfo(ctx, album.AlbumName, album.Artist.ArtistName)
+	albumInfo, err := client.GetAlbumInfo(
+		ctx,
+		album.AlbumName,
+		album.Artist.ArtistName,
+		clients.ImageDimension(imageSize),
+	)
 	if err != nil {
 		return clients.AlbumInfo{}, err
 	}
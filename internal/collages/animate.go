@@ -0,0 +1,152 @@
+package collages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+)
+
+var ErrNoFrames = errors.New("no frames to encode")
+
+// EncodeAnimatedGif encodes the frames as a looping GIF, showing each frame for delay.
+func EncodeAnimatedGif(w io.Writer, frames []image.Image, delay time.Duration) error {
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+
+	anim := &gif.GIF{}
+	for _, frame := range frames {
+		bounds := frame.Bounds()
+		paletted := image.NewPaletted(bounds, palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, bounds, frame, bounds.Min)
+		anim.Image = append(anim.Image, paletted)
+		// GIF delays are in 100ths of a second
+		anim.Delay = append(anim.Delay, int(delay/(10*time.Millisecond)))
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// EncodeAnimatedWebp encodes each frame as a lossy WebP image and muxes them
+// into a single looping animated WebP, showing each frame for delay.
+func EncodeAnimatedWebp(
+	w io.Writer,
+	frames []image.Image,
+	delay time.Duration,
+	quality float32,
+) error {
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+
+	bounds := frames[0].Bounds()
+	hasAlpha := false
+	encodedFrames := make([][]byte, 0, len(frames))
+	for _, frame := range frames {
+		buf := new(bytes.Buffer)
+		if err := webpEncode(buf, frame, quality); err != nil {
+			return err
+		}
+		chunks, alpha, err := webpImageChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		hasAlpha = hasAlpha || alpha
+		encodedFrames = append(encodedFrames, chunks)
+	}
+
+	body := new(bytes.Buffer)
+	body.WriteString("WEBP")
+
+	// VP8X header declaring an animated image with the canvas size
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02
+	if hasAlpha {
+		vp8x[0] |= 0x10
+	}
+	putUint24(vp8x[4:], uint32(bounds.Dx()-1)) // #nosec G115
+	putUint24(vp8x[7:], uint32(bounds.Dy()-1)) // #nosec G115
+	writeChunk(body, "VP8X", vp8x)
+
+	// ANIM chunk: black background, loop forever
+	anim := make([]byte, 6)
+	binary.LittleEndian.PutUint32(anim, 0xff000000)
+	writeChunk(body, "ANIM", anim)
+
+	for i, chunks := range encodedFrames {
+		frameBounds := frames[i].Bounds()
+		anmf := make([]byte, 16, 16+len(chunks))
+		putUint24(anmf[6:], uint32(frameBounds.Dx()-1))      // #nosec G115
+		putUint24(anmf[9:], uint32(frameBounds.Dy()-1))      // #nosec G115
+		putUint24(anmf[12:], uint32(delay/time.Millisecond)) // #nosec G115
+		// do not blend with the previous frame
+		anmf[15] = 0x02
+		anmf = append(anmf, chunks...)
+		writeChunk(body, "ANMF", anmf)
+	}
+
+	header := make([]byte, 8)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(body.Len())) // #nosec G115
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// webpImageChunks returns the raw ALPH/VP8/VP8L chunks of a still WebP image
+func webpImageChunks(data []byte) ([]byte, bool, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, false, fmt.Errorf("invalid webp data")
+	}
+
+	var chunks []byte
+	hasAlpha := false
+	for offset := 12; offset+8 <= len(data); {
+		fourCC := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		end := offset + 8 + size + size%2
+		if offset+8+size > len(data) {
+			return nil, false, fmt.Errorf("truncated webp chunk %q", fourCC)
+		}
+		switch fourCC {
+		case "ALPH":
+			hasAlpha = true
+			chunks = append(chunks, data[offset:min(end, len(data))]...)
+		case "VP8 ", "VP8L":
+			chunks = append(chunks, data[offset:min(end, len(data))]...)
+		}
+		offset = end
+	}
+	if len(chunks) == 0 {
+		return nil, false, fmt.Errorf("no image data in webp")
+	}
+	if len(chunks)%2 != 0 {
+		chunks = append(chunks, 0)
+	}
+	return chunks, hasAlpha, nil
+}
+
+func writeChunk(buf *bytes.Buffer, fourCC string, data []byte) {
+	header := make([]byte, 8)
+	copy(header, fourCC)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data))) // #nosec G115
+	buf.Write(header)
+	buf.Write(data)
+	if len(data)%2 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
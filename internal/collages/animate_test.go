@@ -0,0 +1,122 @@
+package collages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+	"time"
+)
+
+func solidFrame(c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := range 8 {
+		for x := range 8 {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEncodeAnimatedGif(t *testing.T) {
+	frames := []image.Image{
+		solidFrame(color.RGBA{R: 255, A: 255}),
+		solidFrame(color.RGBA{G: 255, A: 255}),
+		solidFrame(color.RGBA{B: 255, A: 255}),
+	}
+
+	buf := new(bytes.Buffer)
+	if err := EncodeAnimatedGif(buf, frames, 1500*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	decoded, err := gif.DecodeAll(buf)
+	if err != nil {
+		t.Fatalf("unable to decode gif: %v", err)
+	}
+	if len(decoded.Image) != len(frames) {
+		t.Fatalf("expected %d frames, got %d", len(frames), len(decoded.Image))
+	}
+	for i, delay := range decoded.Delay {
+		if delay != 150 {
+			t.Errorf("frame %d: expected delay 150, got %d", i, delay)
+		}
+	}
+}
+
+func TestEncodeAnimatedGifNoFrames(t *testing.T) {
+	if err := EncodeAnimatedGif(new(bytes.Buffer), nil, time.Second); err != ErrNoFrames {
+		t.Fatalf("expected ErrNoFrames, got %v", err)
+	}
+}
+
+func TestWebpImageChunks(t *testing.T) {
+	riff := func(chunks ...[]byte) []byte {
+		body := new(bytes.Buffer)
+		body.WriteString("WEBP")
+		for _, c := range chunks {
+			body.Write(c)
+		}
+		header := make([]byte, 8)
+		copy(header, "RIFF")
+		binary.LittleEndian.PutUint32(header[4:], uint32(body.Len()))
+		return append(header, body.Bytes()...)
+	}
+	chunk := func(fourCC string, data []byte) []byte {
+		buf := new(bytes.Buffer)
+		writeChunk(buf, fourCC, data)
+		return buf.Bytes()
+	}
+
+	tests := map[string]struct {
+		data      []byte
+		expected  []byte
+		wantAlpha bool
+		wantErr   bool
+	}{
+		"lossy": {
+			data:     riff(chunk("VP8 ", []byte{1, 2, 3, 4})),
+			expected: chunk("VP8 ", []byte{1, 2, 3, 4}),
+		},
+		"extended with alpha": {
+			data: riff(
+				chunk("VP8X", make([]byte, 10)),
+				chunk("ALPH", []byte{9}),
+				chunk("VP8 ", []byte{1, 2}),
+			),
+			expected:  append(chunk("ALPH", []byte{9}), chunk("VP8 ", []byte{1, 2})...),
+			wantAlpha: true,
+		},
+		"not webp": {
+			data:    []byte("GIF89a"),
+			wantErr: true,
+		},
+		"no image data": {
+			data:    riff(chunk("EXIF", []byte{1, 2})),
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			chunks, alpha, err := webpImageChunks(tc.data)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(chunks, tc.expected) {
+				t.Errorf("chunks mismatch:\nExpected: %v\nGot:      %v", tc.expected, chunks)
+			}
+			if alpha != tc.wantAlpha {
+				t.Errorf("expected alpha %v, got %v", tc.wantAlpha, alpha)
+			}
+		})
+	}
+}
@@ -2,10 +2,6 @@ package collages
 
 import (
 	"context"
-	"encoding/json"
-	"io"
-	"strconv"
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -23,122 +19,181 @@ type LastfmArtist struct {
 	Attr      struct {
 		Rank string `json:"rank"`
 	} `json:"@attr"`
-	Name   string               `json:"name"`
-	Images []lastfm.LastfmImage `json:"image"`
+	Name   string                          `json:"name"`
+	Images lastfm.List[lastfm.LastfmImage] `json:"image"`
 }
 
 type LastfmTopArtists struct {
 	TopArtists struct {
-		Attr    lastfm.LastfmUser `json:"@attr"`
-		Artists []LastfmArtist    `json:"artist"`
+		Attr    lastfm.LastfmUser         `json:"@attr"`
+		Artists lastfm.List[LastfmArtist] `json:"artist"`
 	} `json:"topartists"`
 }
 
 func GetElementsForArtist(
 	ctx context.Context,
-	username string,
-	period lastfm.Period,
-	count int,
-	imageSize string,
+	options ElementOptions,
 	displayOptions DisplayOptions,
 	jobChan chan<- CollageElement,
 ) error {
 	config := config.GetConfig()
-	if count > config.MaxImages.Artists {
+	if options.Count > config.MaxImages.Artists {
 		return lastfm.ErrTooManyImages
 	}
-	return getArtists(ctx, username, period, count, imageSize, jobChan)
+	return getArtists(ctx, options, jobChan)
 }
 
 func getLastfmArtists(
 	ctx context.Context,
+	source ListeningSource,
 	username string,
 	period lastfm.Period,
 	count int,
+	filter *itemFilter,
 ) ([]LastfmArtist, error) {
 	artists := []LastfmArtist{}
-	totalPages := 0
+	handler := func(page []LastfmArtist) (int, error) {
+		page = filterItems(ctx, filter, page, count-len(artists), describeArtist)
+		artists = append(artists, page...)
+		return filter.fetched(len(artists), count), nil
+	}
+	err := source.TopArtists(ctx, username, period, count, filteredPageSize(count, filter), handler)
+	if err != nil {
+		return nil, err
+	}
+	return artists[:min(len(artists), count)], nil
+}
 
-	handler := func(data io.Reader) (int, int, error) {
-		var lastfmTopArtists LastfmTopArtists
-		err := json.NewDecoder(data).Decode(&lastfmTopArtists)
-		if err != nil {
-			return 0, 0, err
-		}
-		artists = append(artists, lastfmTopArtists.TopArtists.Artists...)
-		if totalPages == 0 {
-			total, err := strconv.Atoi(lastfmTopArtists.TopArtists.Attr.TotalPages)
-			if err != nil {
-				return 0, 0, err
-			}
-			totalPages = total
-		}
-		return len(artists), totalPages, nil
+// getLastfmArtistsForUsers fetches the top artists of a single user, or merges the
+// top artists of several users
+func getLastfmArtistsForUsers(ctx context.Context, options ElementOptions) ([]LastfmArtist, error) {
+	filter := newItemFilter(options.Filter)
+	source := listeningSource(options.Source)
+	fetch := func(username string) ([]LastfmArtist, error) {
+		return getLastfmArtists(ctx, source, username, options.Period, options.Count, filter)
 	}
-	err := lastfm.GetLastFmResponse(ctx, lastfm.MethodArtist, username, period, count, handler)
+	if len(options.Usernames) == 1 {
+		return fetch(options.Usernames[0])
+	}
+	lists, err := fetchForUsers(ctx, options.Usernames, fetch)
 	if err != nil {
 		return nil, err
 	}
-	return artists, nil
+	merged := mergeLists(lists, options.Count, func(artist LastfmArtist) (string, string) {
+		return artist.Mbid, artistKey(artist)
+	}, func(artist *LastfmArtist) *string {
+		return &artist.Playcount
+	}, options.Aggregation)
+	return merged[:min(len(merged), options.Count)], nil
 }
 
 func getArtists(
 	ctx context.Context,
-	username string,
-	period lastfm.Period,
-	count int,
-	imageSize string,
+	options ElementOptions,
 	jobChan chan<- CollageElement,
 ) error {
-	artists, err := getLastfmArtists(ctx, username, period, count)
-	if err != nil {
-		return err
-	}
-
-	var cacheCount int64
-	logger := zerolog.Ctx(ctx)
-
-	var wg sync.WaitGroup
-
 	start := time.Now()
-	for i, lastfmArtist := range artists {
-		wg.Add(1)
-
-		go func(i int, lastfmArtist LastfmArtist) {
-			defer wg.Done()
-
-			artist := parseLastfmArtist(ctx, lastfmArtist, imageSize, &cacheCount)
-
-			img, ext, imgErr := DownloadImageWithRetry(ctx, artist.ImageUrl)
-			if imgErr != nil {
-				logger.Error().
-					Err(imgErr).
-					Str("imageUrl", artist.ImageUrl).
-					Msg("Error downloading image")
-			}
-
-			jobChan <- CollageElement{
-				Index:      i,
-				ImageBytes: img,
-				ImageExt:   ext,
-				Parameters: artist.Parameters(),
-			}
-		}(i, lastfmArtist)
+	var cacheCount int64
+	if options.streamable() {
+		count, err := streamArtists(ctx, listeningSource(options.Source), options, jobChan)
+		if err != nil {
+			return err
+		}
+		cacheCount = count
+	} else {
+		artists, err := getLastfmArtistsForUsers(ctx, options)
+		if err != nil {
+			return err
+		}
+		artists = fitItems(&options, artists, func(artist LastfmArtist) int {
+			return parsePlaycount(artist.Playcount)
+		})
+		cacheCount = getArtistElements(ctx, artists, options.ImageSize, jobChan)
 	}
 
-	wg.Wait()
-
-	logger.Info().
-		Int64("cacheCount", atomic.LoadInt64(&cacheCount)).
-		Str("username", username).
-		Int("totalCount", count).
+	zerolog.Ctx(ctx).Info().
+		Int64("cacheCount", cacheCount).
+		Strs("usernames", options.Usernames).
+		Int("totalCount", options.Count).
 		Dur("duration", time.Since(start)).
 		Str("method", "artist").
 		Msg("Image URLs fetched")
-
 	return nil
 }
 
+// streamArtists resolves the artwork for the artists of each page as it arrives,
+// so the collage is drawn while the later pages are still being fetched
+func streamArtists(
+	ctx context.Context,
+	source ListeningSource,
+	options ElementOptions,
+	jobChan chan<- CollageElement,
+) (int64, error) {
+	var cacheCount int64
+	resolve := artistResolver(ctx, options.ImageSize, jobChan, &cacheCount)
+	pool := newItemPool(options.Count, resolve)
+	filter := newItemFilter(options.Filter)
+	fetched := 0
+	handler := func(page []LastfmArtist) (int, error) {
+		page = filterItems(ctx, filter, page, options.Count-fetched, describeArtist)
+		page = page[:min(len(page), options.Count-fetched)]
+		pool.add(page)
+		fetched += len(page)
+		return filter.fetched(fetched, options.Count), nil
+	}
+	err := source.TopArtists(
+		ctx,
+		options.Usernames[0],
+		options.Period,
+		options.Count,
+		filteredPageSize(options.Count, filter),
+		handler,
+	)
+	pool.wait()
+	return atomic.LoadInt64(&cacheCount), err
+}
+
+// getArtistElements resolves the artwork for each artist and sends it as an element
+// in the same order as the artists, returning the number of image URL cache hits
+func getArtistElements(
+	ctx context.Context,
+	artists []LastfmArtist,
+	imageSize string,
+	jobChan chan<- CollageElement,
+) int64 {
+	var cacheCount int64
+	forEachItem(artists, artistResolver(ctx, imageSize, jobChan, &cacheCount))
+	return atomic.LoadInt64(&cacheCount)
+}
+
+// artistResolver returns a function resolving the artwork for the artist at index i
+// and sending it as an element
+func artistResolver(
+	ctx context.Context,
+	imageSize string,
+	jobChan chan<- CollageElement,
+	cacheCount *int64,
+) func(i int, artist LastfmArtist) {
+	logger := zerolog.Ctx(ctx)
+	return func(i int, lastfmArtist LastfmArtist) {
+		artist := parseLastfmArtist(ctx, lastfmArtist, imageSize, cacheCount)
+
+		img, ext, err := DownloadImageWithRetry(ctx, artist.ImageUrl)
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("imageUrl", artist.ImageUrl).
+				Msg("Error downloading image")
+		}
+		jobChan <- CollageElement{
+			Index:      i,
+			Parameters: artist.Parameters(),
+			ImageBytes: img,
+			ImageExt:   ext,
+		}
+	}
+}
+
 func parseLastfmArtist(
 	ctx context.Context,
 	artist LastfmArtist,
@@ -166,7 +221,7 @@ func parseLastfmArtist(
 		}
 	}
 
-	idOrURL, err := lastfm.GetImageIdForArtist(ctx, artist.Name, artist.Mbid)
+	idOrURL, err := lastfm.GetImageIdForArtist(ctx, artist.Name, artist.Mbid, imageSize)
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -0,0 +1,189 @@
+package collages
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"slices"
+)
+
+const (
+	// number of colour clusters used when finding the dominant colour
+	dominantColourClusters = 4
+	// maximum number of k-means iterations
+	dominantColourIterations = 8
+	// the image is sampled on a grid of at most this many pixels per side
+	dominantColourSamples = 32
+)
+
+// dominantColour returns the centre of the largest colour cluster in the image,
+// found using k-means over a sampled grid of pixels.
+func dominantColour(img image.Image) color.RGBA {
+	if img == nil {
+		return color.RGBA{}
+	}
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return color.RGBA{}
+	}
+
+	stepX := max(b.Dx()/dominantColourSamples, 1)
+	stepY := max(b.Dy()/dominantColourSamples, 1)
+	samples := make([][3]float64, 0, dominantColourSamples*dominantColourSamples)
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			samples = append(samples, [3]float64{
+				float64(r >> 8),
+				float64(g >> 8),
+				float64(b >> 8),
+			})
+		}
+	}
+
+	k := min(dominantColourClusters, len(samples))
+
+	// seed the centroids with evenly spaced samples ordered by brightness so
+	// the result is deterministic
+	sorted := slices.Clone(samples)
+	slices.SortFunc(sorted, func(a, b [3]float64) int {
+		la, lb := luminance(a), luminance(b)
+		switch {
+		case la < lb:
+			return -1
+		case la > lb:
+			return 1
+		default:
+			return 0
+		}
+	})
+	centroids := make([][3]float64, k)
+	for i := range k {
+		centroids[i] = sorted[(2*i+1)*len(sorted)/(2*k)]
+	}
+
+	assignments := make([]int, len(samples))
+	counts := make([]int, k)
+	for range dominantColourIterations {
+		changed := false
+		clear(counts)
+		sums := make([][3]float64, k)
+		for i, s := range samples {
+			nearest := 0
+			nearestDistance := math.MaxFloat64
+			for c, centroid := range centroids {
+				if d := colourDistance(s, centroid); d < nearestDistance {
+					nearest = c
+					nearestDistance = d
+				}
+			}
+			if assignments[i] != nearest {
+				assignments[i] = nearest
+				changed = true
+			}
+			counts[nearest]++
+			for j := range 3 {
+				sums[nearest][j] += s[j]
+			}
+		}
+		for c := range centroids {
+			if counts[c] == 0 {
+				continue
+			}
+			for j := range 3 {
+				centroids[c][j] = sums[c][j] / float64(counts[c])
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	largest := 0
+	for c := range counts {
+		if counts[c] > counts[largest] {
+			largest = c
+		}
+	}
+	centroid := centroids[largest]
+	return color.RGBA{
+		R: uint8(math.Round(centroid[0])),
+		G: uint8(math.Round(centroid[1])),
+		B: uint8(math.Round(centroid[2])),
+		A: 255,
+	}
+}
+
+func colourDistance(a, b [3]float64) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dr*dr + dg*dg + db*db
+}
+
+// luminance returns the perceived brightness of an RGB colour in the range 0-255
+func luminance(c [3]float64) float64 {
+	return 0.299*c[0] + 0.587*c[1] + 0.114*c[2]
+}
+
+// hsv converts a colour to hue (0-360), saturation (0-1) and value (0-1)
+func hsv(c color.RGBA) (float64, float64, float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	maxC := max(r, g, b)
+	minC := min(r, g, b)
+	delta := maxC - minC
+
+	var h float64
+	switch {
+	case delta == 0:
+		h = 0
+	case maxC == r:
+		h = 60 * math.Mod((g-b)/delta, 6)
+	case maxC == g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	var s float64
+	if maxC > 0 {
+		s = delta / maxC
+	}
+	return h, s, maxC
+}
+
+// averageColour returns the mean colour of the image, sampled on a grid
+func averageColour(img image.Image) color.RGBA {
+	if img == nil {
+		return color.RGBA{}
+	}
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return color.RGBA{}
+	}
+
+	stepX := max(b.Dx()/dominantColourSamples, 1)
+	stepY := max(b.Dy()/dominantColourSamples, 1)
+	var sum [3]float64
+	n := 0
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum[0] += float64(r >> 8)
+			sum[1] += float64(g >> 8)
+			sum[2] += float64(b >> 8)
+			n++
+		}
+	}
+	return color.RGBA{
+		R: uint8(math.Round(sum[0] / float64(n))),
+		G: uint8(math.Round(sum[1] / float64(n))),
+		B: uint8(math.Round(sum[2] / float64(n))),
+		A: 255,
+	}
+}
+
+func rgbToVector(c color.RGBA) [3]float64 {
+	return [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+}
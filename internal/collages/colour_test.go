@@ -0,0 +1,116 @@
+package collages
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestHsv(t *testing.T) {
+	testCases := map[string]struct {
+		colour                 color.RGBA
+		hue, saturation, value float64
+	}{
+		"red":     {colour: color.RGBA{R: 255, A: 255}, hue: 0, saturation: 1, value: 1},
+		"green":   {colour: color.RGBA{G: 255, A: 255}, hue: 120, saturation: 1, value: 1},
+		"blue":    {colour: color.RGBA{B: 255, A: 255}, hue: 240, saturation: 1, value: 1},
+		"magenta": {colour: color.RGBA{R: 255, B: 255, A: 255}, hue: 300, saturation: 1, value: 1},
+		"black":   {colour: color.RGBA{A: 255}, hue: 0, saturation: 0, value: 0},
+		"white": {
+			colour:     color.RGBA{R: 255, G: 255, B: 255, A: 255},
+			hue:        0,
+			saturation: 0,
+			value:      1,
+		},
+		"dark orange": {
+			colour:     color.RGBA{R: 128, G: 64, A: 255},
+			hue:        30,
+			saturation: 1,
+			value:      128.0 / 255,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			hue, saturation, value := hsv(tc.colour)
+			if !approxEqual(hue, tc.hue) ||
+				!approxEqual(saturation, tc.saturation) ||
+				!approxEqual(value, tc.value) {
+				t.Errorf(
+					"expected %v, %v, %v, got %v, %v, %v",
+					tc.hue,
+					tc.saturation,
+					tc.value,
+					hue,
+					saturation,
+					value,
+				)
+			}
+		})
+	}
+}
+
+func TestLuminance(t *testing.T) {
+	testCases := map[string]struct {
+		colour   [3]float64
+		expected float64
+	}{
+		"black": {colour: [3]float64{0, 0, 0}, expected: 0},
+		"white": {colour: [3]float64{255, 255, 255}, expected: 255},
+		"red":   {colour: [3]float64{255, 0, 0}, expected: 76.245},
+		"green": {colour: [3]float64{0, 255, 0}, expected: 149.685},
+		"blue":  {colour: [3]float64{0, 0, 255}, expected: 29.07},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if result := luminance(tc.colour); !approxEqual(result, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestDominantColour(t *testing.T) {
+	red := color.RGBA{R: 220, G: 20, B: 20, A: 255}
+	blue := color.RGBA{R: 20, G: 20, B: 220, A: 255}
+
+	// split is an image of the first colour with the bottom quarter the second
+	split := func(first, second color.RGBA) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+		for y := range 64 {
+			for x := range 64 {
+				if y < 48 {
+					img.SetRGBA(x, y, first)
+				} else {
+					img.SetRGBA(x, y, second)
+				}
+			}
+		}
+		return img
+	}
+
+	pixel := split(blue, blue).(*image.RGBA).SubImage(image.Rect(3, 3, 4, 4))
+
+	testCases := map[string]struct {
+		img      image.Image
+		expected color.RGBA
+	}{
+		"nil":         {img: nil, expected: color.RGBA{}},
+		"empty":       {img: image.NewRGBA(image.Rectangle{}), expected: color.RGBA{}},
+		"solid":       {img: split(red, red), expected: red},
+		"mostly red":  {img: split(red, blue), expected: red},
+		"mostly blue": {img: split(blue, red), expected: blue},
+		"one pixel":   {img: pixel, expected: blue},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if result := dominantColour(tc.img); result != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
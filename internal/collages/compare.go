@@ -0,0 +1,416 @@
+package collages
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"image"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients"
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+const (
+	compareTileDimension = 150
+	compareGutter        = 30
+	compareHeaderHeight  = 200
+)
+
+type CompareOptions struct {
+	Username1 string
+	Username2 string
+	Method    lastfm.Method
+	Period    lastfm.Period
+	// number of top items fetched for each user
+	Count int
+	// grid size of each of the three sections
+	Rows      int
+	Columns   int
+	FontSize  float64
+	BoldFont  bool
+	PlayCount bool
+	Webp      bool
+}
+
+// comparison is the result of comparing two users' top lists, holding the index of
+// each item in the users' lists
+type comparison struct {
+	shared [][2]int
+	only1  []int
+	only2  []int
+	// weighted overlap of the listening shares of both users, from 0 to 100
+	score int
+}
+
+// itemKey identifies an item across users by its MBID, if it has one, or its
+// normalised name
+type itemKey struct {
+	mbid string
+	name string
+}
+
+// compareLists finds the shared and unique items of two ranked lists, matching
+// items by MBID or by name. The shared items are ordered by their combined rank.
+func compareLists(keys1, keys2 []itemKey, plays1, plays2 []int) comparison {
+	mbids2 := make(map[string]int, len(keys2))
+	names2 := make(map[string]int, len(keys2))
+	for i, key := range keys2 {
+		if _, ok := mbids2[key.mbid]; !ok && key.mbid != "" {
+			mbids2[key.mbid] = i
+		}
+		if _, ok := names2[key.name]; !ok {
+			names2[key.name] = i
+		}
+	}
+
+	total1, total2 := 0, 0
+	for _, p := range plays1 {
+		total1 += p
+	}
+	for _, p := range plays2 {
+		total2 += p
+	}
+
+	result := comparison{}
+	matched2 := make(map[int]bool)
+	overlap := 0.0
+	for i, key := range keys1 {
+		j, ok := -1, false
+		if key.mbid != "" {
+			j, ok = mbids2[key.mbid]
+		}
+		if !ok || matched2[j] {
+			j, ok = names2[key.name]
+		}
+		if !ok || matched2[j] {
+			result.only1 = append(result.only1, i)
+			continue
+		}
+		matched2[j] = true
+		result.shared = append(result.shared, [2]int{i, j})
+		if total1 > 0 && total2 > 0 {
+			overlap += min(float64(plays1[i])/float64(total1), float64(plays2[j])/float64(total2))
+		}
+	}
+	for j := range keys2 {
+		if !matched2[j] {
+			result.only2 = append(result.only2, j)
+		}
+	}
+
+	slices.SortStableFunc(result.shared, func(a, b [2]int) int {
+		return cmp.Compare(a[0]+a[1], b[0]+b[1])
+	})
+
+	result.score = int(overlap*100 + 0.5)
+	return result
+}
+
+func parsePlaycount(playcount string) int {
+	n, err := strconv.Atoi(playcount)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// fetchForUsers fetches a top list for each user concurrently, returning the lists in
+// the same order as the usernames
+func fetchForUsers[T any](
+	ctx context.Context,
+	usernames []string,
+	fetch func(username string) ([]T, error),
+) ([][]T, error) {
+	lists := make([][]T, len(usernames))
+	errs := make([]error, len(usernames))
+	var wg sync.WaitGroup
+	for i, username := range usernames {
+		wg.Go(func() {
+			lists[i], errs[i] = fetch(username)
+		})
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("username", usernames[i]).Msg("Fetch failed")
+			return nil, err
+		}
+	}
+	return lists, nil
+}
+
+// splitComparison compares the two lists and returns up to perSection of user 1's
+// unique items, the shared items with their combined playcount, and user 2's
+// unique items, along with the compatibility score
+func splitComparison[T any](
+	list1, list2 []T,
+	keys func(T) (mbid string, name string),
+	playcount func(*T) *string,
+	perSection int,
+) ([3][]T, int) {
+	keys1, plays1 := make([]itemKey, len(list1)), make([]int, len(list1))
+	for i := range list1 {
+		keys1[i].mbid, keys1[i].name = keys(list1[i])
+		plays1[i] = parsePlaycount(*playcount(&list1[i]))
+	}
+	keys2, plays2 := make([]itemKey, len(list2)), make([]int, len(list2))
+	for i := range list2 {
+		keys2[i].mbid, keys2[i].name = keys(list2[i])
+		plays2[i] = parsePlaycount(*playcount(&list2[i]))
+	}
+	result := compareLists(keys1, keys2, plays1, plays2)
+
+	var groups [3][]T
+	for _, i := range result.only1[:min(len(result.only1), perSection)] {
+		groups[0] = append(groups[0], list1[i])
+	}
+	for _, pair := range result.shared[:min(len(result.shared), perSection)] {
+		item := list1[pair[0]]
+		*playcount(&item) = strconv.Itoa(plays1[pair[0]] + plays2[pair[1]])
+		groups[1] = append(groups[1], item)
+	}
+	for _, j := range result.only2[:min(len(result.only2), perSection)] {
+		groups[2] = append(groups[2], list2[j])
+	}
+	return groups, result.score
+}
+
+// CreateComparison renders a collage comparing two users' top albums or artists,
+// with the shared items in the middle and each user's unique favourites on their side
+func CreateComparison(
+	ctx context.Context,
+	options CompareOptions,
+) (image.Image, *bytes.Buffer, error) {
+	start := time.Now()
+	logger := zerolog.Ctx(ctx)
+	cfg := config.GetConfig()
+
+	perSection := options.Rows * options.Columns
+	var sections [3]func(chan<- CollageElement)
+	var score int
+
+	// the three sections side by side are fitted within the pixel budget, the
+	// header and gutters being small next to them
+	budget := DisplayOptions{
+		Rows:           options.Rows,
+		Columns:        3 * options.Columns,
+		ImageDimension: compareTileDimension,
+	}
+	if err := FitPixelBudget(&budget); err != nil {
+		return nil, nil, err
+	}
+	dimension := budget.ImageDimension
+	imageSize := clients.ImageSizeForDimension(dimension)
+	usernames := []string{options.Username1, options.Username2}
+
+	switch options.Method {
+	case lastfm.MethodAlbum:
+		if options.Count > cfg.MaxImages.Albums {
+			return nil, nil, lastfm.ErrTooManyImages
+		}
+		lists, err := fetchForUsers(ctx, usernames, func(username string) ([]LastfmAlbum, error) {
+			return getLastfmAlbums(
+				ctx,
+				listeningSource(SourceLastfm),
+				username,
+				options.Period,
+				options.Count,
+				nil,
+				false,
+			)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		groups, s := splitComparison(
+			lists[0],
+			lists[1],
+			func(album LastfmAlbum) (string, string) {
+				return album.Mbid, albumKey(album)
+			},
+			func(album *LastfmAlbum) *string {
+				return &album.Playcount
+			},
+			perSection,
+		)
+		score = s
+		for i, albums := range groups {
+			sections[i] = func(jobChan chan<- CollageElement) {
+				getAlbumElements(ctx, albums, imageSize, jobChan)
+			}
+		}
+	case lastfm.MethodArtist:
+		if options.Count > cfg.MaxImages.Artists {
+			return nil, nil, lastfm.ErrTooManyImages
+		}
+		lists, err := fetchForUsers(ctx, usernames, func(username string) ([]LastfmArtist, error) {
+			return getLastfmArtists(
+				ctx,
+				listeningSource(SourceLastfm),
+				username,
+				options.Period,
+				options.Count,
+				nil,
+			)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		groups, s := splitComparison(
+			lists[0],
+			lists[1],
+			func(artist LastfmArtist) (string, string) {
+				return artist.Mbid, artistKey(artist)
+			},
+			func(artist *LastfmArtist) *string {
+				return &artist.Playcount
+			},
+			perSection,
+		)
+		score = s
+		for i, artists := range groups {
+			sections[i] = func(jobChan chan<- CollageElement) {
+				getArtistElements(ctx, artists, imageSize, jobChan)
+			}
+		}
+	default:
+		return nil, nil, lastfm.ErrInvalidMethod
+	}
+
+	displayOptions := DisplayOptions{
+		TextLocation:   lastfm.LocationTopLeft,
+		ImageDimension: dimension,
+		Columns:        options.Columns,
+		Rows:           options.Rows,
+		FontSize:       options.FontSize,
+		BoldFont:       options.BoldFont,
+		PlayCount:      options.PlayCount,
+		ArtistName:     true,
+		AlbumName:      true,
+	}
+
+	var sectionImages [3]image.Image
+	var wg sync.WaitGroup
+	for i, getElements := range sections {
+		wg.Go(func() {
+			tiles := collectTiles(ctx, perSection, dimension, getElements)
+			sectionImages[i] = drawSection(tiles, displayOptions)
+		})
+	}
+	wg.Wait()
+
+	sectionWidth := options.Columns * dimension
+	width := 3*sectionWidth + 4*compareGutter
+	height := compareHeaderHeight + options.Rows*dimension + compareGutter
+	dc := gg.NewContext(width, height)
+	dc.SetRGB255(0x12, 0x12, 0x1c)
+	dc.Clear()
+
+	dc.SetRGB(1, 1, 1)
+	if err := dc.LoadFontFace(fontFileBold, 40); err != nil {
+		return nil, nil, err
+	}
+	title := truncateToWidth(
+		dc,
+		options.Username1+" vs "+options.Username2,
+		float64(width-2*compareGutter),
+	)
+	dc.DrawStringAnchored(title, float64(width)/2, 65, 0.5, 0)
+	if err := dc.LoadFontFace(fontFileRegular, 28); err != nil {
+		return nil, nil, err
+	}
+	dc.SetRGB255(0xd5, 0x10, 0x07)
+	dc.DrawStringAnchored(
+		fmt.Sprintf("%d%% taste compatibility", score),
+		float64(width)/2,
+		110,
+		0.5,
+		0,
+	)
+
+	labels := [3]string{options.Username1, "Shared", options.Username2}
+	if err := dc.LoadFontFace(fontFileBold, 24); err != nil {
+		return nil, nil, err
+	}
+	for i, sectionImage := range sectionImages {
+		x := compareGutter + i*(sectionWidth+compareGutter)
+		dc.SetRGBA(1, 1, 1, 0.8)
+		label := truncateToWidth(dc, labels[i], float64(sectionWidth))
+		dc.DrawStringAnchored(label, float64(x+sectionWidth/2), compareHeaderHeight-25, 0.5, 0)
+		dc.DrawImage(sectionImage, x, compareHeaderHeight)
+	}
+
+	collage := dc.Image()
+	buffer := new(bytes.Buffer)
+	if options.Webp {
+		logger.Info().Msg("Converting to Webp image")
+		if err := webpEncode(buffer, collage, CompressionQuality); err != nil {
+			logger.Err(err).Msg("Unable to create Webp image")
+		}
+	}
+
+	logger.Info().
+		Dur("duration", time.Since(start)).
+		Int("score", score).
+		Msg("Comparison created")
+	return collage, buffer, nil
+}
+
+// collectTiles decodes the elements produced by getElements into tiles ordered by index
+func collectTiles(
+	ctx context.Context,
+	count int,
+	dimension int,
+	getElements func(jobChan chan<- CollageElement),
+) []tile {
+	jobChan := make(chan CollageElement, count)
+	getElements(jobChan)
+	close(jobChan)
+
+	tiles := make([]tile, 0, count)
+	for element := range jobChan {
+		t := tile{element: element}
+		img, err := getImage(element.ImageBytes, element.ImageExt)
+		if err != nil {
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Int("index", element.Index).
+				Msg("failed parsing image")
+		}
+		t.img = normaliseToSquare(img, dimension)
+		tiles = append(tiles, t)
+	}
+	sortTiles(tiles, SortRank, nil)
+	return tiles
+}
+
+// drawSection draws the tiles in a grid on their own canvas
+func drawSection(tiles []tile, displayOptions DisplayOptions) image.Image {
+	dc := gg.NewContext(
+		displayOptions.Columns*displayOptions.ImageDimension,
+		displayOptions.Rows*displayOptions.ImageDimension,
+	)
+	dc.SetRGBA(1, 1, 1, 0.05)
+	dc.Clear()
+	fontFile := fontFileRegular
+	if displayOptions.BoldFont {
+		fontFile = fontFileBold
+	}
+	dc.LoadFontFace(fontFile, displayOptions.FontSize)
+
+	var mu sync.Mutex
+	for i, t := range tiles {
+		drawTile(dc, &mu, t, i, displayOptions)
+	}
+	return dc.Image()
+}
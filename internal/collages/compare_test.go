@@ -0,0 +1,192 @@
+package collages
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+)
+
+func TestCompareLists(t *testing.T) {
+	named := func(names ...string) []itemKey {
+		keys := make([]itemKey, len(names))
+		for i, name := range names {
+			keys[i] = itemKey{name: name}
+		}
+		return keys
+	}
+
+	testCases := map[string]struct {
+		keys1, keys2   []itemKey
+		plays1, plays2 []int
+		expected       comparison
+	}{
+		"matched by name": {
+			keys1:  named("ride", "slowdive"),
+			keys2:  named("slowdive", "lush"),
+			plays1: []int{10, 10},
+			plays2: []int{10, 10},
+			expected: comparison{
+				shared: [][2]int{{1, 0}},
+				only1:  []int{0},
+				only2:  []int{1},
+				score:  50,
+			},
+		},
+		"matched by mbid": {
+			keys1:    []itemKey{{mbid: "1", name: "loveless"}},
+			keys2:    []itemKey{{mbid: "1", name: "loveless (remastered)"}},
+			plays1:   []int{5},
+			plays2:   []int{20},
+			expected: comparison{shared: [][2]int{{0, 0}}, score: 100},
+		},
+		"different mbids matched by name": {
+			keys1:    []itemKey{{mbid: "1", name: "loveless"}},
+			keys2:    []itemKey{{mbid: "2", name: "loveless"}},
+			plays1:   []int{5},
+			plays2:   []int{5},
+			expected: comparison{shared: [][2]int{{0, 0}}, score: 100},
+		},
+		"mbid preferred over name": {
+			keys1: []itemKey{{mbid: "1", name: "souvlaki"}},
+			keys2: []itemKey{
+				{mbid: "2", name: "souvlaki"},
+				{mbid: "1", name: "souvlaki (deluxe)"},
+			},
+			plays1:   []int{5},
+			plays2:   []int{5, 5},
+			expected: comparison{shared: [][2]int{{0, 1}}, only2: []int{0}, score: 50},
+		},
+		"each item matched once": {
+			keys1:    named("ride", "ride"),
+			keys2:    named("ride"),
+			plays1:   []int{5, 5},
+			plays2:   []int{5},
+			expected: comparison{shared: [][2]int{{0, 0}}, only1: []int{1}, score: 50},
+		},
+		"shared ordered by combined rank": {
+			keys1:  named("ride", "lush", "slowdive"),
+			keys2:  named("slowdive", "pale saints", "lush"),
+			plays1: []int{3, 2, 1},
+			plays2: []int{3, 2, 1},
+			expected: comparison{
+				shared: [][2]int{{2, 0}, {1, 2}},
+				only1:  []int{0},
+				only2:  []int{1},
+				score:  33,
+			},
+		},
+		"nothing shared": {
+			keys1:    named("ride"),
+			keys2:    named("lush"),
+			plays1:   []int{5},
+			plays2:   []int{5},
+			expected: comparison{only1: []int{0}, only2: []int{0}},
+		},
+		"no plays": {
+			keys1:    named("ride"),
+			keys2:    named("ride"),
+			plays1:   []int{0},
+			plays2:   []int{0},
+			expected: comparison{shared: [][2]int{{0, 0}}},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := compareLists(tc.keys1, tc.keys2, tc.plays1, tc.plays2)
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestSplitComparison(t *testing.T) {
+	artist := func(name, mbid, playcount string) LastfmArtist {
+		return LastfmArtist{Name: name, Mbid: mbid, Playcount: playcount}
+	}
+	keys := func(artist LastfmArtist) (string, string) {
+		return artist.Mbid, artistKey(artist)
+	}
+	playcount := func(artist *LastfmArtist) *string {
+		return &artist.Playcount
+	}
+	names := func(artists []LastfmArtist) []string {
+		result := make([]string, len(artists))
+		for i, artist := range artists {
+			result[i] = artist.Name + ":" + artist.Playcount
+		}
+		return result
+	}
+
+	list1 := []LastfmArtist{
+		artist("Slowdive", "1", "40"),
+		artist("Ride", "", "30"),
+		artist("Lush", "", "20"),
+		artist("Chapterhouse", "", "10"),
+		artist("Swervedriver", "", "5"),
+	}
+	list2 := []LastfmArtist{
+		artist("slowdive ", "", "10"),
+		artist("Pale Saints", "", "10"),
+		artist("My Bloody Valentine", "2", "10"),
+		artist("LUSH", "", "10"),
+		artist("Catherine Wheel", "", "10"),
+		artist("Moose", "", "10"),
+	}
+
+	testCases := map[string]struct {
+		perSection int
+		expected   [3][]string
+	}{
+		"every item": {
+			perSection: 10,
+			expected: [3][]string{
+				{"Ride:30", "Chapterhouse:10", "Swervedriver:5"},
+				{"Slowdive:50", "Lush:30"},
+				{"Pale Saints:10", "My Bloody Valentine:10", "Catherine Wheel:10", "Moose:10"},
+			},
+		},
+		"limited sections": {
+			perSection: 1,
+			expected:   [3][]string{{"Ride:30"}, {"Slowdive:50"}, {"Pale Saints:10"}},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			groups, score := splitComparison(list1, list2, keys, playcount, tc.perSection)
+			for i, group := range groups {
+				if result := names(group); !reflect.DeepEqual(result, tc.expected[i]) {
+					t.Errorf("section %d: expected %v, got %v", i, tc.expected[i], result)
+				}
+			}
+			// the shared artists are each a sixth of the second user's plays, less
+			// than their share of the first user's
+			if score != 33 {
+				t.Errorf("expected score 33, got %d", score)
+			}
+		})
+	}
+	if list1[0].Playcount != "40" {
+		t.Errorf("expected the lists to be unchanged, got %v", list1[0].Playcount)
+	}
+}
+
+func TestCreateComparisonPixelBudget(t *testing.T) {
+	initRenderConfig(t, 1_000_000, 0)
+	options := CompareOptions{
+		Username1: "user1",
+		Username2: "user2",
+		Method:    lastfm.MethodAlbum,
+		Count:     10,
+		Rows:      20,
+		Columns:   20,
+	}
+	// the budget is checked before either user's albums are fetched
+	_, _, err := CreateComparison(context.Background(), options)
+	if !errors.Is(err, ErrCollageTooLarge) {
+		t.Errorf("expected ErrCollageTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package collages
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// editionKeywords mark a bracketed or dashed title suffix as describing an edition
+// of the album rather than a different album
+const editionKeywords = `deluxe|remaster(?:ed)?|expanded|edition|anniversary|bonus|special|` +
+	`reissue|version|mono|stereo|legacy|collector'?s`
+
+var editionSuffixRegex = regexp.MustCompile(
+	`(?i)\s*(?:[(\[][^)\]]*\b(?:` + editionKeywords + `)\b[^)\]]*[)\]]` +
+		`|-\s*[^-()\[\]]*\b(?:` + editionKeywords + `)\b[^-()\[\]]*)$`,
+)
+
+// baseAlbumTitle strips edition suffixes such as "(Deluxe)" or "- Remastered 2011"
+// from the album title
+func baseAlbumTitle(title string) string {
+	for {
+		base := strings.TrimSpace(editionSuffixRegex.ReplaceAllString(title, ""))
+		if base == title || base == "" {
+			return title
+		}
+		title = base
+	}
+}
+
+// mergeEditions combines the editions of each album into its best ranked entry,
+// titled without the edition and with the playcounts summed, and re-sorts the
+// albums by playcount
+func mergeEditions(albums []LastfmAlbum) []LastfmAlbum {
+	merged := []LastfmAlbum{}
+	plays := []int{}
+	lookup := map[string]int{}
+	for _, album := range albums {
+		album.AlbumName = baseAlbumTitle(album.AlbumName)
+		key := albumKey(album)
+		if i, ok := lookup[key]; ok {
+			plays[i] += parsePlaycount(album.Playcount)
+			continue
+		}
+		lookup[key] = len(merged)
+		merged = append(merged, album)
+		plays = append(plays, parsePlaycount(album.Playcount))
+	}
+
+	for i := range merged {
+		merged[i].Playcount = strconv.Itoa(plays[i])
+	}
+	slices.SortStableFunc(merged, func(a, b LastfmAlbum) int {
+		return cmp.Compare(parsePlaycount(b.Playcount), parsePlaycount(a.Playcount))
+	})
+	return merged
+}
@@ -0,0 +1,55 @@
+package collages
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBaseAlbumTitle(t *testing.T) {
+	testCases := map[string]string{
+		"Abbey Road":                                "Abbey Road",
+		"Abbey Road (Remastered)":                   "Abbey Road",
+		"Rumours (Super Deluxe)":                    "Rumours",
+		"Led Zeppelin IV (Remaster)":                "Led Zeppelin IV",
+		"Nevermind [20th Anniversary Edition]":      "Nevermind",
+		"Let It Be - Remastered 2009":               "Let It Be",
+		"OK Computer OKNOTOK 1997 2017":             "OK Computer OKNOTOK 1997 2017",
+		"In Rainbows (Disk 2)":                      "In Rainbows (Disk 2)",
+		"Blue (Deluxe Edition) [Remastered 2021]":   "Blue",
+		"Stop Making Sense (Live)":                  "Stop Making Sense (Live)",
+		"(What's the Story) Morning Glory?":         "(What's the Story) Morning Glory?",
+		"The Wall - Part 1 (2011 Remastered)":       "The Wall - Part 1",
+		"Deluxe":                                    "Deluxe",
+		"Songs in the Key of Life (Deluxe Version)": "Songs in the Key of Life",
+	}
+	for title, expected := range testCases {
+		t.Run(title, func(t *testing.T) {
+			if result := baseAlbumTitle(title); result != expected {
+				t.Errorf("expected %q, got %q", expected, result)
+			}
+		})
+	}
+}
+
+func TestMergeEditions(t *testing.T) {
+	album := func(artist, name, playcount, mbid string) LastfmAlbum {
+		a := LastfmAlbum{AlbumName: name, Playcount: playcount, Mbid: mbid}
+		a.Artist.ArtistName = artist
+		return a
+	}
+	albums := []LastfmAlbum{
+		album("Fleetwood Mac", "Tusk", "50", "tusk"),
+		album("Fleetwood Mac", "Rumours (Super Deluxe)", "40", "deluxe"),
+		album("Fleetwood Mac", "Rumours", "30", "original"),
+		album("Other Artist", "Rumours", "20", "other"),
+	}
+
+	expected := []LastfmAlbum{
+		album("Fleetwood Mac", "Rumours", "70", "deluxe"),
+		album("Fleetwood Mac", "Tusk", "50", "tusk"),
+		album("Other Artist", "Rumours", "20", "other"),
+	}
+	if result := mergeEditions(albums); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
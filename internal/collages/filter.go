@@ -0,0 +1,261 @@
+package collages
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+)
+
+// Filter removes items from the fetched lists before the collage is built
+type Filter struct {
+	// artist or item names, or MBIDs, to leave out
+	Exclude []string
+	// artist names or MBIDs to keep, all artists are kept when empty
+	OnlyArtists []string
+	// genre the item's artist must be tagged with
+	Tag string
+}
+
+func (f Filter) IsEmpty() bool {
+	return len(f.Exclude) == 0 && len(f.OnlyArtists) == 0 && f.Tag == ""
+}
+
+// a filter stops fetching pages once it has scanned this many items, or looked up
+// the tags of this many artists, and the collage is built from what it found, so
+// a rare tag doesn't walk the user's whole library
+const (
+	maxFilterScan = 5000
+	maxTagLookups = 250
+)
+
+// filterItem is the part of a fetched item that filters match against
+type filterItem struct {
+	artist     string
+	artistMbid string
+	names      []string
+	mbid       string
+}
+
+// itemFilter applies a filter to fetched items, remembering the artist tags it has
+// looked up so they are only fetched once across pages and users
+type itemFilter struct {
+	exclude     map[string]bool
+	onlyArtists map[string]bool
+	tag         string
+
+	mu      sync.Mutex
+	tags    map[string][]lastfm.ArtistTag
+	scanned int
+}
+
+// newItemFilter returns nil when the filter would keep every item
+func newItemFilter(filter Filter) *itemFilter {
+	if filter.IsEmpty() {
+		return nil
+	}
+	f := &itemFilter{
+		exclude:     map[string]bool{},
+		onlyArtists: map[string]bool{},
+		tag:         normaliseName(filter.Tag),
+		tags:        map[string][]lastfm.ArtistTag{},
+	}
+	for _, value := range filter.Exclude {
+		f.exclude[normaliseName(value)] = true
+	}
+	for _, value := range filter.OnlyArtists {
+		f.onlyArtists[normaliseName(value)] = true
+	}
+	return f
+}
+
+// matches applies the name and MBID rules, which need no further requests
+func (f *itemFilter) matches(item filterItem) bool {
+	artist := normaliseName(item.artist)
+	artistMbid := strings.ToLower(item.artistMbid)
+	mbid := strings.ToLower(item.mbid)
+	for _, value := range []string{artist, artistMbid, mbid} {
+		if value != "" && f.exclude[value] {
+			return false
+		}
+	}
+	for _, name := range item.names {
+		if name != "" && f.exclude[normaliseName(name)] {
+			return false
+		}
+	}
+	if len(f.onlyArtists) > 0 && !f.onlyArtists[artist] &&
+		(artistMbid == "" || !f.onlyArtists[artistMbid]) {
+		return false
+	}
+	return true
+}
+
+// hasTag reports whether the artist is tagged with the filter's tag, looking up
+// the tags of any artists not seen before
+func (f *itemFilter) hasTag(ctx context.Context, items []filterItem) []bool {
+	f.mu.Lock()
+	unknown := []LastfmArtist{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		key := normaliseName(item.artist)
+		if _, ok := f.tags[key]; ok || seen[key] {
+			continue
+		}
+		// artists past the limit are left unknown, so are treated as untagged
+		if len(f.tags)+len(unknown) >= maxTagLookups {
+			break
+		}
+		seen[key] = true
+		unknown = append(unknown, LastfmArtist{Name: item.artist, Mbid: item.artistMbid})
+	}
+	f.mu.Unlock()
+
+	found := getArtistTags(ctx, unknown)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, artist := range unknown {
+		f.tags[artistKey(artist)] = found[artistKey(artist)]
+	}
+	result := make([]bool, len(items))
+	for i, item := range items {
+		result[i] = slices.ContainsFunc(
+			f.tags[normaliseName(item.artist)],
+			func(t lastfm.ArtistTag) bool { return t.Name == f.tag },
+		)
+	}
+	return result
+}
+
+// filterItems returns the items the filter keeps, in order. Once need items are
+// kept the remaining items are dropped, so that no more artist tags are looked up
+// than necessary.
+func filterItems[T any](
+	ctx context.Context,
+	f *itemFilter,
+	items []T,
+	need int,
+	describe func(T) filterItem,
+) []T {
+	if f == nil {
+		return items
+	}
+	f.mu.Lock()
+	f.scanned += len(items)
+	f.mu.Unlock()
+
+	kept := []T{}
+	candidates := []T{}
+	for _, item := range items {
+		if f.matches(describe(item)) {
+			candidates = append(candidates, item)
+		}
+	}
+	if f.tag == "" {
+		return candidates
+	}
+
+	// tags are looked up in batches to stop early once the grid is full
+	batchSize := 2 * tagLookupConcurrency
+	for start := 0; start < len(candidates) && len(kept) < need; start += batchSize {
+		batch := candidates[start:min(start+batchSize, len(candidates))]
+		described := make([]filterItem, len(batch))
+		for i, item := range batch {
+			described[i] = describe(item)
+		}
+		for i, ok := range f.hasTag(ctx, described) {
+			if ok {
+				kept = append(kept, batch[i])
+			}
+		}
+	}
+	return kept
+}
+
+func describeAlbum(album LastfmAlbum) filterItem {
+	return filterItem{
+		artist:     album.Artist.ArtistName,
+		artistMbid: album.Artist.Mbid,
+		names:      []string{album.AlbumName},
+		mbid:       album.Mbid,
+	}
+}
+
+func describeArtist(artist LastfmArtist) filterItem {
+	return filterItem{
+		artist:     artist.Name,
+		artistMbid: artist.Mbid,
+		names:      []string{artist.Name},
+		mbid:       artist.Mbid,
+	}
+}
+
+func describeTrack(track LastfmTrack) filterItem {
+	return filterItem{
+		artist:     track.Artist.Name,
+		artistMbid: track.Artist.Mbid,
+		names:      []string{track.Name},
+		mbid:       track.Mbid,
+	}
+}
+
+func describeRecentTrack(track LastfmRecentTrack) filterItem {
+	return filterItem{
+		artist:     track.Artist.Name,
+		artistMbid: track.Artist.Mbid,
+		names:      []string{track.Name, track.Album.Name},
+		mbid:       track.Mbid,
+	}
+}
+
+func describeLovedTrack(track LastfmLovedTrack) filterItem {
+	return filterItem{
+		artist:     track.Artist.Name,
+		artistMbid: track.Artist.Mbid,
+		names:      []string{track.Name},
+		mbid:       track.Mbid,
+	}
+}
+
+// fitItems drops the items played fewer than the minimum number of times, then
+// lets the caller size the grid to the items that are left
+func fitItems[T any](options *ElementOptions, items []T, playcount func(T) int) []T {
+	if options.MinPlays > 0 {
+		items = slices.DeleteFunc(items, func(item T) bool {
+			return playcount(item) < options.MinPlays
+		})
+	}
+	if options.Fit != nil {
+		keep, imageSize := options.Fit(len(items))
+		options.ImageSize = imageSize
+		items = items[:min(len(items), keep)]
+	}
+	return items
+}
+
+// fetched returns the number of items to report as fetched to the page loop,
+// reporting the grid as full once the filter has scanned or looked up as many
+// items as it may, so no more pages are fetched
+func (f *itemFilter) fetched(n int, count int) int {
+	if f == nil {
+		return n
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.scanned >= maxFilterScan || (f.tag != "" && len(f.tags) >= maxTagLookups) {
+		return max(n, count)
+	}
+	return n
+}
+
+// filteredPageSize is the page size used to fetch count items, fetching full pages
+// when a filter may drop items so fewer requests are needed to fill the grid
+func filteredPageSize(count int, f *itemFilter) int {
+	if f == nil {
+		return min(count, lastfm.MaxPageSize)
+	}
+	return lastfm.MaxPageSize
+}
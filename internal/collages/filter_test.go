@@ -0,0 +1,246 @@
+package collages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+func TestFilterItems(t *testing.T) {
+	album := func(artist, name, mbid string) LastfmAlbum {
+		a := LastfmAlbum{AlbumName: name, Mbid: mbid}
+		a.Artist.ArtistName = artist
+		return a
+	}
+	albums := []LastfmAlbum{
+		album("Radiohead", "OK Computer", "0b6b4ba0-d36f-47bd-b4ea-6a5b91842d29"),
+		album("The Daily", "The Daily", ""),
+		album("Slowdive", "Souvlaki", ""),
+		album("Radiohead", "Kid A", ""),
+	}
+
+	testCases := map[string]struct {
+		filter   Filter
+		expected []string
+	}{
+		"no filter": {
+			expected: []string{"OK Computer", "The Daily", "Souvlaki", "Kid A"},
+		},
+		"exclude artist case insensitive": {
+			filter:   Filter{Exclude: []string{"the  daily"}},
+			expected: []string{"OK Computer", "Souvlaki", "Kid A"},
+		},
+		"exclude album and mbid": {
+			filter: Filter{
+				Exclude: []string{"Kid A", "0B6B4BA0-D36F-47BD-B4EA-6A5B91842D29"},
+			},
+			expected: []string{"The Daily", "Souvlaki"},
+		},
+		"only artist": {
+			filter:   Filter{OnlyArtists: []string{"radiohead"}},
+			expected: []string{"OK Computer", "Kid A"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			filter := newItemFilter(tc.filter)
+			result := filterItems(context.Background(), filter, albums, len(albums), describeAlbum)
+			names := []string{}
+			for _, a := range result {
+				names = append(names, a.AlbumName)
+			}
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, names)
+			}
+		})
+	}
+}
+
+// tagServer serves artist.gettoptags with the tags of each artist by name, and
+// no tags for any other artist, returning the number of lookups made
+func tagServer(t *testing.T, tags map[string][]lastfm.ArtistTag) *atomic.Int32 {
+	t.Helper()
+	var lookups atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		var response lastfm.GetArtistTopTagsResponse
+		response.TopTags.Tags = tags[r.URL.Query().Get("artist")]
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("LASTFM_ENDPOINT", server.URL)
+	t.Setenv("LASTFM_API_KEY", "key")
+	t.Setenv("FANART_API_KEY", "key")
+	if err := config.Init(); err != nil {
+		t.Fatalf("unable to init config: %v", err)
+	}
+	return &lookups
+}
+
+func TestFilterItemsTag(t *testing.T) {
+	shoegaze := []lastfm.ArtistTag{{Name: "Shoegaze", Count: 100}}
+	lookups := tagServer(t, map[string][]lastfm.ArtistTag{
+		"Slowdive": shoegaze,
+		"Ride":     shoegaze,
+		"Radiohead": {
+			{Name: "alternative", Count: 100},
+			{Name: "shoegaze", Count: 0},
+		},
+	})
+	albums := testAlbums("Radiohead", 2, "")
+	albums = append(albums, testAlbums("Slowdive", 2, "")...)
+	albums = append(albums, testAlbums("Ride", 1, "")...)
+
+	filter := newItemFilter(Filter{Tag: "shoegaze"})
+	result := filterItems(context.Background(), filter, albums, len(albums), describeAlbum)
+	artists := []string{}
+	for _, album := range result {
+		artists = append(artists, album.Artist.ArtistName)
+	}
+	expected := []string{"Slowdive", "Slowdive", "Ride"}
+	if !reflect.DeepEqual(artists, expected) {
+		t.Errorf("expected %v, got %v", expected, artists)
+	}
+	// each artist is only looked up once
+	if lookups.Load() != 3 {
+		t.Errorf("expected 3 tag lookups, got %d", lookups.Load())
+	}
+
+	// the tags are remembered for later pages
+	filterItems(context.Background(), filter, albums, len(albums), describeAlbum)
+	if lookups.Load() != 3 {
+		t.Errorf("expected the tags to be remembered, got %d lookups", lookups.Load())
+	}
+}
+
+func TestFilterScanLimit(t *testing.T) {
+	lookups := tagServer(t, nil)
+
+	// every album is by a different artist, none of which have the tag
+	albums := []LastfmAlbum{}
+	for i := range 3 * maxFilterScan {
+		albums = append(albums, testAlbums(fmt.Sprintf("Artist %d", i), 1, "")...)
+	}
+
+	testCases := map[string]struct {
+		filter   Filter
+		maxPages int
+	}{
+		"tag": {
+			filter:   Filter{Tag: "shoegaze"},
+			maxPages: maxTagLookups/lastfm.MaxPageSize + 1,
+		},
+		"only artists": {
+			filter:   Filter{OnlyArtists: []string{"Slowdive"}},
+			maxPages: maxFilterScan / lastfm.MaxPageSize,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lookups.Store(0)
+			pages := 0
+			source := pagedSource{albums: albums, maxPageSize: lastfm.MaxPageSize, pages: &pages}
+			result, err := getLastfmAlbums(
+				context.Background(),
+				source,
+				"user",
+				lastfm.PeriodOverall,
+				10,
+				newItemFilter(tc.filter),
+				false,
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != 0 {
+				t.Errorf("expected no albums, got %d", len(result))
+			}
+			if pages > tc.maxPages {
+				t.Errorf("expected at most %d pages, got %d", tc.maxPages, pages)
+			}
+			if lookups.Load() > maxTagLookups {
+				t.Errorf("expected at most %d tag lookups, got %d", maxTagLookups, lookups.Load())
+			}
+		})
+	}
+}
+
+func TestFitItems(t *testing.T) {
+	// the grid keeps at most limit items, fetched at a smaller size once more
+	// than half of the limit are kept
+	fitTo := func(limit int) func(n int) (int, string) {
+		return func(n int) (int, string) {
+			if n > limit/2 {
+				return limit, "medium"
+			}
+			return limit, "large"
+		}
+	}
+
+	testCases := map[string]struct {
+		items     []int
+		minPlays  int
+		fit       func(n int) (int, string)
+		expected  []int
+		imageSize string
+	}{
+		"no items": {
+			items:     []int{},
+			fit:       fitTo(4),
+			expected:  []int{},
+			imageSize: "large",
+		},
+		"unchanged": {
+			items:     []int{5, 3, 1},
+			expected:  []int{5, 3, 1},
+			imageSize: "extralarge",
+		},
+		"below the minimum plays": {
+			items:     []int{5, 3, 1, 2, 0},
+			minPlays:  2,
+			expected:  []int{5, 3, 2},
+			imageSize: "extralarge",
+		},
+		"fewer than the grid": {
+			items:     []int{5, 3, 1},
+			fit:       fitTo(4),
+			expected:  []int{5, 3, 1},
+			imageSize: "medium",
+		},
+		"more than the grid": {
+			items:     []int{9, 8, 7, 6, 5},
+			fit:       fitTo(4),
+			expected:  []int{9, 8, 7, 6},
+			imageSize: "medium",
+		},
+		"fitted after the minimum plays": {
+			items:     []int{9, 1, 8, 1, 7},
+			minPlays:  2,
+			fit:       fitTo(8),
+			expected:  []int{9, 8, 7},
+			imageSize: "large",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			options := ElementOptions{MinPlays: tc.minPlays, Fit: tc.fit, ImageSize: "extralarge"}
+			items := fitItems(&options, tc.items, func(playcount int) int { return playcount })
+			if !reflect.DeepEqual(items, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, items)
+			}
+			if options.ImageSize != tc.imageSize {
+				t.Errorf("expected image size %s, got %s", tc.imageSize, options.ImageSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package collages
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+var ErrInvalidFitMode = errors.New("invalid fit mode")
+
+// FitMode is how artwork that isn't square is fitted to a tile
+type FitMode string
+
+const (
+	// the whole image is shown, letterboxed onto black
+	FitContain FitMode = "contain"
+	// the centre of the image is cropped to fill the tile
+	FitCover FitMode = "cover"
+	// the most detailed part of the image is cropped to fill the tile
+	FitSmart FitMode = "smart"
+)
+
+func GetFitModeFromStr(s string) (FitMode, error) {
+	switch s {
+	case "contain":
+		return FitContain, nil
+	case "cover":
+		return FitCover, nil
+	case "smart":
+		return FitSmart, nil
+	default:
+		return FitContain, ErrInvalidFitMode
+	}
+}
+
+// energySize is the length of the short side of the copy of an image searched for
+// the smart crop, which only needs to be detailed enough to find edges
+const energySize = 64
+
+// fitToSquare resizes the image to a square tile of size pixels, fitting it to
+// the tile with the fit mode
+func fitToSquare(img image.Image, size int, fit FitMode) image.Image {
+	if img == nil || fit == "" || fit == FitContain {
+		return normaliseToSquare(img, size)
+	}
+	b := img.Bounds()
+	if b.Dx() == b.Dy() {
+		return normaliseToSquare(img, size)
+	}
+
+	var crop image.Rectangle
+	if fit == FitSmart {
+		crop = smartCrop(img)
+	} else {
+		crop = centreCrop(b)
+	}
+	return normaliseToSquare(cropImage(img, crop), size)
+}
+
+// centreCrop returns the largest square in the centre of the bounds
+func centreCrop(b image.Rectangle) image.Rectangle {
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// smartCrop returns the largest square of the image with the most edges in it,
+// which is where the subject of a photo usually is rather than a plain background
+func smartCrop(img image.Image) image.Rectangle {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	if side <= 0 {
+		return b
+	}
+
+	// the edges are found on a small copy of the image, where the crop is the
+	// short side long
+	scale := float64(min(side, energySize)) / float64(side)
+	small := resize.Resize(
+		uint(float64(b.Dx())*scale+0.5), // #nosec G115
+		uint(float64(b.Dy())*scale+0.5), // #nosec G115
+		img,
+		resize.Bilinear,
+	)
+	energy := edgeEnergy(small)
+	window := min(small.Bounds().Dx(), small.Bounds().Dy())
+
+	// the crop slides along the long side, so only the total energy of each line
+	// across it is needed
+	horizontal := b.Dx() > b.Dy()
+	lines := energy.rows
+	if horizontal {
+		lines = energy.columns
+	}
+	offset := bestWindow(lines, window)
+	start := int(float64(offset)/scale + 0.5)
+	if horizontal {
+		start = min(b.Min.X+start, b.Max.X-side)
+		return image.Rect(start, b.Min.Y, start+side, b.Min.Y+side)
+	}
+	start = min(b.Min.Y+start, b.Max.Y-side)
+	return image.Rect(b.Min.X, start, b.Min.X+side, start+side)
+}
+
+type lineEnergy struct {
+	rows    []float64
+	columns []float64
+}
+
+// edgeEnergy returns the total gradient of the brightness along each row and
+// column of the image
+func edgeEnergy(img image.Image) lineEnergy {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	luma := make([]float64, w*h)
+	for y := range h {
+		for x := range w {
+			gray := color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray)
+			luma[y*w+x] = float64(gray.Y)
+		}
+	}
+
+	energy := lineEnergy{rows: make([]float64, h), columns: make([]float64, w)}
+	for y := range h {
+		for x := range w {
+			var e float64
+			if x+1 < w {
+				e += math.Abs(luma[y*w+x+1] - luma[y*w+x])
+			}
+			if y+1 < h {
+				e += math.Abs(luma[(y+1)*w+x] - luma[y*w+x])
+			}
+			energy.rows[y] += e
+			energy.columns[x] += e
+		}
+	}
+	return energy
+}
+
+// bestWindow returns the start of the run of window lines with the most energy,
+// preferring the one closest to the centre when they are equal
+func bestWindow(lines []float64, window int) int {
+	if window >= len(lines) {
+		return 0
+	}
+	var sum float64
+	for _, e := range lines[:window] {
+		sum += e
+	}
+	centre := (len(lines) - window) / 2
+	best, bestSum := 0, sum
+	for start := 1; start+window <= len(lines); start++ {
+		sum += lines[start+window-1] - lines[start-1]
+		closer := absInt(start-centre) < absInt(best-centre)
+		if sum > bestSum || (sum == bestSum && closer) {
+			best, bestSum = start, sum
+		}
+	}
+	return best
+}
+
+// cropImage copies the part of the image within the crop, so the tile starts at
+// the origin like every other tile
+func cropImage(img image.Image, crop image.Rectangle) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, crop.Min, draw.Src)
+	return dst
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
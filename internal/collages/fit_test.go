@@ -0,0 +1,71 @@
+package collages
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// detailedImage returns a plain image with a checkerboard within detail, like a
+// photo of a subject against a plain background
+func detailedImage(width, height int, detail image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			c := color.RGBA{R: 40, G: 40, B: 40, A: 255}
+			if (image.Point{x, y}).In(detail) && (x/4+y/4)%2 == 0 {
+				c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestSmartCrop(t *testing.T) {
+	testCases := map[string]struct {
+		img  image.Image
+		crop image.Rectangle
+	}{
+		"landscape": {
+			img:  detailedImage(300, 100, image.Rect(200, 0, 300, 100)),
+			crop: image.Rect(200, 0, 300, 100),
+		},
+		"portrait": {
+			img:  detailedImage(100, 400, image.Rect(0, 20, 100, 120)),
+			crop: image.Rect(0, 20, 100, 120),
+		},
+		"plain": {
+			img:  detailedImage(300, 100, image.Rectangle{}),
+			crop: image.Rect(100, 0, 200, 100),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			crop := smartCrop(tc.img)
+			// the edges are found on a smaller copy of the image
+			tolerance := 8
+			if crop.Dx() != tc.crop.Dx() || crop.Dy() != tc.crop.Dy() ||
+				absInt(crop.Min.X-tc.crop.Min.X) > tolerance ||
+				absInt(crop.Min.Y-tc.crop.Min.Y) > tolerance {
+				t.Errorf("expected crop %v, got %v", tc.crop, crop)
+			}
+		})
+	}
+}
+
+func TestFitToSquare(t *testing.T) {
+	img := detailedImage(300, 100, image.Rect(200, 0, 300, 100))
+	black := color.RGBA{A: 255}
+
+	contain := fitToSquare(img, 60, FitContain)
+	if contain.Bounds() != image.Rect(0, 0, 60, 60) || contain.At(30, 0) != black {
+		t.Errorf("expected the image letterboxed onto black")
+	}
+	for _, fit := range []FitMode{FitCover, FitSmart} {
+		tile := fitToSquare(img, 60, fit)
+		if tile.Bounds() != image.Rect(0, 0, 60, 60) || tile.At(30, 0) == black {
+			t.Errorf("expected %s to fill the tile", fit)
+		}
+	}
+}
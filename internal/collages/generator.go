@@ -18,6 +18,7 @@ import (
 	"github.com/SongStitch/go-webp/encoder"
 	"github.com/SongStitch/go-webp/webp"
 	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/progress"
 	"github.com/fogleman/gg"
 
 	"github.com/nfnt/resize"
@@ -41,6 +42,36 @@ type DisplayOptions struct {
 	TrackName      bool
 	Webp           bool
 	AlbumName      bool
+	Sort           SortOrder
+	Fit            FitMode
+}
+
+// ElementOptions controls which items are fetched to build the collage elements
+type ElementOptions struct {
+	// the service the top lists are fetched from, Last.fm if empty
+	Source      Source
+	Usernames   []string
+	Period      lastfm.Period
+	Count       int
+	ImageSize   string
+	Aggregation Aggregation
+	// collapse consecutive scrobbles of the same album for timeline methods
+	Dedupe bool
+	Filter Filter
+	// items played fewer times than this are left out
+	MinPlays int
+	// combine the editions of each album, e.g. deluxe and remastered releases
+	MergeEditions bool
+	// Fit is called with the number of items once they are known, returning how
+	// many of them are drawn and the image size to fetch for them
+	Fit func(n int) (int, string)
+}
+
+// streamable reports whether the artwork can be resolved as each page of the list
+// arrives, which isn't possible when the whole list is needed first to merge,
+// threshold or fit it
+func (o ElementOptions) streamable() bool {
+	return len(o.Usernames) == 1 && !o.MergeEditions && o.MinPlays == 0 && o.Fit == nil
 }
 
 type CollageElement struct {
@@ -53,7 +84,7 @@ type CollageElement struct {
 const (
 	fontFileRegular    = "./assets/NotoSans-Regular.ttf"
 	fontFileBold       = "./assets/NotoSans-Bold.ttf"
-	compressionQuality = 70
+	CompressionQuality = 70
 )
 
 func getTextOffset(dc *gg.Context, text string, displayOptions DisplayOptions) (float64, float64) {
@@ -102,6 +133,15 @@ func placeText(
 ) {
 	parameters := drawable.Parameters
 	textToDraw := []string{}
+	if parameters["nowplaying"] == "true" {
+		textToDraw = append(textToDraw, "Now playing")
+	}
+	if val, ok := parameters["tag"]; ok && len(val) > 0 {
+		textToDraw = append(textToDraw, val)
+	}
+	if val, ok := parameters["share"]; ok && len(val) > 0 {
+		textToDraw = append(textToDraw, val)
+	}
 	if val, ok := parameters["track"]; ok && displayOptions.TrackName && len(val) > 0 {
 		textToDraw = append(textToDraw, val)
 	}
@@ -114,6 +154,9 @@ func placeText(
 	if val, ok := parameters["playcount"]; ok && displayOptions.PlayCount && len(val) > 0 {
 		textToDraw = append(textToDraw, val)
 	}
+	if val, ok := parameters["date"]; ok && len(val) > 0 {
+		textToDraw = append(textToDraw, val)
+	}
 
 	if !displayOptions.TextLocation.IsTop() {
 		slices.Reverse(textToDraw)
@@ -133,13 +176,15 @@ func resizeImage(ctx context.Context, img image.Image, width uint, height uint)
 	if width == 0 && height == 0 {
 		zerolog.Ctx(ctx).Info().Msg("Unable to resize image, both width and height are 0")
 		return img
-	} else if int(width) == (img).Bounds().Dx() && int(height) == (img).Bounds().Dy() /* #nosec G115 */ {
-		return img
 	} else if height == 0 {
 		height = uint(float64(width) * float64((img).Bounds().Dy()) / float64((img).Bounds().Dx()))
 	} else if width == 0 {
 		width = uint(float64(height) * float64((img).Bounds().Dx()) / float64((img).Bounds().Dy()))
 	}
+	// tiles are already drawn at the requested size where the grid divides it
+	if int(width) == (img).Bounds().Dx() && int(height) == (img).Bounds().Dy() /* #nosec G115 */ {
+		return img
+	}
 	result := resize.Resize(width, height, img, resize.Lanczos3)
 	return result
 }
@@ -162,14 +207,16 @@ func convertToGrayscale(img image.Image) image.Image {
 	return grayImg
 }
 
-func getImage(data io.ReadCloser, extension string) (image.Image, error) {
-	isNil := func(i any) bool {
-		if i == nil {
-			return true
-		}
-		v := reflect.ValueOf(i)
-		return v.Kind() == reflect.Ptr && v.IsNil()
+// isNil reports whether the interface is nil or holds a nil pointer
+func isNil(i any) bool {
+	if i == nil {
+		return true
 	}
+	v := reflect.ValueOf(i)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+func getImage(data io.ReadCloser, extension string) (image.Image, error) {
 	if isNil(data) {
 		return nil, nil
 	}
@@ -194,6 +241,30 @@ func CreateCollage(
 ) (image.Image, *bytes.Buffer, error) {
 	start := time.Now()
 	logger := zerolog.Ctx(ctx)
+	// the grid may have been resized to fit the items since the budget was checked
+	if err := FitPixelBudget(&displayOptions); err != nil {
+		// the elements are still being sent, so they are discarded in the background
+		go func() {
+			for element := range jobChan {
+				if !isNil(element.ImageBytes) {
+					element.ImageBytes.Close()
+				}
+			}
+		}()
+		return nil, nil, err
+	}
+	tracker := progress.FromContext(ctx)
+	tracker.SetTotal(displayOptions.Rows * displayOptions.Columns)
+
+	if drawInStrips(displayOptions) {
+		collage := createStripCollage(ctx, displayOptions, jobChan)
+		logger.Info().
+			Dur("duration", time.Since(start)).
+			Int("rows", displayOptions.Rows).
+			Int("columns", displayOptions.Columns).
+			Msg("Collage artwork downloaded, drawing in strips")
+		return collage, new(bytes.Buffer), nil
+	}
 
 	collageWidth := displayOptions.ImageDimension * displayOptions.Columns
 	collageHeight := displayOptions.ImageDimension * displayOptions.Rows
@@ -205,6 +276,11 @@ func CreateCollage(
 	}
 	dc.LoadFontFace(fontFile, displayOptions.FontSize)
 
+	// When sorting by anything other than rank, every tile must be known before
+	// the positions can be decided, so tiles are collected and drawn afterwards.
+	deferPlacement := displayOptions.Sort != "" && displayOptions.Sort != SortRank
+	tiles := []tile{}
+
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	for range 5 {
@@ -214,28 +290,46 @@ func CreateCollage(
 			defer wg.Done()
 
 			for element := range jobChan {
-				i := element.Index
-
-				x := (i % displayOptions.Columns) * displayOptions.ImageDimension
-				y := (i / displayOptions.Columns) * displayOptions.ImageDimension
+				tracker.TileResolved()
+				t := tile{element: element}
 				img, err := getImage(element.ImageBytes, element.ImageExt)
 				if err != nil {
-					zerolog.Ctx(ctx).Error().Err(err).Int("index", i).Msg("failed parsing image")
+					zerolog.Ctx(ctx).
+						Error().
+						Err(err).
+						Int("index", element.Index).
+						Msg("failed parsing image")
 				} else if img != nil {
-					img = normaliseToSquare(img, displayOptions.ImageDimension)
-					dc.DrawImage(img, x, y)
+					if displayOptions.Sort.NeedsColour() {
+						t.colour = dominantColour(img)
+					}
+					t.img = fitToSquare(img, displayOptions.ImageDimension, displayOptions.Fit)
 				}
 
-				mu.Lock()
-				placeText(dc, element, displayOptions, float64(x), float64(y))
-				mu.Unlock()
+				if deferPlacement {
+					mu.Lock()
+					tiles = append(tiles, t)
+					mu.Unlock()
+					continue
+				}
+				drawTile(dc, &mu, t, element.Index, displayOptions)
+				tracker.TileRendered()
 			}
 		}()
 	}
 	wg.Wait()
+
+	if deferPlacement {
+		sortTiles(tiles, displayOptions.Sort, nil)
+		for i, t := range tiles {
+			drawTile(dc, &mu, t, i, displayOptions)
+			tracker.TileRendered()
+		}
+	}
+
 	collage := dc.Image()
 
-	if displayOptions.Resize {
+	if needsResize(displayOptions) {
 		collage = resizeImage(ctx, collage, displayOptions.Width, displayOptions.Height)
 	}
 
@@ -247,7 +341,7 @@ func CreateCollage(
 
 	if displayOptions.Webp && !displayOptions.Grayscale {
 		logger.Info().Msg("Converting to Webp image")
-		err := webpEncode(collageBuffer, collage, compressionQuality)
+		err := webpEncode(collageBuffer, collage, CompressionQuality)
 		if err != nil {
 			logger.Err(err).Msg("Unable to create Webp image")
 		}
@@ -261,6 +355,19 @@ func CreateCollage(
 	return collage, collageBuffer, nil
 }
 
+// drawTile draws the tile and its text at the given position in the grid
+func drawTile(dc *gg.Context, mu *sync.Mutex, t tile, position int, displayOptions DisplayOptions) {
+	x := (position % displayOptions.Columns) * displayOptions.ImageDimension
+	y := (position / displayOptions.Columns) * displayOptions.ImageDimension
+	if t.img != nil {
+		dc.DrawImage(t.img, x, y)
+	}
+
+	mu.Lock()
+	placeText(dc, t.element, displayOptions, float64(x), float64(y))
+	mu.Unlock()
+}
+
 func normaliseToSquare(img image.Image, size int) image.Image {
 	if img == nil {
 		return nil
@@ -0,0 +1,118 @@
+package collages
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidAggregation = errors.New("invalid aggregation")
+
+// Aggregation is how the top lists of several users are combined into one
+type Aggregation string
+
+const (
+	// rank by the total playcount across all users
+	AggregationSum Aggregation = "sum"
+	// rank by Borda count, where each user awards points by rank so every user
+	// has an equal say regardless of how much they listen
+	AggregationBorda Aggregation = "borda"
+)
+
+func GetAggregationFromStr(s string) (Aggregation, error) {
+	switch s {
+	case "sum":
+		return AggregationSum, nil
+	case "borda":
+		return AggregationBorda, nil
+	default:
+		return AggregationSum, ErrInvalidAggregation
+	}
+}
+
+// normaliseName lowercases the name and collapses whitespace so that the same
+// item can be matched across users
+func normaliseName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+func albumKey(album LastfmAlbum) string {
+	return normaliseName(album.Artist.ArtistName) + "\x00" + normaliseName(album.AlbumName)
+}
+
+func artistKey(artist LastfmArtist) string {
+	return normaliseName(artist.Name)
+}
+
+func trackKey(track LastfmTrack) string {
+	return normaliseName(track.Artist.Name) + "\x00" + normaliseName(track.Name)
+}
+
+type mergedItem[T any] struct {
+	item   T
+	plays  int
+	points int
+	order  int
+}
+
+// mergeLists combines several users' ranked lists into one, matching items by MBID
+// or by normalised name. The merged items have their playcounts summed and are
+// ordered according to the aggregation. Borda points are awarded against the
+// count of items requested, so a user with a shorter list doesn't award fewer.
+func mergeLists[T any](
+	lists [][]T,
+	count int,
+	keys func(T) (mbid string, name string),
+	playcount func(*T) *string,
+	aggregation Aggregation,
+) []T {
+	merged := []*mergedItem[T]{}
+	lookup := map[string]*mergedItem[T]{}
+
+	for _, list := range lists {
+		for rank, item := range list {
+			mbid, name := keys(item)
+			mbidKey, nameKey := "mbid:"+mbid, "name:"+name
+
+			var entry *mergedItem[T]
+			ok := false
+			if mbid != "" {
+				entry, ok = lookup[mbidKey]
+			}
+			if !ok {
+				entry, ok = lookup[nameKey]
+			}
+			if !ok {
+				entry = &mergedItem[T]{item: item, order: len(merged)}
+				merged = append(merged, entry)
+			}
+			if mbid != "" {
+				lookup[mbidKey] = entry
+			}
+			lookup[nameKey] = entry
+
+			entry.plays += parsePlaycount(*playcount(&item))
+			entry.points += max(count-rank, 0)
+		}
+	}
+
+	slices.SortStableFunc(merged, func(a, b *mergedItem[T]) int {
+		if aggregation == AggregationBorda {
+			return cmp.Or(
+				cmp.Compare(b.points, a.points),
+				cmp.Compare(b.plays, a.plays),
+				cmp.Compare(a.order, b.order),
+			)
+		}
+		return cmp.Or(cmp.Compare(b.plays, a.plays), cmp.Compare(a.order, b.order))
+	})
+
+	result := make([]T, len(merged))
+	for i, entry := range merged {
+		result[i] = entry.item
+		*playcount(&result[i]) = strconv.Itoa(entry.plays)
+	}
+	return result
+}
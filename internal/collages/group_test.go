@@ -0,0 +1,111 @@
+package collages
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeLists(t *testing.T) {
+	artist := func(name, mbid, playcount string) LastfmArtist {
+		return LastfmArtist{Name: name, Mbid: mbid, Playcount: playcount}
+	}
+
+	testCases := map[string]struct {
+		lists       [][]LastfmArtist
+		count       int
+		aggregation Aggregation
+		expected    []string
+		playcounts  []string
+	}{
+		"sum": {
+			lists: [][]LastfmArtist{
+				{artist("Slowdive", "", "10"), artist("Ride", "", "5")},
+				{artist("Ride", "", "8"), artist("Lush", "", "1")},
+			},
+			count:       2,
+			aggregation: AggregationSum,
+			expected:    []string{"Ride", "Slowdive", "Lush"},
+			playcounts:  []string{"13", "10", "1"},
+		},
+		"sum with unequal lists": {
+			lists: [][]LastfmArtist{
+				{artist("Slowdive", "", "3")},
+				{
+					artist("Ride", "", "100"),
+					artist("Lush", "", "50"),
+					artist("Chapterhouse", "", "20"),
+				},
+			},
+			count:       3,
+			aggregation: AggregationSum,
+			expected:    []string{"Ride", "Lush", "Chapterhouse", "Slowdive"},
+			playcounts:  []string{"100", "50", "20", "3"},
+		},
+		"borda with unequal lists": {
+			lists: [][]LastfmArtist{
+				{artist("Slowdive", "", "3")},
+				{
+					artist("Ride", "", "100"),
+					artist("Lush", "", "50"),
+					artist("Chapterhouse", "", "20"),
+				},
+			},
+			count:       3,
+			aggregation: AggregationBorda,
+			// each number one gets the same points, the tie goes to the playcount
+			expected:   []string{"Ride", "Slowdive", "Lush", "Chapterhouse"},
+			playcounts: []string{"100", "3", "50", "20"},
+		},
+		"borda across users": {
+			lists: [][]LastfmArtist{
+				{artist("Slowdive", "", "90"), artist("Ride", "", "80")},
+				{artist("Ride", "", "5"), artist("Lush", "", "4")},
+			},
+			count:       2,
+			aggregation: AggregationBorda,
+			expected:    []string{"Ride", "Slowdive", "Lush"},
+			playcounts:  []string{"85", "90", "4"},
+		},
+		"matched by mbid": {
+			lists: [][]LastfmArtist{
+				{artist("Sigur Rós", "f6f2326f", "10")},
+				{artist("Sigur Ros", "f6f2326f", "5")},
+			},
+			count:       1,
+			aggregation: AggregationSum,
+			expected:    []string{"Sigur Rós"},
+			playcounts:  []string{"15"},
+		},
+		"matched by normalised name": {
+			lists: [][]LastfmArtist{
+				{artist("My Bloody  Valentine", "", "10")},
+				{artist("my bloody valentine", "", "5")},
+			},
+			count:       1,
+			aggregation: AggregationSum,
+			expected:    []string{"My Bloody  Valentine"},
+			playcounts:  []string{"15"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			merged := mergeLists(tc.lists, tc.count, func(artist LastfmArtist) (string, string) {
+				return artist.Mbid, artistKey(artist)
+			}, func(artist *LastfmArtist) *string {
+				return &artist.Playcount
+			}, tc.aggregation)
+
+			names, playcounts := []string{}, []string{}
+			for _, artist := range merged {
+				names = append(names, artist.Name)
+				playcounts = append(playcounts, artist.Playcount)
+			}
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, names)
+			}
+			if !reflect.DeepEqual(playcounts, tc.playcounts) {
+				t.Errorf("expected playcounts %v, got %v", tc.playcounts, playcounts)
+			}
+		})
+	}
+}
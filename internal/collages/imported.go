@@ -0,0 +1,160 @@
+package collages
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/config"
+	"github.com/SongStitch/song-stitch/internal/scrobbles"
+)
+
+// GetElementsForImport builds the elements from uploaded scrobbles instead of the
+// user's Last.fm top lists, resolving the artwork through the usual providers
+func GetElementsForImport(
+	ctx context.Context,
+	history []scrobbles.Scrobble,
+	method lastfm.Method,
+	options ElementOptions,
+	jobChan chan<- CollageElement,
+) error {
+	start := time.Now()
+	config := config.GetConfig()
+	filter := newItemFilter(options.Filter)
+
+	var cacheCount int64
+	switch method {
+	case lastfm.MethodAlbum:
+		if options.Count > config.MaxImages.Albums {
+			return lastfm.ErrTooManyImages
+		}
+		albums := topAlbums(history)
+		if options.MergeEditions {
+			albums = mergeEditions(albums)
+		}
+		albums = filterItems(ctx, filter, albums, options.Count, describeAlbum)
+		albums = albums[:min(len(albums), options.Count)]
+		albums = fitItems(&options, albums, func(album LastfmAlbum) int {
+			return parsePlaycount(album.Playcount)
+		})
+		cacheCount = getAlbumElements(ctx, albums, options.ImageSize, jobChan)
+	case lastfm.MethodArtist:
+		if options.Count > config.MaxImages.Artists {
+			return lastfm.ErrTooManyImages
+		}
+		artists := topArtists(history)
+		artists = filterItems(ctx, filter, artists, options.Count, describeArtist)
+		artists = artists[:min(len(artists), options.Count)]
+		artists = fitItems(&options, artists, func(artist LastfmArtist) int {
+			return parsePlaycount(artist.Playcount)
+		})
+		cacheCount = getArtistElements(ctx, artists, options.ImageSize, jobChan)
+	case lastfm.MethodTrack:
+		if options.Count > config.MaxImages.Tracks {
+			return lastfm.ErrTooManyImages
+		}
+		tracks := topTracks(history)
+		tracks = filterItems(ctx, filter, tracks, options.Count, describeTrack)
+		tracks = tracks[:min(len(tracks), options.Count)]
+		tracks = fitItems(&options, tracks, func(track LastfmTrack) int {
+			return parsePlaycount(track.Playcount)
+		})
+		cacheCount = getTrackElements(ctx, tracks, options.ImageSize, jobChan)
+	default:
+		return lastfm.ErrInvalidMethod
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Int64("cacheCount", cacheCount).
+		Int("scrobbles", len(history)).
+		Int("totalCount", options.Count).
+		Dur("duration", time.Since(start)).
+		Str("method", string(method)).
+		Msg("Image URLs fetched")
+	return nil
+}
+
+type rankedItem[T any] struct {
+	item  T
+	plays int
+	order int
+}
+
+// rankScrobbles groups the scrobbles by key and returns an item for each group,
+// ordered by the number of scrobbles. Scrobbles with an empty key are skipped.
+func rankScrobbles[T any](
+	history []scrobbles.Scrobble,
+	key func(scrobbles.Scrobble) string,
+	item func(scrobbles.Scrobble) T,
+	playcount func(*T) *string,
+) []T {
+	ranked := []*rankedItem[T]{}
+	lookup := map[string]*rankedItem[T]{}
+	for _, s := range history {
+		k := key(s)
+		if k == "" {
+			continue
+		}
+		entry, ok := lookup[k]
+		if !ok {
+			entry = &rankedItem[T]{item: item(s), order: len(ranked)}
+			lookup[k] = entry
+			ranked = append(ranked, entry)
+		}
+		entry.plays++
+	}
+
+	slices.SortStableFunc(ranked, func(a, b *rankedItem[T]) int {
+		return cmp.Or(cmp.Compare(b.plays, a.plays), cmp.Compare(a.order, b.order))
+	})
+	result := make([]T, len(ranked))
+	for i, entry := range ranked {
+		result[i] = entry.item
+		*playcount(&result[i]) = strconv.Itoa(entry.plays)
+	}
+	return result
+}
+
+func topAlbums(history []scrobbles.Scrobble) []LastfmAlbum {
+	return rankScrobbles(history, func(s scrobbles.Scrobble) string {
+		if s.Album == "" {
+			return ""
+		}
+		return normaliseName(s.Artist) + "\x00" + normaliseName(s.Album)
+	}, func(s scrobbles.Scrobble) LastfmAlbum {
+		album := LastfmAlbum{AlbumName: s.Album, Mbid: s.AlbumMbid}
+		album.Artist.ArtistName = s.Artist
+		album.Artist.Mbid = s.ArtistMbid
+		return album
+	}, func(album *LastfmAlbum) *string {
+		return &album.Playcount
+	})
+}
+
+func topArtists(history []scrobbles.Scrobble) []LastfmArtist {
+	return rankScrobbles(history, func(s scrobbles.Scrobble) string {
+		return normaliseName(s.Artist)
+	}, func(s scrobbles.Scrobble) LastfmArtist {
+		return LastfmArtist{Name: s.Artist, Mbid: s.ArtistMbid}
+	}, func(artist *LastfmArtist) *string {
+		return &artist.Playcount
+	})
+}
+
+func topTracks(history []scrobbles.Scrobble) []LastfmTrack {
+	return rankScrobbles(history, func(s scrobbles.Scrobble) string {
+		return normaliseName(s.Artist) + "\x00" + normaliseName(s.Track)
+	}, func(s scrobbles.Scrobble) LastfmTrack {
+		track := LastfmTrack{Name: s.Track, Mbid: s.TrackMbid}
+		track.Artist.Name = s.Artist
+		track.Artist.Mbid = s.ArtistMbid
+		return track
+	}, func(track *LastfmTrack) *string {
+		return &track.Playcount
+	})
+}
@@ -0,0 +1,164 @@
+package collages
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+type LastfmLovedTrack struct {
+	Artist struct {
+		URL  string `json:"url"`
+		Name string `json:"name"`
+		Mbid string `json:"mbid"`
+	} `json:"artist"`
+	Mbid string `json:"mbid"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Date struct {
+		Uts  string `json:"uts"`
+		Text string `json:"#text"`
+	} `json:"date"`
+	Images []lastfm.LastfmImage `json:"image"`
+}
+
+type LastfmLovedTracks struct {
+	LovedTracks struct {
+		Attr   lastfm.LastfmUser  `json:"@attr"`
+		Tracks []LastfmLovedTrack `json:"track"`
+	} `json:"lovedtracks"`
+}
+
+// lovedDate formats the date the track was loved for its caption
+func (t LastfmLovedTrack) lovedDate() string {
+	uts, err := strconv.ParseInt(t.Date.Uts, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return "Loved " + time.Unix(uts, 0).UTC().Format("2 Jan 2006")
+}
+
+// GetElementsForLoved sends an element for each of the user's loved tracks, most
+// recently loved first
+func GetElementsForLoved(
+	ctx context.Context,
+	options ElementOptions,
+	displayOptions DisplayOptions,
+	jobChan chan<- CollageElement,
+) error {
+	config := config.GetConfig()
+	if options.Count > config.MaxImages.Tracks {
+		return lastfm.ErrTooManyImages
+	}
+	return getLovedTracks(ctx, options, jobChan)
+}
+
+func getLastfmLovedTracks(
+	ctx context.Context,
+	username string,
+	count int,
+	filter *itemFilter,
+) ([]LastfmLovedTrack, error) {
+	tracks := []LastfmLovedTrack{}
+	totalPages := 0
+
+	handler := func(data io.Reader) (int, int, error) {
+		var lastfmLovedTracks LastfmLovedTracks
+		err := json.NewDecoder(data).Decode(&lastfmLovedTracks)
+		if err != nil {
+			return 0, 0, err
+		}
+		page := lastfmLovedTracks.LovedTracks.Tracks
+		page = filterItems(ctx, filter, page, count-len(tracks), describeLovedTrack)
+		tracks = append(tracks, page...)
+		if totalPages == 0 {
+			total, err := strconv.Atoi(lastfmLovedTracks.LovedTracks.Attr.TotalPages)
+			if err != nil {
+				return 0, 0, err
+			}
+			totalPages = total
+		}
+		return filter.fetched(len(tracks), count), totalPages, nil
+	}
+	err := lastfm.GetLastFmResponseWithPageSize(
+		ctx,
+		lastfm.MethodLoved,
+		username,
+		"",
+		count,
+		filteredPageSize(count, filter),
+		handler,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tracks[:min(len(tracks), count)], nil
+}
+
+func getLovedTracks(
+	ctx context.Context,
+	options ElementOptions,
+	jobChan chan<- CollageElement,
+) error {
+	filter := newItemFilter(options.Filter)
+	tracks, err := getLastfmLovedTracks(ctx, options.Usernames[0], options.Count, filter)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	cacheCount := getLovedTrackElements(ctx, tracks, options.ImageSize, jobChan)
+	zerolog.Ctx(ctx).Info().
+		Int64("cacheCount", cacheCount).
+		Strs("usernames", options.Usernames).
+		Int("totalCount", options.Count).
+		Dur("duration", time.Since(start)).
+		Str("method", "loved").
+		Msg("Image URLs fetched")
+
+	return nil
+}
+
+// getLovedTrackElements resolves the artwork for each loved track through the same
+// lookups as top tracks, as Last.fm doesn't include album art with loved tracks
+func getLovedTrackElements(
+	ctx context.Context,
+	tracks []LastfmLovedTrack,
+	imageSize string,
+	jobChan chan<- CollageElement,
+) int64 {
+	var cacheCount int64
+	logger := zerolog.Ctx(ctx)
+
+	forEachItem(tracks, func(i int, lovedTrack LastfmLovedTrack) {
+		lastfmTrack := LastfmTrack{Mbid: lovedTrack.Mbid, Name: lovedTrack.Name}
+		lastfmTrack.Artist.Name = lovedTrack.Artist.Name
+		track := parseLastfmTrack(ctx, lastfmTrack, imageSize, &cacheCount)
+
+		img, ext, err := DownloadImageWithRetry(ctx, track.ImageUrl)
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("imageUrl", track.ImageUrl).
+				Msg("Error downloading image")
+		}
+		parameters := track.Parameters()
+		delete(parameters, "playcount")
+		parameters["date"] = lovedTrack.lovedDate()
+		jobChan <- CollageElement{
+			Index:      i,
+			Parameters: parameters,
+			ImageBytes: img,
+			ImageExt:   ext,
+		}
+	})
+	return atomic.LoadInt64(&cacheCount)
+}
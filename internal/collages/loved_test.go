@@ -0,0 +1,49 @@
+package collages
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLovedDate(t *testing.T) {
+	testCases := map[string]struct {
+		track    string
+		expected string
+	}{
+		"loved": {
+			track:    `{"name":"Alison","date":{"uts":"1700000000","#text":"14 Nov 2023, 22:13"}}`,
+			expected: "Loved 14 Nov 2023",
+		},
+		"formatted in UTC": {
+			track:    `{"name":"Alison","date":{"uts":"1704067199","#text":"31 Dec 2023, 23:59"}}`,
+			expected: "Loved 31 Dec 2023",
+		},
+		"single digit day": {
+			track:    `{"name":"Alison","date":{"uts":"1709251200"}}`,
+			expected: "Loved 1 Mar 2024",
+		},
+		"missing date": {
+			track:    `{"name":"Alison"}`,
+			expected: "",
+		},
+		"missing uts": {
+			track:    `{"name":"Alison","date":{"#text":"14 Nov 2023, 22:13"}}`,
+			expected: "",
+		},
+		"invalid uts": {
+			track:    `{"name":"Alison","date":{"uts":"yesterday"}}`,
+			expected: "",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var track LastfmLovedTrack
+			if err := json.Unmarshal([]byte(tc.track), &track); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if date := track.lovedDate(); date != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, date)
+			}
+		})
+	}
+}
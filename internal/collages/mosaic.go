@@ -0,0 +1,246 @@
+package collages
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/nfnt/resize"
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients"
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+var ErrNoAvatar = errors.New("user has no avatar")
+var ErrNoMosaicTiles = errors.New("no album covers available for the mosaic")
+
+// penalty added to the colour distance each time a cover has already been used,
+// so that the mosaic spreads across the available covers
+const mosaicRepeatPenalty = 12.0
+
+type MosaicOptions struct {
+	Username      string
+	Period        lastfm.Period
+	Count         int
+	Rows          int
+	Columns       int
+	TileDimension int
+	// percentage of the target cell colour blended over each tile
+	Blend     int
+	Width     uint
+	Height    uint
+	Grayscale bool
+	Webp      bool
+}
+
+type mosaicTile struct {
+	img    image.Image
+	colour color.RGBA
+}
+
+// GetAvatar downloads the user's Last.fm profile picture to use as a mosaic target
+func GetAvatar(ctx context.Context, username string) (image.Image, error) {
+	userInfo, err := lastfm.GetUserInfo(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	// images are listed from smallest to largest, prefer the largest
+	avatarUrl := ""
+	for _, img := range userInfo.Images {
+		if img.Link != "" {
+			avatarUrl = img.Link
+		}
+	}
+	if avatarUrl == "" {
+		return nil, ErrNoAvatar
+	}
+
+	data, ext, err := DownloadImageWithRetry(ctx, avatarUrl)
+	if err != nil {
+		return nil, err
+	}
+	return getImage(data, ext)
+}
+
+// CreateMosaic recreates the target image using the user's top album covers as tiles
+func CreateMosaic(
+	ctx context.Context,
+	target image.Image,
+	options MosaicOptions,
+) (image.Image, *bytes.Buffer, error) {
+	start := time.Now()
+	logger := zerolog.Ctx(ctx)
+
+	if options.Count > config.GetConfig().MaxImages.Albums {
+		return nil, nil, lastfm.ErrTooManyImages
+	}
+	// the tiles are shrunk to fit the canvas within the pixel budget before any
+	// are fetched, as they are fetched at the tile size
+	budget := DisplayOptions{
+		Rows:           options.Rows,
+		Columns:        options.Columns,
+		ImageDimension: options.TileDimension,
+		Width:          options.Width,
+		Height:         options.Height,
+	}
+	if err := FitPixelBudget(&budget); err != nil {
+		return nil, nil, err
+	}
+	options.TileDimension = budget.ImageDimension
+
+	tiles, err := getMosaicTiles(ctx, options, getAlbums)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tiles) == 0 {
+		return nil, nil, ErrNoMosaicTiles
+	}
+
+	// shrink the target so each pixel is the average colour of a mosaic cell
+	cells := resize.Resize(
+		uint(options.Columns), // #nosec G115
+		uint(options.Rows),    // #nosec G115
+		target,
+		resize.Bilinear,
+	)
+	cellBounds := cells.Bounds()
+
+	dc := gg.NewContext(
+		options.Columns*options.TileDimension,
+		options.Rows*options.TileDimension,
+	)
+	dc.SetRGB(0, 0, 0)
+	dc.Clear()
+
+	uses := make([]int, len(tiles))
+	assigned := make([]int, options.Rows*options.Columns)
+	for row := range options.Rows {
+		for column := range options.Columns {
+			r, g, b, _ := cells.At(cellBounds.Min.X+column, cellBounds.Min.Y+row).RGBA()
+			cellColour := color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 255}
+
+			// avoid placing the same cover next to itself where there is a choice
+			neighbours := []int{}
+			if column > 0 {
+				neighbours = append(neighbours, assigned[row*options.Columns+column-1])
+			}
+			if row > 0 {
+				neighbours = append(neighbours, assigned[(row-1)*options.Columns+column])
+			}
+
+			best := bestMosaicTile(tiles, uses, neighbours, cellColour)
+			uses[best]++
+			assigned[row*options.Columns+column] = best
+
+			x := column * options.TileDimension
+			y := row * options.TileDimension
+			dc.DrawImage(tiles[best].img, x, y)
+			if options.Blend > 0 {
+				dc.SetRGBA255(
+					int(cellColour.R),
+					int(cellColour.G),
+					int(cellColour.B),
+					options.Blend*255/100,
+				)
+				dc.DrawRectangle(
+					float64(x),
+					float64(y),
+					float64(options.TileDimension),
+					float64(options.TileDimension),
+				)
+				dc.Fill()
+			}
+		}
+	}
+
+	mosaic := dc.Image()
+	if options.Width > 0 || options.Height > 0 {
+		mosaic = resizeImage(ctx, mosaic, options.Width, options.Height)
+	}
+	if options.Grayscale {
+		mosaic = convertToGrayscale(mosaic)
+	}
+
+	buffer := new(bytes.Buffer)
+	if options.Webp && !options.Grayscale {
+		logger.Info().Msg("Converting to Webp image")
+		if err := webpEncode(buffer, mosaic, CompressionQuality); err != nil {
+			logger.Err(err).Msg("Unable to create Webp image")
+		}
+	}
+
+	logger.Info().
+		Dur("duration", time.Since(start)).
+		Int("rows", options.Rows).
+		Int("columns", options.Columns).
+		Int("tiles", len(tiles)).
+		Msg("Mosaic created")
+	return mosaic, buffer, nil
+}
+
+func bestMosaicTile(tiles []mosaicTile, uses []int, neighbours []int, target color.RGBA) int {
+	best := -1
+	bestCost := math.MaxFloat64
+	for i, t := range tiles {
+		cost := math.Sqrt(colourDistance(rgbToVector(t.colour), rgbToVector(target)))
+		cost += float64(uses[i]) * mosaicRepeatPenalty
+		for _, n := range neighbours {
+			if n == i && len(tiles) > len(neighbours) {
+				cost = math.MaxFloat64
+			}
+		}
+		if best == -1 || cost < bestCost {
+			best = i
+			bestCost = cost
+		}
+	}
+	return best
+}
+
+// getMosaicTiles fetches, decodes and measures the user's top album covers from
+// getElements
+func getMosaicTiles(
+	ctx context.Context,
+	options MosaicOptions,
+	getElements func(context.Context, ElementOptions, chan<- CollageElement) error,
+) ([]mosaicTile, error) {
+	logger := zerolog.Ctx(ctx)
+
+	jobChan := make(chan CollageElement, 100)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- getElements(ctx, ElementOptions{
+			Usernames: []string{options.Username},
+			Period:    options.Period,
+			Count:     options.Count,
+			ImageSize: clients.ImageSizeForDimension(options.TileDimension),
+		}, jobChan)
+		close(jobChan)
+	}()
+
+	tiles := []mosaicTile{}
+	for element := range jobChan {
+		img, err := getImage(element.ImageBytes, element.ImageExt)
+		if err != nil {
+			logger.Error().Err(err).Int("index", element.Index).Msg("failed parsing image")
+			continue
+		}
+		if img == nil {
+			continue
+		}
+		img = normaliseToSquare(img, options.TileDimension)
+		tiles = append(tiles, mosaicTile{img: img, colour: averageColour(img)})
+	}
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+	return tiles, nil
+}
@@ -0,0 +1,158 @@
+package collages
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+func TestBestMosaicTile(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	darkRed := color.RGBA{R: 200, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	tiles := []mosaicTile{{colour: red}, {colour: darkRed}, {colour: blue}}
+
+	testCases := map[string]struct {
+		tiles      []mosaicTile
+		uses       []int
+		neighbours []int
+		target     color.RGBA
+		expected   int
+	}{
+		"closest colour": {
+			tiles:    tiles,
+			uses:     []int{0, 0, 0},
+			target:   color.RGBA{R: 250, A: 255},
+			expected: 0,
+		},
+		"repeats are penalised": {
+			tiles:    tiles,
+			uses:     []int{5, 0, 0},
+			target:   color.RGBA{R: 250, A: 255},
+			expected: 1,
+		},
+		"neighbours are avoided": {
+			tiles:      tiles,
+			uses:       []int{0, 0, 0},
+			neighbours: []int{0, 1},
+			target:     red,
+			expected:   2,
+		},
+		"neighbours are used without a choice": {
+			tiles:      tiles[:2],
+			uses:       []int{0, 0},
+			neighbours: []int{0, 1},
+			target:     red,
+			expected:   0,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			best := bestMosaicTile(tc.tiles, tc.uses, tc.neighbours, tc.target)
+			if best != tc.expected {
+				t.Errorf("expected tile %d, got %d", tc.expected, best)
+			}
+		})
+	}
+}
+
+// solidElement returns an element with a jpeg of a single colour
+func solidElement(t *testing.T, index int, fill color.RGBA) CollageElement {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := range 32 {
+		for x := range 32 {
+			img.SetRGBA(x, y, fill)
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("unable to encode jpeg: %v", err)
+	}
+	return CollageElement{Index: index, ImageBytes: io.NopCloser(buf), ImageExt: ".jpg"}
+}
+
+func TestGetMosaicTiles(t *testing.T) {
+	options := MosaicOptions{Username: "user", Count: 4, TileDimension: 16}
+	grey := color.RGBA{R: 128, G: 128, B: 128, A: 255}
+
+	getElements := func(
+		ctx context.Context,
+		elementOptions ElementOptions,
+		jobChan chan<- CollageElement,
+	) error {
+		if elementOptions.Count != options.Count || elementOptions.ImageSize != "small" {
+			t.Errorf("unexpected element options %+v", elementOptions)
+		}
+		jobChan <- solidElement(t, 0, grey)
+		// an element without artwork and one that can't be decoded are skipped
+		jobChan <- CollageElement{Index: 1}
+		jobChan <- CollageElement{
+			Index:      2,
+			ImageBytes: io.NopCloser(bytes.NewReader([]byte("not an image"))),
+			ImageExt:   ".jpg",
+		}
+		jobChan <- solidElement(t, 3, grey)
+		return nil
+	}
+
+	tiles, err := getMosaicTiles(context.Background(), options, getElements)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tiles) != 2 {
+		t.Fatalf("expected 2 tiles, got %d", len(tiles))
+	}
+	for _, tile := range tiles {
+		if tile.img.Bounds() != image.Rect(0, 0, 16, 16) {
+			t.Errorf("expected a 16px square tile, got %v", tile.img.Bounds())
+		}
+		if absInt(int(tile.colour.R)-int(grey.R)) > 2 {
+			t.Errorf("expected the tile colour to be %v, got %v", grey, tile.colour)
+		}
+	}
+
+	errFetch := errors.New("fetch failed")
+	_, err = getMosaicTiles(
+		context.Background(),
+		options,
+		func(context.Context, ElementOptions, chan<- CollageElement) error {
+			return errFetch
+		},
+	)
+	if err != errFetch {
+		t.Errorf("expected %v, got %v", errFetch, err)
+	}
+}
+
+func TestCreateMosaicPixelBudget(t *testing.T) {
+	initRenderConfig(t, 1_000_000, 0)
+	target := image.NewRGBA(image.Rect(0, 0, 10, 10))
+
+	testCases := map[string]MosaicOptions{
+		"tiles too small": {Username: "user", Count: 10, Rows: 40, Columns: 40, TileDimension: 64},
+		"output too large": {
+			Username:      "user",
+			Count:         10,
+			Rows:          4,
+			Columns:       4,
+			TileDimension: 64,
+			Width:         2000,
+			Height:        1000,
+		},
+	}
+	for name, options := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// the budget is checked before any covers are fetched
+			_, _, err := CreateMosaic(context.Background(), target, options)
+			if !errors.Is(err, ErrCollageTooLarge) {
+				t.Errorf("expected ErrCollageTooLarge, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package collages
+
+import (
+	"sync"
+
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+type indexedItem[T any] struct {
+	index int
+	item  T
+}
+
+// itemPool resolves items on a bounded pool of workers as they are added, so a
+// large collage doesn't start hundreds of artwork lookups at once. Items are
+// started in the order they are added, so the highest ranked are resolved first,
+// and up to size items are queued without blocking the caller adding them.
+type itemPool[T any] struct {
+	items chan indexedItem[T]
+	wg    sync.WaitGroup
+	added int
+}
+
+func newItemPool[T any](size int, resolve func(i int, item T)) *itemPool[T] {
+	workers := 1
+	if cfg := config.GetConfig(); cfg != nil {
+		workers = max(cfg.Concurrency.Workers, 1)
+	}
+	workers = min(workers, max(size, 1))
+	p := &itemPool[T]{items: make(chan indexedItem[T], size)}
+	for range workers {
+		p.wg.Go(func() {
+			for item := range p.items {
+				resolve(item.index, item.item)
+			}
+		})
+	}
+	return p
+}
+
+// add queues the items, indexed after the items already added
+func (p *itemPool[T]) add(items []T) {
+	for _, item := range items {
+		p.items <- indexedItem[T]{index: p.added, item: item}
+		p.added++
+	}
+}
+
+// wait waits for every added item to be resolved, no more items can be added
+func (p *itemPool[T]) wait() {
+	close(p.items)
+	p.wg.Wait()
+}
+
+// forEachItem calls resolve for each item on a bounded pool of workers
+func forEachItem[T any](items []T, resolve func(i int, item T)) {
+	if len(items) == 0 {
+		return
+	}
+	pool := newItemPool(len(items), resolve)
+	pool.add(items)
+	pool.wait()
+}
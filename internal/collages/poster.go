@@ -0,0 +1,378 @@
+package collages
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients"
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+)
+
+const (
+	posterWidth  = 1080
+	posterHeight = 1920
+	// number of items shown in each section of the poster
+	posterItems = 5
+	// number of top tracks used to estimate listening time
+	posterDurationSampleSize = 1000
+	// assumed track length when Last.fm does not know the duration
+	defaultTrackSeconds = 210
+
+	posterMargin        = 60
+	posterSectionTop    = 430
+	posterSectionHeight = 480
+	posterRowHeight     = 84
+	posterThumbnail     = 72
+)
+
+type PosterOptions struct {
+	Username string
+	Period   lastfm.Period
+	Webp     bool
+}
+
+type posterRow struct {
+	img      image.Image
+	title    string
+	subtitle string
+}
+
+type posterData struct {
+	userInfo  lastfm.UserInfo
+	scrobbles int
+	minutes   int
+	artists   []posterRow
+	albums    []posterRow
+	tracks    []posterRow
+}
+
+// CreatePoster renders a story sized "year in review" style poster of the user's
+// top artists, albums and tracks along with their listening totals
+func CreatePoster(
+	ctx context.Context,
+	options PosterOptions,
+) (image.Image, *bytes.Buffer, error) {
+	start := time.Now()
+	logger := zerolog.Ctx(ctx)
+
+	data, err := getPosterData(ctx, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dc := gg.NewContext(posterWidth, posterHeight)
+	gradient := gg.NewLinearGradient(0, 0, 0, posterHeight)
+	gradient.AddColorStop(0, rgb(0x1b, 0x1b, 0x2f))
+	gradient.AddColorStop(1, rgb(0x3a, 0x0a, 0x12))
+	dc.SetFillStyle(gradient)
+	dc.DrawRectangle(0, 0, posterWidth, posterHeight)
+	dc.Fill()
+
+	name := data.userInfo.Name
+	if name == "" {
+		name = options.Username
+	}
+	dc.SetRGB(1, 1, 1)
+	if err := dc.LoadFontFace(fontFileBold, 64); err != nil {
+		return nil, nil, err
+	}
+	name = truncateToWidth(dc, name, posterWidth-2*posterMargin)
+	dc.DrawStringAnchored(name, posterMargin, 130, 0, 0)
+	if err := dc.LoadFontFace(fontFileRegular, 36); err != nil {
+		return nil, nil, err
+	}
+	dc.SetRGBA(1, 1, 1, 0.7)
+	dc.DrawStringAnchored(periodTitle(options.Period), posterMargin, 190, 0, 0)
+
+	boxWidth := float64(posterWidth-3*posterMargin) / 2
+	stats := []struct {
+		label string
+		value int
+	}{
+		{"Scrobbles", data.scrobbles},
+		{"Minutes listened", data.minutes},
+	}
+	for i, stat := range stats {
+		x := posterMargin + float64(i)*(boxWidth+posterMargin)
+		dc.SetRGBA(1, 1, 1, 0.08)
+		dc.DrawRoundedRectangle(x, 230, boxWidth, 150, 20)
+		dc.Fill()
+		dc.SetRGB(1, 1, 1)
+		if err := dc.LoadFontFace(fontFileBold, 56); err != nil {
+			return nil, nil, err
+		}
+		dc.DrawStringAnchored(formatNumber(stat.value), x+30, 310, 0, 0)
+		if err := dc.LoadFontFace(fontFileRegular, 26); err != nil {
+			return nil, nil, err
+		}
+		dc.SetRGBA(1, 1, 1, 0.7)
+		dc.DrawStringAnchored(stat.label, x+30, 355, 0, 0)
+	}
+
+	sections := []struct {
+		title string
+		rows  []posterRow
+	}{
+		{"Top Artists", data.artists},
+		{"Top Albums", data.albums},
+		{"Top Tracks", data.tracks},
+	}
+	for i, section := range sections {
+		top := posterSectionTop + i*posterSectionHeight
+		if err := drawPosterSection(dc, section.title, section.rows, top); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := dc.LoadFontFace(fontFileRegular, 22); err != nil {
+		return nil, nil, err
+	}
+	dc.SetRGBA(1, 1, 1, 0.5)
+	dc.DrawStringAnchored("songstitch.art", posterWidth/2, posterHeight-25, 0.5, 0)
+
+	poster := dc.Image()
+	buffer := new(bytes.Buffer)
+	if options.Webp {
+		logger.Info().Msg("Converting to Webp image")
+		if err := webpEncode(buffer, poster, CompressionQuality); err != nil {
+			logger.Err(err).Msg("Unable to create Webp image")
+		}
+	}
+
+	logger.Info().
+		Dur("duration", time.Since(start)).
+		Str("username", options.Username).
+		Msg("Poster created")
+	return poster, buffer, nil
+}
+
+func drawPosterSection(dc *gg.Context, title string, rows []posterRow, top int) error {
+	dc.SetRGB(1, 1, 1)
+	if err := dc.LoadFontFace(fontFileBold, 40); err != nil {
+		return err
+	}
+	dc.DrawStringAnchored(title, posterMargin, float64(top+45), 0, 0)
+
+	textX := float64(posterMargin + posterThumbnail + 70)
+	textWidth := float64(posterWidth-posterMargin) - textX
+	for i, row := range rows {
+		y := top + 70 + i*posterRowHeight
+
+		if err := dc.LoadFontFace(fontFileBold, 30); err != nil {
+			return err
+		}
+		dc.SetRGBA(1, 1, 1, 0.6)
+		rankY := float64(y + posterThumbnail/2)
+		dc.DrawStringAnchored(strconv.Itoa(i+1), posterMargin, rankY, 0, 0.35)
+
+		thumbX := posterMargin + 40
+		if row.img != nil {
+			dc.DrawImage(row.img, thumbX, y)
+		} else {
+			dc.SetRGBA(1, 1, 1, 0.1)
+			dc.DrawRectangle(float64(thumbX), float64(y), posterThumbnail, posterThumbnail)
+			dc.Fill()
+		}
+
+		dc.SetRGB(1, 1, 1)
+		title := truncateToWidth(dc, row.title, textWidth)
+		dc.DrawStringAnchored(title, textX, float64(y+32), 0, 0)
+		if err := dc.LoadFontFace(fontFileRegular, 24); err != nil {
+			return err
+		}
+		dc.SetRGBA(1, 1, 1, 0.7)
+		subtitle := truncateToWidth(dc, row.subtitle, textWidth)
+		dc.DrawStringAnchored(subtitle, textX, float64(y+64), 0, 0)
+	}
+	return nil
+}
+
+func getPosterData(ctx context.Context, options PosterOptions) (posterData, error) {
+	data := posterData{}
+	var tracks []LastfmTrack
+	var albums []LastfmAlbum
+	var artists []LastfmArtist
+	errs := make([]error, 5)
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		data.userInfo, errs[0] = lastfm.GetUserInfo(ctx, options.Username)
+	})
+	wg.Go(func() {
+		from := options.Period.Start(time.Now())
+		data.scrobbles, errs[1] = lastfm.GetScrobbleCount(ctx, options.Username, from)
+	})
+	wg.Go(func() {
+		tracks, errs[2] = getLastfmTracks(
+			ctx,
+			listeningSource(SourceLastfm),
+			options.Username,
+			options.Period,
+			posterDurationSampleSize,
+			nil,
+		)
+	})
+	wg.Go(func() {
+		albums, errs[3] = getLastfmAlbums(
+			ctx,
+			listeningSource(SourceLastfm),
+			options.Username,
+			options.Period,
+			posterItems,
+			nil,
+			false,
+		)
+	})
+	wg.Go(func() {
+		artists, errs[4] = getLastfmArtists(
+			ctx,
+			listeningSource(SourceLastfm),
+			options.Username,
+			options.Period,
+			posterItems,
+			nil,
+		)
+	})
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return data, err
+		}
+	}
+
+	data.minutes = estimateListeningMinutes(tracks, data.scrobbles)
+
+	tracks = tracks[:min(len(tracks), posterItems)]
+	albums = albums[:min(len(albums), posterItems)]
+	artists = artists[:min(len(artists), posterItems)]
+
+	// the artwork is fetched at the closest Last.fm size above the thumbnail size
+	imageSize := clients.ImageSizeForDimension(posterThumbnail)
+	wg.Go(func() {
+		data.artists = getPosterRows(ctx, len(artists), func(jobChan chan<- CollageElement) {
+			getArtistElements(ctx, artists, imageSize, jobChan)
+		})
+	})
+	wg.Go(func() {
+		data.albums = getPosterRows(ctx, len(albums), func(jobChan chan<- CollageElement) {
+			getAlbumElements(ctx, albums, imageSize, jobChan)
+		})
+	})
+	wg.Go(func() {
+		data.tracks = getPosterRows(ctx, len(tracks), func(jobChan chan<- CollageElement) {
+			getTrackElements(ctx, tracks, imageSize, jobChan)
+		})
+	})
+	wg.Wait()
+
+	return data, nil
+}
+
+// getPosterRows collects the elements produced by getElements into rows in rank order
+func getPosterRows(
+	ctx context.Context,
+	count int,
+	getElements func(jobChan chan<- CollageElement),
+) []posterRow {
+	tiles := collectTiles(ctx, count, posterThumbnail, getElements)
+
+	rows := make([]posterRow, 0, len(tiles))
+	for _, t := range tiles {
+		parameters := t.element.Parameters
+		row := posterRow{img: t.img}
+		plays := parameters["playcount"] + " plays"
+		if track, ok := parameters["track"]; ok {
+			row.title = track
+			row.subtitle = parameters["artist"] + " · " + plays
+		} else if album, ok := parameters["album"]; ok {
+			row.title = album
+			row.subtitle = parameters["artist"] + " · " + plays
+		} else {
+			row.title = parameters["artist"]
+			row.subtitle = plays
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// estimateListeningMinutes estimates the total listening time from the durations
+// of the top tracks, scaled up to account for scrobbles outside of the sample
+func estimateListeningMinutes(tracks []LastfmTrack, scrobbles int) int {
+	knownSeconds, knownPlays, samplePlays := 0, 0, 0
+	for _, track := range tracks {
+		plays, err := strconv.Atoi(track.Playcount)
+		if err != nil {
+			continue
+		}
+		samplePlays += plays
+		if seconds, err := strconv.Atoi(track.Duration); err == nil && seconds > 0 {
+			knownSeconds += seconds * plays
+			knownPlays += plays
+		}
+	}
+
+	averageSeconds := float64(defaultTrackSeconds)
+	if knownPlays > 0 {
+		averageSeconds = float64(knownSeconds) / float64(knownPlays)
+	}
+	plays := max(scrobbles, samplePlays)
+	return int(averageSeconds * float64(plays) / 60)
+}
+
+func periodTitle(period lastfm.Period) string {
+	switch period {
+	case lastfm.PeriodSevenDays:
+		return "Your week in music"
+	case lastfm.PeriodOneMonth:
+		return "Your month in music"
+	case lastfm.PeriodThreeMonths:
+		return "Your last 3 months in music"
+	case lastfm.PeriodSixMonths:
+		return "Your last 6 months in music"
+	case lastfm.PeriodTwelveMonths:
+		return "Your year in music"
+	default:
+		return "Your music of all time"
+	}
+}
+
+func rgb(r, g, b uint8) color.Color {
+	return color.RGBA{R: r, G: g, B: b, A: 255}
+}
+
+// truncateToWidth shortens the text with an ellipsis so it fits within the width
+func truncateToWidth(dc *gg.Context, text string, width float64) string {
+	if w, _ := dc.MeasureString(text); w <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		truncated := string(runes) + "…"
+		if w, _ := dc.MeasureString(truncated); w <= width {
+			return truncated
+		}
+	}
+	return ""
+}
+
+// formatNumber formats the number with thousands separators
+func formatNumber(n int) string {
+	s := strconv.Itoa(n)
+	if n < 0 {
+		return "-" + formatNumber(-n)
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,102 @@
+package collages
+
+import (
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func TestEstimateListeningMinutes(t *testing.T) {
+	track := func(playcount, duration string) LastfmTrack {
+		return LastfmTrack{Playcount: playcount, Duration: duration}
+	}
+
+	testCases := map[string]struct {
+		tracks    []LastfmTrack
+		scrobbles int
+		expected  int
+	}{
+		"no tracks": {
+			scrobbles: 10,
+			expected:  35,
+		},
+		"known durations": {
+			tracks:    []LastfmTrack{track("2", "120"), track("1", "300")},
+			scrobbles: 3,
+			expected:  9,
+		},
+		"averaged over every scrobble": {
+			tracks:    []LastfmTrack{track("2", "120"), track("1", "300")},
+			scrobbles: 30,
+			expected:  90,
+		},
+		"unknown durations ignored": {
+			tracks:    []LastfmTrack{track("4", "180"), track("6", "0"), track("5", "")},
+			scrobbles: 0,
+			expected:  45,
+		},
+		"no known durations": {
+			tracks:    []LastfmTrack{track("4", "0")},
+			scrobbles: 2,
+			expected:  14,
+		},
+		"invalid playcount skipped": {
+			tracks:    []LastfmTrack{track("many", "600"), track("2", "60")},
+			scrobbles: 0,
+			expected:  2,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			minutes := estimateListeningMinutes(tc.tracks, tc.scrobbles)
+			if minutes != tc.expected {
+				t.Errorf("expected %d minutes, got %d", tc.expected, minutes)
+			}
+		})
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	testCases := map[int]string{
+		0:          "0",
+		7:          "7",
+		999:        "999",
+		1000:       "1,000",
+		12345:      "12,345",
+		123456:     "123,456",
+		1234567:    "1,234,567",
+		-1234:      "-1,234",
+		-999:       "-999",
+		1000000000: "1,000,000,000",
+	}
+	for n, expected := range testCases {
+		if result := formatNumber(n); result != expected {
+			t.Errorf("%d: expected %q, got %q", n, expected, result)
+		}
+	}
+}
+
+func TestTruncateToWidth(t *testing.T) {
+	// the default face is 7 pixels wide for every character
+	dc := gg.NewContext(100, 100)
+
+	testCases := map[string]struct {
+		text     string
+		width    float64
+		expected string
+	}{
+		"fits":              {text: "Loveless", width: 56, expected: "Loveless"},
+		"truncated":         {text: "Loveless", width: 40, expected: "Love…"},
+		"multibyte":         {text: "Rósa Rós", width: 28, expected: "Rós…"},
+		"only the ellipsis": {text: "Loveless", width: 7, expected: "…"},
+		"too narrow":        {text: "Loveless", width: 6, expected: ""},
+		"empty":             {text: "", width: 10, expected: ""},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if result := truncateToWidth(dc, tc.text, tc.width); result != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, result)
+			}
+		})
+	}
+}
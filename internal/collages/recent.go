@@ -0,0 +1,233 @@
+package collages
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+type LastfmRecentTrack struct {
+	Artist struct {
+		Name string `json:"#text"`
+		Mbid string `json:"mbid"`
+	} `json:"artist"`
+	Album struct {
+		Name string `json:"#text"`
+		Mbid string `json:"mbid"`
+	} `json:"album"`
+	Mbid string `json:"mbid"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Attr struct {
+		NowPlaying string `json:"nowplaying"`
+	} `json:"@attr"`
+	Date struct {
+		Uts  string `json:"uts"`
+		Text string `json:"#text"`
+	} `json:"date"`
+	Images []lastfm.LastfmImage `json:"image"`
+}
+
+type LastfmRecentTracks struct {
+	RecentTracks struct {
+		Attr   lastfm.LastfmUser   `json:"@attr"`
+		Tracks []LastfmRecentTrack `json:"track"`
+	} `json:"recenttracks"`
+}
+
+func (t LastfmRecentTrack) nowPlaying() bool {
+	return t.Attr.NowPlaying == "true"
+}
+
+// sameAlbum reports whether both scrobbles are from the same known album
+func (t LastfmRecentTrack) sameAlbum(other LastfmRecentTrack) bool {
+	if t.Album.Name == "" || other.Album.Name == "" {
+		return false
+	}
+	return normaliseName(t.Artist.Name) == normaliseName(other.Artist.Name) &&
+		normaliseName(t.Album.Name) == normaliseName(other.Album.Name)
+}
+
+// GetElementsForRecent sends an element for each of the user's most recent
+// scrobbles, newest first
+func GetElementsForRecent(
+	ctx context.Context,
+	options ElementOptions,
+	displayOptions DisplayOptions,
+	jobChan chan<- CollageElement,
+) error {
+	config := config.GetConfig()
+	if options.Count > config.MaxImages.Tracks {
+		return lastfm.ErrTooManyImages
+	}
+	return getRecentTracks(ctx, options, jobChan)
+}
+
+// a deduped fetch stops once it has scanned this many scrobbles, so a long run of
+// scrobbles from one album doesn't walk the user's whole history
+const maxDedupeScan = 5000
+
+// getLastfmRecentTracks fetches the user's latest scrobbles. When dedupe is set,
+// consecutive scrobbles from the same album are collapsed into the first of them,
+// fetching full pages as most scrobbles may be dropped.
+func getLastfmRecentTracks(
+	ctx context.Context,
+	username string,
+	count int,
+	dedupe bool,
+	filter *itemFilter,
+) ([]LastfmRecentTrack, error) {
+	tracks := []LastfmRecentTrack{}
+	totalPages := 0
+	scanned := 0
+
+	handler := func(data io.Reader) (int, int, error) {
+		var lastfmRecentTracks LastfmRecentTracks
+		err := json.NewDecoder(data).Decode(&lastfmRecentTracks)
+		if err != nil {
+			return 0, 0, err
+		}
+		page := lastfmRecentTracks.RecentTracks.Tracks
+		scanned += len(page)
+		page = filterItems(ctx, filter, page, count-len(tracks), describeRecentTrack)
+		tracks = appendRecentTracks(tracks, page, dedupe)
+		if totalPages == 0 {
+			total, err := strconv.Atoi(lastfmRecentTracks.RecentTracks.Attr.TotalPages)
+			if err != nil {
+				return 0, 0, err
+			}
+			totalPages = total
+		}
+		if dedupe && scanned >= maxDedupeScan {
+			return max(len(tracks), count), totalPages, nil
+		}
+		return filter.fetched(len(tracks), count), totalPages, nil
+	}
+	pageSize := filteredPageSize(count, filter)
+	if dedupe {
+		pageSize = lastfm.MaxPageSize
+	}
+	err := lastfm.GetLastFmResponseWithPageSize(
+		ctx,
+		lastfm.MethodRecent,
+		username,
+		"",
+		count,
+		pageSize,
+		handler,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tracks[:min(len(tracks), count)], nil
+}
+
+// appendRecentTracks appends a page of scrobbles to the tracks, dropping the
+// track being played if it isn't first and, when dedupe is set, scrobbles from
+// the same album as the one before them
+func appendRecentTracks(
+	tracks []LastfmRecentTrack,
+	page []LastfmRecentTrack,
+	dedupe bool,
+) []LastfmRecentTrack {
+	for _, track := range page {
+		// the track being played is repeated at the top of every page
+		if track.nowPlaying() && len(tracks) > 0 {
+			continue
+		}
+		if dedupe && len(tracks) > 0 && tracks[len(tracks)-1].sameAlbum(track) {
+			continue
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+func getRecentTracks(
+	ctx context.Context,
+	options ElementOptions,
+	jobChan chan<- CollageElement,
+) error {
+	tracks, err := getLastfmRecentTracks(
+		ctx,
+		options.Usernames[0],
+		options.Count,
+		options.Dedupe,
+		newItemFilter(options.Filter),
+	)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	cacheCount := getRecentTrackElements(ctx, tracks, options.ImageSize, jobChan)
+	zerolog.Ctx(ctx).Info().
+		Int64("cacheCount", cacheCount).
+		Strs("usernames", options.Usernames).
+		Int("totalCount", options.Count).
+		Bool("dedupe", options.Dedupe).
+		Dur("duration", time.Since(start)).
+		Str("method", "recent").
+		Msg("Image URLs fetched")
+
+	return nil
+}
+
+// getRecentTrackElements sends an element for each scrobble, using the album art
+// Last.fm includes with the scrobble and otherwise looking the track up
+func getRecentTrackElements(
+	ctx context.Context,
+	tracks []LastfmRecentTrack,
+	imageSize string,
+	jobChan chan<- CollageElement,
+) int64 {
+	var cacheCount int64
+	logger := zerolog.Ctx(ctx)
+
+	forEachItem(tracks, func(i int, recentTrack LastfmRecentTrack) {
+		track := Track{
+			Name:      recentTrack.Name,
+			Artist:    recentTrack.Artist.Name,
+			Album:     recentTrack.Album.Name,
+			Mbid:      recentTrack.Mbid,
+			ImageSize: imageSize,
+		}
+		track.ImageUrl = lastfm.ImageForSize(recentTrack.Images, imageSize)
+		if track.ImageUrl == "" {
+			lastfmTrack := LastfmTrack{Mbid: recentTrack.Mbid, Name: recentTrack.Name}
+			lastfmTrack.Artist.Name = recentTrack.Artist.Name
+			track = parseLastfmTrack(ctx, lastfmTrack, imageSize, &cacheCount)
+			if recentTrack.Album.Name != "" {
+				track.Album = recentTrack.Album.Name
+			}
+		}
+
+		img, ext, err := DownloadImageWithRetry(ctx, track.ImageUrl)
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("imageUrl", track.ImageUrl).
+				Msg("Error downloading image")
+		}
+		parameters := track.Parameters()
+		delete(parameters, "playcount")
+		if recentTrack.nowPlaying() {
+			parameters["nowplaying"] = "true"
+		}
+		jobChan <- CollageElement{
+			Index:      i,
+			Parameters: parameters,
+			ImageBytes: img,
+			ImageExt:   ext,
+		}
+	})
+	return atomic.LoadInt64(&cacheCount)
+}
@@ -0,0 +1,145 @@
+package collages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+func TestAppendRecentTracks(t *testing.T) {
+	scrobble := func(name, artist, album string) LastfmRecentTrack {
+		track := LastfmRecentTrack{Name: name}
+		track.Artist.Name = artist
+		track.Album.Name = album
+		return track
+	}
+	playing := func(track LastfmRecentTrack) LastfmRecentTrack {
+		track.Attr.NowPlaying = "true"
+		return track
+	}
+	alison := scrobble("Alison", "Slowdive", "Souvlaki")
+	machineGun := scrobble("Machine Gun", "Slowdive", "Souvlaki")
+	vapour := scrobble("Vapour", "Ride", "Nowhere")
+	single := scrobble("Leave Them All Behind", "Ride", "")
+	otherSingle := scrobble("Twisterella", "Ride", "")
+
+	testCases := map[string]struct {
+		pages    [][]LastfmRecentTrack
+		dedupe   bool
+		expected []string
+	}{
+		"kept without dedupe": {
+			pages:    [][]LastfmRecentTrack{{alison, machineGun, vapour}},
+			expected: []string{"Alison", "Machine Gun", "Vapour"},
+		},
+		"consecutive duplicates": {
+			pages:    [][]LastfmRecentTrack{{alison, machineGun, vapour}},
+			dedupe:   true,
+			expected: []string{"Alison", "Vapour"},
+		},
+		"album name differs in case": {
+			pages: [][]LastfmRecentTrack{
+				{alison, scrobble("Dagger", "slowdive", "SOUVLAKI")},
+			},
+			dedupe:   true,
+			expected: []string{"Alison"},
+		},
+		"non-consecutive duplicates": {
+			pages:    [][]LastfmRecentTrack{{alison, vapour, machineGun}},
+			dedupe:   true,
+			expected: []string{"Alison", "Vapour", "Machine Gun"},
+		},
+		"duplicates across pages": {
+			pages:    [][]LastfmRecentTrack{{vapour, alison}, {machineGun}},
+			dedupe:   true,
+			expected: []string{"Vapour", "Alison"},
+		},
+		"unknown albums kept": {
+			pages:    [][]LastfmRecentTrack{{single, otherSingle}},
+			dedupe:   true,
+			expected: []string{"Leave Them All Behind", "Twisterella"},
+		},
+		"now playing first": {
+			pages:    [][]LastfmRecentTrack{{playing(vapour), alison, machineGun}},
+			expected: []string{"Vapour", "Alison", "Machine Gun"},
+		},
+		"now playing repeated on the next page": {
+			pages: [][]LastfmRecentTrack{
+				{playing(vapour), alison},
+				{playing(vapour), machineGun},
+			},
+			expected: []string{"Vapour", "Alison", "Machine Gun"},
+		},
+		"now playing deduped with the scrobble after it": {
+			pages:    [][]LastfmRecentTrack{{playing(alison), machineGun, vapour}},
+			dedupe:   true,
+			expected: []string{"Alison", "Vapour"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var tracks []LastfmRecentTrack
+			for _, page := range tc.pages {
+				tracks = appendRecentTracks(tracks, page, tc.dedupe)
+			}
+			names := []string{}
+			for _, track := range tracks {
+				names = append(names, track.Name)
+			}
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, names)
+			}
+		})
+	}
+
+	tracks := appendRecentTracks(nil, []LastfmRecentTrack{playing(vapour)}, false)
+	if !tracks[0].nowPlaying() {
+		t.Error("expected the first track to still be playing")
+	}
+}
+
+func TestGetLastfmRecentTracksDedupeLimit(t *testing.T) {
+	// every scrobble in the user's history is from the same album
+	var pages atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages.Add(1)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit != 500 {
+			t.Errorf("expected full pages, got a limit of %d", limit)
+		}
+		var response LastfmRecentTracks
+		response.RecentTracks.Attr.TotalPages = "1000"
+		for i := range limit {
+			track := LastfmRecentTrack{Name: "Track " + strconv.Itoa(i)}
+			track.Artist.Name = "Slowdive"
+			track.Album.Name = "Souvlaki"
+			response.RecentTracks.Tracks = append(response.RecentTracks.Tracks, track)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("LASTFM_ENDPOINT", server.URL)
+	t.Setenv("LASTFM_API_KEY", "key")
+	t.Setenv("FANART_API_KEY", "key")
+	if err := config.Init(); err != nil {
+		t.Fatalf("unable to init config: %v", err)
+	}
+
+	tracks, err := getLastfmRecentTracks(context.Background(), "user", 2, true, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tracks) != 1 {
+		t.Errorf("expected the run collapsed into 1 track, got %d", len(tracks))
+	}
+	if n := pages.Load(); n != maxDedupeScan/500 {
+		t.Errorf("expected %d pages, got %d", maxDedupeScan/500, n)
+	}
+}
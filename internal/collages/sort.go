@@ -0,0 +1,160 @@
+package collages
+
+import (
+	"cmp"
+	"errors"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidSortOrder = errors.New("invalid sort order")
+
+type SortOrder string
+
+const (
+	SortRank         SortOrder = "rank"
+	SortColour       SortOrder = "colour"
+	SortHue          SortOrder = "hue"
+	SortBrightness   SortOrder = "brightness"
+	SortPlaycount    SortOrder = "playcount"
+	SortAlphabetical SortOrder = "alphabetical"
+	SortRandom       SortOrder = "random"
+)
+
+func GetSortOrderFromStr(s string) (SortOrder, error) {
+	switch s {
+	case "rank":
+		return SortRank, nil
+	case "colour", "color":
+		return SortColour, nil
+	case "hue":
+		return SortHue, nil
+	case "brightness":
+		return SortBrightness, nil
+	case "playcount":
+		return SortPlaycount, nil
+	case "alphabetical":
+		return SortAlphabetical, nil
+	case "random":
+		return SortRandom, nil
+	default:
+		return SortRank, ErrInvalidSortOrder
+	}
+}
+
+// NeedsColour reports whether the sort order requires the dominant colour of each tile
+func (s SortOrder) NeedsColour() bool {
+	return s == SortColour || s == SortHue || s == SortBrightness
+}
+
+// saturation below which a colour is treated as a shade of grey when sorting by colour
+const greyscaleSaturation = 0.15
+
+type tile struct {
+	element CollageElement
+	img     image.Image
+	colour  color.RGBA
+}
+
+func (t tile) playcount() int {
+	playcount, err := strconv.Atoi(t.element.Parameters["playcount"])
+	if err != nil {
+		return 0
+	}
+	return playcount
+}
+
+// name returns the most specific name of the element, e.g. the track name for tracks
+func (t tile) name() string {
+	for _, key := range []string{"tag", "track", "album", "artist"} {
+		if val := t.element.Parameters[key]; val != "" {
+			return strings.ToLower(val)
+		}
+	}
+	return ""
+}
+
+func (t tile) brightness() float64 {
+	return luminance(rgbToVector(t.colour))
+}
+
+// sortTiles orders the tiles in place, shuffling them with rng for a random order,
+// or with the global source if it is nil. Tiles without an image are always placed
+// last when sorting by colour, so the gaps end up at the end of the collage.
+func sortTiles(tiles []tile, order SortOrder, rng *rand.Rand) {
+	switch order {
+	case SortRandom:
+		shuffle := rand.Shuffle
+		if rng != nil {
+			shuffle = rng.Shuffle
+		}
+		shuffle(len(tiles), func(i, j int) {
+			tiles[i], tiles[j] = tiles[j], tiles[i]
+		})
+		return
+	case SortPlaycount:
+		slices.SortStableFunc(tiles, func(a, b tile) int {
+			return cmp.Or(
+				cmp.Compare(b.playcount(), a.playcount()),
+				cmp.Compare(a.element.Index, b.element.Index),
+			)
+		})
+		return
+	case SortAlphabetical:
+		slices.SortStableFunc(tiles, func(a, b tile) int {
+			return cmp.Or(
+				cmp.Compare(a.name(), b.name()),
+				cmp.Compare(a.element.Index, b.element.Index),
+			)
+		})
+		return
+	case SortRank:
+		slices.SortStableFunc(tiles, func(a, b tile) int {
+			return cmp.Compare(a.element.Index, b.element.Index)
+		})
+		return
+	}
+
+	slices.SortStableFunc(tiles, func(a, b tile) int {
+		if (a.img == nil) != (b.img == nil) {
+			if a.img == nil {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Or(compareColours(a, b, order), cmp.Compare(a.element.Index, b.element.Index))
+	})
+}
+
+func compareColours(a, b tile, order SortOrder) int {
+	switch order {
+	case SortBrightness:
+		// brightest first
+		return cmp.Compare(b.brightness(), a.brightness())
+	case SortHue:
+		hueA, _, _ := hsv(a.colour)
+		hueB, _, _ := hsv(b.colour)
+		return cmp.Compare(hueA, hueB)
+	default:
+		// rainbow order: colours by hue, followed by the greys from light to dark
+		hueA, satA, _ := hsv(a.colour)
+		hueB, satB, _ := hsv(b.colour)
+		greyA, greyB := satA < greyscaleSaturation, satB < greyscaleSaturation
+		if greyA != greyB {
+			if greyA {
+				return 1
+			}
+			return -1
+		}
+		if greyA {
+			return cmp.Compare(b.brightness(), a.brightness())
+		}
+		// bucket hues so similar colours are grouped and ordered light to dark
+		bucketA, bucketB := int(hueA/30), int(hueB/30)
+		return cmp.Or(cmp.Compare(bucketA, bucketB), cmp.Compare(b.brightness(), a.brightness()))
+	}
+}
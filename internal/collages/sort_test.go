@@ -0,0 +1,124 @@
+package collages
+
+import (
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// sortTestTiles returns tiles of different colours, playcounts and names, with
+// the tile at index 4 missing its image
+func sortTestTiles() []tile {
+	tiles := []struct {
+		colour    color.RGBA
+		playcount int
+		name      string
+	}{
+		{color.RGBA{R: 255, A: 255}, 5, "Charlie"},
+		{color.RGBA{B: 255, A: 255}, 20, "alpha"},
+		{color.RGBA{R: 250, G: 250, B: 250, A: 255}, 5, "Bravo"},
+		{color.RGBA{G: 200, A: 255}, 1, "delta"},
+		{color.RGBA{}, 30, "echo"},
+		{color.RGBA{R: 40, G: 40, B: 40, A: 255}, 0, "foxtrot"},
+	}
+	result := make([]tile, len(tiles))
+	for i, t := range tiles {
+		result[i] = tile{
+			element: CollageElement{
+				Index: i,
+				Parameters: map[string]string{
+					"album":     t.name,
+					"playcount": strconv.Itoa(t.playcount),
+				},
+			},
+			colour: t.colour,
+		}
+		if i != 4 {
+			result[i].img = image.NewRGBA(image.Rect(0, 0, 1, 1))
+		}
+	}
+	// the tiles arrive in whatever order their artwork was downloaded
+	slices.Reverse(result)
+	return result
+}
+
+func tileIndexes(tiles []tile) []int {
+	indexes := make([]int, len(tiles))
+	for i, t := range tiles {
+		indexes[i] = t.element.Index
+	}
+	return indexes
+}
+
+func TestSortTiles(t *testing.T) {
+	testCases := map[SortOrder][]int{
+		SortRank:         {0, 1, 2, 3, 4, 5},
+		SortPlaycount:    {4, 1, 0, 2, 3, 5},
+		SortAlphabetical: {1, 2, 0, 3, 4, 5},
+		SortHue:          {0, 2, 5, 3, 1, 4},
+		SortBrightness:   {2, 3, 0, 5, 1, 4},
+		SortColour:       {0, 3, 1, 2, 5, 4},
+	}
+	for order, expected := range testCases {
+		t.Run(string(order), func(t *testing.T) {
+			tiles := sortTestTiles()
+			sortTiles(tiles, order, nil)
+			if indexes := tileIndexes(tiles); !reflect.DeepEqual(indexes, expected) {
+				t.Errorf("expected %v, got %v", expected, indexes)
+			}
+		})
+	}
+}
+
+func TestSortTilesRandom(t *testing.T) {
+	shuffled := func(seed uint64) []int {
+		tiles := sortTestTiles()
+		sortTiles(tiles, SortRandom, rand.New(rand.NewPCG(seed, seed)))
+		return tileIndexes(tiles)
+	}
+
+	first := shuffled(1)
+	if second := shuffled(1); !reflect.DeepEqual(first, second) {
+		t.Errorf("expected the same order for the same seed, got %v and %v", first, second)
+	}
+	sorted := slices.Sorted(slices.Values(first))
+	if !reflect.DeepEqual(sorted, []int{0, 1, 2, 3, 4, 5}) {
+		t.Errorf("expected every tile once, got %v", first)
+	}
+}
+
+func TestCompareColours(t *testing.T) {
+	colourTile := func(r, g, b uint8) tile {
+		return tile{colour: color.RGBA{R: r, G: g, B: b, A: 255}}
+	}
+	red, darkRed := colourTile(255, 0, 0), colourTile(120, 0, 0)
+	orange, blue := colourTile(255, 90, 0), colourTile(0, 0, 255)
+	white, black := colourTile(255, 255, 255), colourTile(0, 0, 0)
+
+	testCases := map[string]struct {
+		a, b     tile
+		order    SortOrder
+		expected int
+	}{
+		"hue":                           {a: red, b: blue, order: SortHue, expected: -1},
+		"hue ignores brightness":        {a: darkRed, b: red, order: SortHue, expected: 0},
+		"brightest first":               {a: white, b: red, order: SortBrightness, expected: -1},
+		"darkest last":                  {a: black, b: blue, order: SortBrightness, expected: 1},
+		"colours before greys":          {a: white, b: blue, order: SortColour, expected: 1},
+		"greys light to dark":           {a: white, b: black, order: SortColour, expected: -1},
+		"colours by hue bucket":         {a: blue, b: orange, order: SortColour, expected: 1},
+		"similar hues light to dark":    {a: darkRed, b: red, order: SortColour, expected: 1},
+		"similar hues ignore hue order": {a: orange, b: darkRed, order: SortColour, expected: -1},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if result := compareColours(tc.a, tc.b, tc.order); result != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package collages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/clients/listenbrainz"
+)
+
+var ErrInvalidSource = errors.New("invalid source")
+
+// Source is the service the listening history is fetched from
+type Source string
+
+const (
+	SourceLastfm       Source = "lastfm"
+	SourceLibrefm      Source = "librefm"
+	SourceListenBrainz Source = "listenbrainz"
+)
+
+func GetSourceFromStr(s string) (Source, error) {
+	switch s {
+	case "lastfm":
+		return SourceLastfm, nil
+	case "librefm":
+		return SourceLibrefm, nil
+	case "listenbrainz":
+		return SourceListenBrainz, nil
+	default:
+		return SourceLastfm, ErrInvalidSource
+	}
+}
+
+// ListeningSource fetches a user's top albums, artists and tracks for a period. The
+// handler is called with each page and returns the number of items kept so far,
+// pages are fetched until it reaches count or there are none left.
+type ListeningSource interface {
+	TopAlbums(
+		ctx context.Context,
+		username string,
+		period lastfm.Period,
+		count int,
+		pageSize int,
+		handler func(page []LastfmAlbum) (int, error),
+	) error
+	TopArtists(
+		ctx context.Context,
+		username string,
+		period lastfm.Period,
+		count int,
+		pageSize int,
+		handler func(page []LastfmArtist) (int, error),
+	) error
+	TopTracks(
+		ctx context.Context,
+		username string,
+		period lastfm.Period,
+		count int,
+		pageSize int,
+		handler func(page []LastfmTrack) (int, error),
+	) error
+}
+
+func listeningSource(source Source) ListeningSource {
+	switch source {
+	case SourceListenBrainz:
+		return listenBrainzSource{}
+	case SourceLibrefm:
+		return lastfmSource{service: lastfm.ServiceLibrefm}
+	default:
+		return lastfmSource{service: lastfm.ServiceLastfm}
+	}
+}
+
+// lastfmSource fetches the top lists from Last.fm or a compatible service
+type lastfmSource struct {
+	service lastfm.Service
+}
+
+func (s lastfmSource) TopAlbums(
+	ctx context.Context,
+	username string,
+	period lastfm.Period,
+	count int,
+	pageSize int,
+	handler func(page []LastfmAlbum) (int, error),
+) error {
+	return lastfm.GetServiceResponse(
+		ctx,
+		s.service,
+		lastfm.MethodAlbum,
+		username,
+		period,
+		count,
+		pageSize,
+		func(data io.Reader) (int, int, error) {
+			var response LastfmTopAlbums
+			if err := json.NewDecoder(data).Decode(&response); err != nil {
+				return 0, 0, err
+			}
+			return handleLastfmPage(response.TopAlbums.Albums, response.TopAlbums.Attr, handler)
+		},
+	)
+}
+
+func (s lastfmSource) TopArtists(
+	ctx context.Context,
+	username string,
+	period lastfm.Period,
+	count int,
+	pageSize int,
+	handler func(page []LastfmArtist) (int, error),
+) error {
+	return lastfm.GetServiceResponse(
+		ctx,
+		s.service,
+		lastfm.MethodArtist,
+		username,
+		period,
+		count,
+		pageSize,
+		func(data io.Reader) (int, int, error) {
+			var response LastfmTopArtists
+			if err := json.NewDecoder(data).Decode(&response); err != nil {
+				return 0, 0, err
+			}
+			return handleLastfmPage(response.TopArtists.Artists, response.TopArtists.Attr, handler)
+		},
+	)
+}
+
+func (s lastfmSource) TopTracks(
+	ctx context.Context,
+	username string,
+	period lastfm.Period,
+	count int,
+	pageSize int,
+	handler func(page []LastfmTrack) (int, error),
+) error {
+	return lastfm.GetServiceResponse(
+		ctx,
+		s.service,
+		lastfm.MethodTrack,
+		username,
+		period,
+		count,
+		pageSize,
+		func(data io.Reader) (int, int, error) {
+			var response LastfmTopTracks
+			if err := json.NewDecoder(data).Decode(&response); err != nil {
+				return 0, 0, err
+			}
+			return handleLastfmPage(response.TopTracks.Tracks, response.TopTracks.Attr, handler)
+		},
+	)
+}
+
+func handleLastfmPage[T any](
+	page []T,
+	attr lastfm.LastfmUser,
+	handler func(page []T) (int, error),
+) (int, int, error) {
+	totalPages, err := lastfm.ParseTotalPages(attr)
+	if err != nil {
+		return 0, 0, err
+	}
+	fetched, err := handler(page)
+	return fetched, totalPages, err
+}
+
+// listenBrainzSource converts the ListenBrainz statistics into the Last.fm types,
+// keeping the MusicBrainz IDs and Cover Art Archive artwork they come with
+type listenBrainzSource struct{}
+
+func (listenBrainzSource) TopAlbums(
+	ctx context.Context,
+	username string,
+	period lastfm.Period,
+	count int,
+	pageSize int,
+	handler func(page []LastfmAlbum) (int, error),
+) error {
+	return listenbrainz.GetUserStatistics(
+		ctx,
+		listenbrainz.EntityReleases,
+		username,
+		rangeForPeriod(period),
+		count,
+		pageSize,
+		func(stats listenbrainz.Statistics) (int, error) {
+			page := make([]LastfmAlbum, len(stats.Payload.Releases))
+			for i, release := range stats.Payload.Releases {
+				album := &page[i]
+				album.AlbumName = release.ReleaseName
+				album.Mbid = release.ReleaseMbid
+				album.Playcount = strconv.Itoa(release.ListenCount)
+				album.Artist.ArtistName = release.ArtistName
+				album.Artist.Mbid = singleMbid(release.ArtistMbids)
+				album.Images = coverArtImages(release.CaaReleaseMbid, release.CaaID)
+			}
+			return handler(page)
+		},
+	)
+}
+
+func (listenBrainzSource) TopArtists(
+	ctx context.Context,
+	username string,
+	period lastfm.Period,
+	count int,
+	pageSize int,
+	handler func(page []LastfmArtist) (int, error),
+) error {
+	return listenbrainz.GetUserStatistics(
+		ctx,
+		listenbrainz.EntityArtists,
+		username,
+		rangeForPeriod(period),
+		count,
+		pageSize,
+		func(stats listenbrainz.Statistics) (int, error) {
+			page := make([]LastfmArtist, len(stats.Payload.Artists))
+			for i, artist := range stats.Payload.Artists {
+				page[i].Name = artist.ArtistName
+				page[i].Mbid = artist.ArtistMbid
+				if page[i].Mbid == "" {
+					page[i].Mbid = singleMbid(artist.ArtistMbids)
+				}
+				page[i].Playcount = strconv.Itoa(artist.ListenCount)
+			}
+			return handler(page)
+		},
+	)
+}
+
+func (listenBrainzSource) TopTracks(
+	ctx context.Context,
+	username string,
+	period lastfm.Period,
+	count int,
+	pageSize int,
+	handler func(page []LastfmTrack) (int, error),
+) error {
+	return listenbrainz.GetUserStatistics(
+		ctx,
+		listenbrainz.EntityRecordings,
+		username,
+		rangeForPeriod(period),
+		count,
+		pageSize,
+		func(stats listenbrainz.Statistics) (int, error) {
+			page := make([]LastfmTrack, len(stats.Payload.Recordings))
+			for i, recording := range stats.Payload.Recordings {
+				track := &page[i]
+				track.Name = recording.TrackName
+				track.Mbid = recording.RecordingMbid
+				track.Playcount = strconv.Itoa(recording.ListenCount)
+				track.Artist.Name = recording.ArtistName
+				track.Artist.Mbid = singleMbid(recording.ArtistMbids)
+				track.Album = recording.ReleaseName
+				track.Images = coverArtImages(recording.CaaReleaseMbid, recording.CaaID)
+			}
+			return handler(page)
+		},
+	)
+}
+
+// rangeForPeriod maps the period to the closest ListenBrainz range. The ranges
+// end at the start of the current week, month or year, so recent listens are left
+// out until the next time the statistics are calculated.
+func rangeForPeriod(period lastfm.Period) listenbrainz.Range {
+	switch period {
+	case lastfm.PeriodSevenDays:
+		return listenbrainz.RangeWeek
+	case lastfm.PeriodOneMonth:
+		return listenbrainz.RangeMonth
+	case lastfm.PeriodThreeMonths:
+		return listenbrainz.RangeQuarter
+	case lastfm.PeriodSixMonths:
+		return listenbrainz.RangeHalfYear
+	case lastfm.PeriodTwelveMonths:
+		return listenbrainz.RangeYear
+	default:
+		return listenbrainz.RangeAllTime
+	}
+}
+
+// singleMbid returns the artist MBID for a single artist, collaborations don't
+// have one artist to look up
+func singleMbid(mbids []string) string {
+	if len(mbids) != 1 {
+		return ""
+	}
+	return mbids[0]
+}
+
+// coverArtImages returns the Cover Art Archive thumbnails of the release in the
+// Last.fm image sizes
+func coverArtImages(releaseMbid string, caaID int64) []lastfm.LastfmImage {
+	if releaseMbid == "" || caaID == 0 {
+		return nil
+	}
+	thumbnail := func(size int) string {
+		return fmt.Sprintf(
+			"https://coverartarchive.org/release/%s/%d-%d.jpg",
+			releaseMbid,
+			caaID,
+			size,
+		)
+	}
+	return []lastfm.LastfmImage{
+		{Size: "small", Link: thumbnail(250)},
+		{Size: "medium", Link: thumbnail(250)},
+		{Size: "large", Link: thumbnail(250)},
+		{Size: "extralarge", Link: thumbnail(500)},
+		{Size: "huge", Link: thumbnail(1200)},
+	}
+}
@@ -0,0 +1,24 @@
+package collages
+
+import (
+	"testing"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/clients/listenbrainz"
+)
+
+func TestRangeForPeriod(t *testing.T) {
+	testCases := map[lastfm.Period]listenbrainz.Range{
+		lastfm.PeriodSevenDays:    listenbrainz.RangeWeek,
+		lastfm.PeriodOneMonth:     listenbrainz.RangeMonth,
+		lastfm.PeriodThreeMonths:  listenbrainz.RangeQuarter,
+		lastfm.PeriodSixMonths:    listenbrainz.RangeHalfYear,
+		lastfm.PeriodTwelveMonths: listenbrainz.RangeYear,
+		lastfm.PeriodOverall:      listenbrainz.RangeAllTime,
+	}
+	for period, expected := range testCases {
+		if timeRange := rangeForPeriod(period); timeRange != expected {
+			t.Errorf("%s: expected %s, got %s", period, expected, timeRange)
+		}
+	}
+}
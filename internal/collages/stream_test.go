@@ -0,0 +1,205 @@
+package collages
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+// pagedSource serves the albums in pages of at most maxPageSize, waiting before
+// each page as a listening service would
+type pagedSource struct {
+	albums      []LastfmAlbum
+	maxPageSize int
+	pageDelay   time.Duration
+	// counts the pages served, if set
+	pages *int
+}
+
+func (s pagedSource) TopAlbums(
+	ctx context.Context,
+	username string,
+	period lastfm.Period,
+	count int,
+	pageSize int,
+	handler func(page []LastfmAlbum) (int, error),
+) error {
+	pageSize = min(pageSize, s.maxPageSize)
+	for start := 0; start < len(s.albums); start += pageSize {
+		time.Sleep(s.pageDelay)
+		if s.pages != nil {
+			*s.pages++
+		}
+		fetched, err := handler(s.albums[start:min(start+pageSize, len(s.albums))])
+		if err != nil {
+			return err
+		}
+		if fetched >= count {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s pagedSource) TopArtists(
+	ctx context.Context,
+	username string,
+	period lastfm.Period,
+	count int,
+	pageSize int,
+	handler func(page []LastfmArtist) (int, error),
+) error {
+	return nil
+}
+
+func (s pagedSource) TopTracks(
+	ctx context.Context,
+	username string,
+	period lastfm.Period,
+	count int,
+	pageSize int,
+	handler func(page []LastfmTrack) (int, error),
+) error {
+	return nil
+}
+
+// artworkServer serves a small jpeg for every path, waiting before each response
+func artworkServer(tb testing.TB, delay time.Duration) *httptest.Server {
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, 64, 64)), nil); err != nil {
+		tb.Fatalf("unable to encode jpeg: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(buf.Bytes())
+	}))
+	tb.Cleanup(server.Close)
+	return server
+}
+
+func testAlbums(artist string, count int, imageURL string) []LastfmAlbum {
+	albums := make([]LastfmAlbum, count)
+	for i := range albums {
+		albums[i].AlbumName = fmt.Sprintf("Album %d", i)
+		albums[i].Artist.ArtistName = artist
+		albums[i].Playcount = "1"
+		albums[i].Images = lastfm.List[lastfm.LastfmImage]{
+			{Size: "extralarge", Link: fmt.Sprintf("%s/%d.jpg", imageURL, i)},
+		}
+	}
+	return albums
+}
+
+func TestStreamAlbums(t *testing.T) {
+	server := artworkServer(t, 0)
+	source := pagedSource{albums: testAlbums("Stream", 25, server.URL), maxPageSize: 5}
+	options := ElementOptions{
+		Usernames: []string{"user"},
+		Count:     12,
+		ImageSize: "extralarge",
+		Filter:    Filter{Exclude: []string{"Album 3"}},
+	}
+
+	jobChan := make(chan CollageElement, len(source.albums))
+	if _, err := streamAlbums(context.Background(), source, options, jobChan); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(jobChan)
+
+	albums := map[int]string{}
+	for element := range jobChan {
+		if element.ImageBytes == nil {
+			t.Errorf("element %d: expected image", element.Index)
+		} else {
+			element.ImageBytes.Close()
+		}
+		albums[element.Index] = element.Parameters["album"]
+	}
+	if len(albums) != options.Count {
+		t.Fatalf("expected %d elements, got %d", options.Count, len(albums))
+	}
+	for i := range options.Count {
+		expected := fmt.Sprintf("Album %d", i)
+		if i >= 3 {
+			expected = fmt.Sprintf("Album %d", i+1)
+		}
+		if albums[i] != expected {
+			t.Errorf("index %d: expected %q, got %q", i, expected, albums[i])
+		}
+	}
+}
+
+// BenchmarkAlbumCollage measures the time from requesting the top albums to the
+// collage being drawn, with the artwork resolved after the whole list is fetched
+// or as each page arrives
+func BenchmarkAlbumCollage(b *testing.B) {
+	b.Setenv("LASTFM_ENDPOINT", "http://localhost")
+	b.Setenv("LASTFM_API_KEY", "key")
+	b.Setenv("FANART_API_KEY", "key")
+	if err := config.Init(); err != nil {
+		b.Fatalf("unable to init config: %v", err)
+	}
+
+	const count = 100
+	server := artworkServer(b, 20*time.Millisecond)
+	source := pagedSource{
+		albums:      testAlbums("Benchmark", count, server.URL),
+		maxPageSize: 25,
+		pageDelay:   50 * time.Millisecond,
+	}
+	options := ElementOptions{
+		Usernames: []string{"user"},
+		Count:     count,
+		ImageSize: "extralarge",
+	}
+	displayOptions := DisplayOptions{ImageDimension: 64, Columns: 10, Rows: 10}
+
+	type buildFunc func(ctx context.Context, jobChan chan<- CollageElement) error
+	run := func(b *testing.B, build buildFunc) {
+		for b.Loop() {
+			ctx := context.Background()
+			jobChan := make(chan CollageElement, count)
+			var wg sync.WaitGroup
+			var err error
+			wg.Go(func() {
+				defer close(jobChan)
+				err = build(ctx, jobChan)
+			})
+			if _, _, err := CreateCollage(ctx, displayOptions, jobChan); err != nil {
+				b.Fatalf("unable to create collage: %v", err)
+			}
+			wg.Wait()
+			if err != nil {
+				b.Fatalf("unable to build elements: %v", err)
+			}
+		}
+	}
+
+	b.Run("sequential", func(b *testing.B) {
+		run(b, func(ctx context.Context, jobChan chan<- CollageElement) error {
+			albums, err := getLastfmAlbums(ctx, source, "user", "", count, nil, false)
+			if err != nil {
+				return err
+			}
+			getAlbumElements(ctx, albums, options.ImageSize, jobChan)
+			return nil
+		})
+	})
+	b.Run("streaming", func(b *testing.B) {
+		run(b, func(ctx context.Context, jobChan chan<- CollageElement) error {
+			_, err := streamAlbums(ctx, source, options, jobChan)
+			return err
+		})
+	})
+}
@@ -0,0 +1,245 @@
+package collages
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/fogleman/gg"
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/config"
+	"github.com/SongStitch/song-stitch/internal/progress"
+)
+
+var ErrCollageTooLarge = errors.New("collage is too large to render")
+
+// tiles smaller than this are too small to be worth rendering, so the collage is
+// refused instead
+const minTileDimension = 32
+
+// FitPixelBudget shrinks the tiles until the collage fits within the configured
+// pixel budget, returning ErrCollageTooLarge if the tiles would be too small or
+// the requested output size is over the budget
+func FitPixelBudget(displayOptions *DisplayOptions) error {
+	budget := math.MaxInt
+	if cfg := config.GetConfig(); cfg != nil && cfg.Render.MaxPixels > 0 {
+		budget = cfg.Render.MaxPixels
+	}
+
+	if int(displayOptions.Width)*int(displayOptions.Height) > budget /* #nosec G115 */ {
+		return ErrCollageTooLarge
+	}
+	tiles := displayOptions.Rows * displayOptions.Columns
+	if tiles == 0 || displayOptions.ImageDimension*displayOptions.ImageDimension*tiles <= budget {
+		return nil
+	}
+	dimension := int(math.Sqrt(float64(budget) / float64(tiles)))
+	if dimension < minTileDimension {
+		return ErrCollageTooLarge
+	}
+	displayOptions.ImageDimension = dimension
+	return nil
+}
+
+// drawInStrips reports whether the collage is large enough to be drawn in strips,
+// and can be, as resizing, WebP encoding and sorting all need the whole canvas.
+// Tiles are usually drawn at the requested size already, as for print sizes, so
+// those collages are only resized if the grid doesn't divide the size evenly.
+func drawInStrips(displayOptions DisplayOptions) bool {
+	cfg := config.GetConfig()
+	if cfg == nil || cfg.Render.StripPixels <= 0 {
+		return false
+	}
+	width := displayOptions.ImageDimension * displayOptions.Columns
+	height := displayOptions.ImageDimension * displayOptions.Rows
+	sorted := displayOptions.Sort != "" && displayOptions.Sort != SortRank
+	return width*height > cfg.Render.StripPixels &&
+		!needsResize(displayOptions) &&
+		!(displayOptions.Webp && !displayOptions.Grayscale) &&
+		!sorted
+}
+
+// needsResize reports whether the collage was requested at a size other than the
+// size of its grid of tiles
+func needsResize(displayOptions DisplayOptions) bool {
+	if !displayOptions.Resize {
+		return false
+	}
+	width := displayOptions.ImageDimension * displayOptions.Columns
+	height := displayOptions.ImageDimension * displayOptions.Rows
+	return (displayOptions.Width > 0 && int(displayOptions.Width) != width) || // #nosec G115
+		(displayOptions.Height > 0 && int(displayOptions.Height) != height) // #nosec G115
+}
+
+type stripTile struct {
+	element CollageElement
+	artwork []byte
+}
+
+type collageStrip struct {
+	row int
+	dc  *gg.Context
+	img *image.RGBA
+}
+
+// stripCollage is a collage drawn a row of tiles at a time as it is read, keeping
+// the downloaded artwork rather than the decoded tiles, so a large collage is
+// encoded without the whole canvas in memory. The last two strips are kept, as a
+// JPEG encoder reads blocks spanning the boundary between them. It isn't safe for
+// concurrent use.
+type stripCollage struct {
+	ctx            context.Context
+	displayOptions DisplayOptions
+	tiles          []stripTile
+	strips         [2]*collageStrip
+	next           int
+}
+
+// createStripCollage downloads the artwork for every element, the collage is
+// drawn once it is read
+func createStripCollage(
+	ctx context.Context,
+	displayOptions DisplayOptions,
+	jobChan <-chan CollageElement,
+) *stripCollage {
+	logger := zerolog.Ctx(ctx)
+	tracker := progress.FromContext(ctx)
+	c := &stripCollage{
+		ctx:            ctx,
+		displayOptions: displayOptions,
+		tiles:          make([]stripTile, displayOptions.Rows*displayOptions.Columns),
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Go(func() {
+			for element := range jobChan {
+				tracker.TileResolved()
+				var artwork []byte
+				if !isNil(element.ImageBytes) {
+					var err error
+					artwork, err = io.ReadAll(element.ImageBytes)
+					element.ImageBytes.Close()
+					if err != nil {
+						logger.Error().
+							Err(err).
+							Int("index", element.Index).
+							Msg("failed reading image")
+					}
+				}
+				element.ImageBytes = nil
+				if element.Index < len(c.tiles) {
+					c.tiles[element.Index] = stripTile{element: element, artwork: artwork}
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return c
+}
+
+func (c *stripCollage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (c *stripCollage) Bounds() image.Rectangle {
+	return image.Rect(
+		0,
+		0,
+		c.displayOptions.ImageDimension*c.displayOptions.Columns,
+		c.displayOptions.ImageDimension*c.displayOptions.Rows,
+	)
+}
+
+func (c *stripCollage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(c.Bounds())) {
+		return color.RGBA{}
+	}
+	row := y / c.displayOptions.ImageDimension
+	strip := c.strip(row)
+	return strip.img.RGBAAt(x, y-row*c.displayOptions.ImageDimension)
+}
+
+// strip returns the drawn strip for the row of tiles, drawing it over the least
+// recently drawn strip if it isn't one of the last two
+func (c *stripCollage) strip(row int) *collageStrip {
+	for _, strip := range c.strips {
+		if strip != nil && strip.row == row {
+			return strip
+		}
+	}
+
+	strip := c.strips[c.next]
+	if strip == nil {
+		dimension := c.displayOptions.ImageDimension
+		dc := gg.NewContext(dimension*c.displayOptions.Columns, dimension)
+		fontFile := fontFileRegular
+		if c.displayOptions.BoldFont {
+			fontFile = fontFileBold
+		}
+		dc.LoadFontFace(fontFile, c.displayOptions.FontSize)
+		strip = &collageStrip{dc: dc, img: dc.Image().(*image.RGBA)}
+		c.strips[c.next] = strip
+	}
+	c.next = (c.next + 1) % len(c.strips)
+	c.drawStrip(strip, row)
+	return strip
+}
+
+func (c *stripCollage) drawStrip(strip *collageStrip, row int) {
+	strip.row = row
+	clear(strip.img.Pix)
+
+	dimension := c.displayOptions.ImageDimension
+	tiles := c.tiles[row*c.displayOptions.Columns : (row+1)*c.displayOptions.Columns]
+	for column, t := range tiles {
+		if t.artwork == nil {
+			continue
+		}
+		img, err := getImage(io.NopCloser(bytes.NewReader(t.artwork)), t.element.ImageExt)
+		if err != nil {
+			zerolog.Ctx(c.ctx).
+				Error().
+				Err(err).
+				Int("index", t.element.Index).
+				Msg("failed parsing image")
+			continue
+		}
+		if img != nil {
+			tile := fitToSquare(img, dimension, c.displayOptions.Fit)
+			strip.dc.DrawImage(tile, column*dimension, 0)
+		}
+	}
+	// text is drawn after every tile so it isn't covered by the tile next to it
+	tracker := progress.FromContext(c.ctx)
+	for column, t := range tiles {
+		if t.element.Parameters == nil {
+			continue
+		}
+		placeText(strip.dc, t.element, c.displayOptions, float64(column*dimension), 0)
+		tracker.TileRendered()
+	}
+
+	if c.displayOptions.Grayscale {
+		grayscaleInPlace(strip.img)
+	}
+}
+
+// grayscaleInPlace converts the image to grayscale without another copy of it
+func grayscaleInPlace(img *image.RGBA) {
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		y := color.GrayModel.Convert(color.RGBA{
+			R: img.Pix[i],
+			G: img.Pix[i+1],
+			B: img.Pix[i+2],
+			A: img.Pix[i+3],
+		}).(color.Gray).Y
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = y, y, y
+	}
+}
@@ -0,0 +1,199 @@
+package collages
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+func initRenderConfig(t *testing.T, maxPixels, stripPixels int) {
+	t.Helper()
+	t.Setenv("LASTFM_ENDPOINT", "http://localhost")
+	t.Setenv("LASTFM_API_KEY", "key")
+	t.Setenv("FANART_API_KEY", "key")
+	t.Setenv("COLLAGE_MAX_PIXELS", strconv.Itoa(maxPixels))
+	t.Setenv("COLLAGE_STRIP_PIXELS", strconv.Itoa(stripPixels))
+	if err := config.Init(); err != nil {
+		t.Fatalf("unable to init config: %v", err)
+	}
+}
+
+func TestFitPixelBudget(t *testing.T) {
+	initRenderConfig(t, 1_000_000, 0)
+
+	testCases := map[string]struct {
+		options   DisplayOptions
+		dimension int
+		err       error
+	}{
+		"within budget": {
+			options:   DisplayOptions{Rows: 3, Columns: 3, ImageDimension: 300},
+			dimension: 300,
+		},
+		"tiles shrunk": {
+			options:   DisplayOptions{Rows: 10, Columns: 10, ImageDimension: 300},
+			dimension: 100,
+		},
+		"tiles too small": {
+			options: DisplayOptions{Rows: 40, Columns: 40, ImageDimension: 174},
+			err:     ErrCollageTooLarge,
+		},
+		"output too large": {
+			options: DisplayOptions{
+				Rows:           3,
+				Columns:        3,
+				ImageDimension: 300,
+				Width:          2000,
+				Height:         1000,
+			},
+			err: ErrCollageTooLarge,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			options := tc.options
+			err := FitPixelBudget(&options)
+			if err != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if err == nil && options.ImageDimension != tc.dimension {
+				t.Errorf("expected dimension %d, got %d", tc.dimension, options.ImageDimension)
+			}
+		})
+	}
+}
+
+func TestDrawInStrips(t *testing.T) {
+	initRenderConfig(t, 100_000_000, 1_000_000)
+
+	testCases := map[string]struct {
+		options DisplayOptions
+		strips  bool
+	}{
+		"under the strip size": {
+			options: DisplayOptions{Rows: 3, Columns: 3, ImageDimension: 300},
+		},
+		"over the strip size": {
+			options: DisplayOptions{Rows: 10, Columns: 10, ImageDimension: 300},
+			strips:  true,
+		},
+		"requested at the grid size": {
+			options: DisplayOptions{
+				Rows:           10,
+				Columns:        10,
+				ImageDimension: 300,
+				Resize:         true,
+				Width:          3000,
+				Height:         3000,
+			},
+			strips: true,
+		},
+		"requested at the grid width": {
+			options: DisplayOptions{
+				Rows:           10,
+				Columns:        10,
+				ImageDimension: 300,
+				Resize:         true,
+				Width:          3000,
+			},
+			strips: true,
+		},
+		"resized": {
+			options: DisplayOptions{
+				Rows:           10,
+				Columns:        10,
+				ImageDimension: 300,
+				Resize:         true,
+				Width:          2990,
+			},
+		},
+		"webp": {
+			options: DisplayOptions{Rows: 10, Columns: 10, ImageDimension: 300, Webp: true},
+		},
+		"sorted": {
+			options: DisplayOptions{Rows: 10, Columns: 10, ImageDimension: 300, Sort: SortHue},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if strips := drawInStrips(tc.options); strips != tc.strips {
+				t.Errorf("expected strips %t, got %t", tc.strips, strips)
+			}
+		})
+	}
+}
+
+// renderTestCollage creates a collage of differently coloured tiles, with the
+// bottom right tile missing
+func renderTestCollage(t *testing.T, displayOptions DisplayOptions) image.Image {
+	t.Helper()
+	count := displayOptions.Rows*displayOptions.Columns - 1
+	jobChan := make(chan CollageElement, count)
+	for i := range count {
+		img := image.NewRGBA(image.Rect(0, 0, 50, 40))
+		fill := color.RGBA{R: uint8(i * 30), G: 128, B: uint8(255 - i*30), A: 255}
+		for y := range 40 {
+			for x := range 50 {
+				img.SetRGBA(x, y, fill)
+			}
+		}
+		buf := new(bytes.Buffer)
+		if err := jpeg.Encode(buf, img, nil); err != nil {
+			t.Fatalf("unable to encode jpeg: %v", err)
+		}
+		jobChan <- CollageElement{
+			Index:      i,
+			Parameters: map[string]string{"album": "Album"},
+			ImageBytes: io.NopCloser(buf),
+			ImageExt:   ".jpg",
+		}
+	}
+	close(jobChan)
+
+	collage, _, err := CreateCollage(context.Background(), displayOptions, jobChan)
+	if err != nil {
+		t.Fatalf("unable to create collage: %v", err)
+	}
+	return collage
+}
+
+func TestStripCollage(t *testing.T) {
+	displayOptions := DisplayOptions{Rows: 3, Columns: 4, ImageDimension: 36, FontSize: 12}
+
+	initRenderConfig(t, 1_000_000, 0)
+	expected := renderTestCollage(t, displayOptions)
+	if _, ok := expected.(*stripCollage); ok {
+		t.Fatal("expected the collage to be drawn on a single canvas")
+	}
+
+	initRenderConfig(t, 1_000_000, 1)
+	collage := renderTestCollage(t, displayOptions)
+	if _, ok := collage.(*stripCollage); !ok {
+		t.Fatal("expected the collage to be drawn in strips")
+	}
+
+	if collage.Bounds() != expected.Bounds() {
+		t.Fatalf("expected bounds %v, got %v", expected.Bounds(), collage.Bounds())
+	}
+	bounds := expected.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			want := color.RGBAModel.Convert(expected.At(x, y))
+			if got := collage.At(x, y); got != want {
+				t.Fatalf("pixel (%d, %d): expected %v, got %v", x, y, want, got)
+			}
+		}
+	}
+
+	// a JPEG encoder reads across the strip boundaries
+	if err := jpeg.Encode(io.Discard, collage, nil); err != nil {
+		t.Fatalf("unable to encode collage: %v", err)
+	}
+}
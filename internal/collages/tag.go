@@ -0,0 +1,269 @@
+package collages
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+	"github.com/SongStitch/song-stitch/internal/config"
+)
+
+const (
+	// number of top artists whose tags make up the user's genres
+	tagArtistSampleSize = 100
+	// number of top albums searched for the album representing each genre
+	tagAlbumSampleSize = 500
+	// number of each artist's top tags that their plays are shared between
+	tagsPerArtist = 5
+	// maximum number of concurrent artist.gettoptags requests
+	tagLookupConcurrency = 8
+)
+
+// tags that describe the listener rather than the music
+var ignoredTags = map[string]bool{
+	"seen live":    true,
+	"favorites":    true,
+	"favourites":   true,
+	"favorite":     true,
+	"favourite":    true,
+	"my favorite":  true,
+	"my favourite": true,
+	"love":         true,
+	"awesome":      true,
+	"beautiful":    true,
+	"albums i own": true,
+}
+
+type tagShare struct {
+	name string
+	// playcount of the user's top artists attributed to the tag
+	plays float64
+	// percentage of the sampled listening attributed to the tag
+	share float64
+}
+
+// GetElementsForTag sends an element for each of the user's top genres, drawn
+// with the user's most played album in that genre
+func GetElementsForTag(
+	ctx context.Context,
+	options ElementOptions,
+	displayOptions DisplayOptions,
+	jobChan chan<- CollageElement,
+) error {
+	config := config.GetConfig()
+	if options.Count > config.MaxImages.Artists {
+		return lastfm.ErrTooManyImages
+	}
+	return getTags(ctx, options, jobChan)
+}
+
+func getTags(ctx context.Context, options ElementOptions, jobChan chan<- CollageElement) error {
+	start := time.Now()
+	logger := zerolog.Ctx(ctx)
+
+	artistOptions := options
+	artistOptions.Count = tagArtistSampleSize
+	albumOptions := options
+	albumOptions.Count = tagAlbumSampleSize
+
+	var artists []LastfmArtist
+	var albums []LastfmAlbum
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		artists, errs[0] = getLastfmArtistsForUsers(ctx, artistOptions)
+	})
+	wg.Go(func() {
+		albums, errs[1] = getLastfmAlbumsForUsers(ctx, albumOptions)
+	})
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	artistTags := getArtistTags(ctx, artists)
+	tags := rankTags(artists, artistTags)
+	tags = tags[:min(len(tags), options.Count)]
+	tags = fitItems(&options, tags, func(tag tagShare) int {
+		return int(tag.plays + 0.5)
+	})
+	representatives := pickTagAlbums(tags, albums, artistTags)
+
+	// the albums are fetched as usual and relabelled with the genre they represent
+	tagAlbums := []LastfmAlbum{}
+	tagIndexes := []int{}
+	for i, album := range representatives {
+		if album == nil {
+			jobChan <- CollageElement{Index: i, Parameters: tagParameters(tags[i], nil)}
+			continue
+		}
+		tagAlbums = append(tagAlbums, *album)
+		tagIndexes = append(tagIndexes, i)
+	}
+	albumChan := make(chan CollageElement, len(tagAlbums))
+	cacheCount := getAlbumElements(ctx, tagAlbums, options.ImageSize, albumChan)
+	close(albumChan)
+	for element := range albumChan {
+		i := tagIndexes[element.Index]
+		element.Index = i
+		element.Parameters = tagParameters(tags[i], element.Parameters)
+		jobChan <- element
+	}
+
+	logger.Info().
+		Int64("cacheCount", cacheCount).
+		Strs("usernames", options.Usernames).
+		Int("totalCount", options.Count).
+		Int("tags", len(tags)).
+		Dur("duration", time.Since(start)).
+		Str("method", "tag").
+		Msg("Image URLs fetched")
+	return nil
+}
+
+// getArtistTags looks up the top tags of each artist, keyed by artist key. Artists
+// whose tags can't be found are left out.
+func getArtistTags(ctx context.Context, artists []LastfmArtist) map[string][]lastfm.ArtistTag {
+	logger := zerolog.Ctx(ctx)
+
+	tags := make([][]lastfm.ArtistTag, len(artists))
+	sem := make(chan struct{}, tagLookupConcurrency)
+	var wg sync.WaitGroup
+	for i, artist := range artists {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			artistTags, err := lastfm.GetArtistTopTags(ctx, artist.Name, artist.Mbid)
+			if err != nil && artist.Mbid != "" {
+				// MBIDs from top lists are not always known to artist.gettoptags
+				artistTags, err = lastfm.GetArtistTopTags(ctx, artist.Name, "")
+			}
+			if err != nil {
+				logger.Warn().Err(err).Str("artist", artist.Name).Msg("Unable to get artist tags")
+				return
+			}
+			tags[i] = artistTags
+		})
+	}
+	wg.Wait()
+
+	result := make(map[string][]lastfm.ArtistTag, len(artists))
+	for i, artist := range artists {
+		if tags[i] != nil {
+			result[artistKey(artist)] = genreTags(artist.Name, tags[i])
+		}
+	}
+	return result
+}
+
+// genreTags normalises the tag names and keeps the artist's top tags that describe
+// the music
+func genreTags(artistName string, tags []lastfm.ArtistTag) []lastfm.ArtistTag {
+	result := []lastfm.ArtistTag{}
+	for _, tag := range tags {
+		name := normaliseName(tag.Name)
+		if tag.Count <= 0 || name == "" || ignoredTags[name] || name == normaliseName(artistName) {
+			continue
+		}
+		result = append(result, lastfm.ArtistTag{Name: name, Count: tag.Count})
+		if len(result) == tagsPerArtist {
+			break
+		}
+	}
+	return result
+}
+
+// rankTags shares each artist's plays between their tags by tag weight and orders
+// the tags by the plays attributed to them
+func rankTags(artists []LastfmArtist, artistTags map[string][]lastfm.ArtistTag) []tagShare {
+	plays := map[string]float64{}
+	order := []string{}
+	total := 0.0
+	for _, artist := range artists {
+		artistPlays := float64(parsePlaycount(artist.Playcount))
+		total += artistPlays
+
+		tags := artistTags[artistKey(artist)]
+		weight := 0
+		for _, tag := range tags {
+			weight += tag.Count
+		}
+		for _, tag := range tags {
+			if _, ok := plays[tag.Name]; !ok {
+				order = append(order, tag.Name)
+			}
+			plays[tag.Name] += artistPlays * float64(tag.Count) / float64(weight)
+		}
+	}
+
+	tags := make([]tagShare, len(order))
+	for i, name := range order {
+		tags[i] = tagShare{name: name, plays: plays[name]}
+		if total > 0 {
+			tags[i].share = plays[name] / total * 100
+		}
+	}
+	slices.SortStableFunc(tags, func(a, b tagShare) int {
+		return cmp.Compare(b.plays, a.plays)
+	})
+	return tags
+}
+
+// pickTagAlbums finds the most played album whose artist has each tag, preferring
+// albums that don't already represent a higher ranked tag. A tag with no album is
+// left as nil.
+func pickTagAlbums(
+	tags []tagShare,
+	albums []LastfmAlbum,
+	artistTags map[string][]lastfm.ArtistTag,
+) []*LastfmAlbum {
+	result := make([]*LastfmAlbum, len(tags))
+	used := make([]bool, len(albums))
+	for i, tag := range tags {
+		pick := -1
+		for j := range albums {
+			hasTag := slices.ContainsFunc(
+				artistTags[normaliseName(albums[j].Artist.ArtistName)],
+				func(t lastfm.ArtistTag) bool { return t.Name == tag.name },
+			)
+			if !hasTag {
+				continue
+			}
+			if !used[j] {
+				pick = j
+				break
+			}
+			if pick == -1 {
+				pick = j
+			}
+		}
+		if pick != -1 {
+			used[pick] = true
+			result[i] = &albums[pick]
+		}
+	}
+	return result
+}
+
+func tagParameters(tag tagShare, albumParameters map[string]string) map[string]string {
+	parameters := map[string]string{
+		"tag":       tag.name,
+		"share":     fmt.Sprintf("%.1f%%", tag.share),
+		"playcount": strconv.Itoa(int(tag.plays + 0.5)),
+	}
+	if albumParameters != nil {
+		parameters["artist"] = albumParameters["artist"]
+		parameters["album"] = albumParameters["album"]
+	}
+	return parameters
+}
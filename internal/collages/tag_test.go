@@ -0,0 +1,194 @@
+package collages
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/SongStitch/song-stitch/internal/clients/lastfm"
+)
+
+func TestGenreTags(t *testing.T) {
+	testCases := map[string]struct {
+		artist   string
+		tags     []lastfm.ArtistTag
+		expected []lastfm.ArtistTag
+	}{
+		"normalised": {
+			artist:   "Slowdive",
+			tags:     []lastfm.ArtistTag{{Name: " Dream  Pop", Count: 100}},
+			expected: []lastfm.ArtistTag{{Name: "dream pop", Count: 100}},
+		},
+		"listener tags dropped": {
+			artist: "Slowdive",
+			tags: []lastfm.ArtistTag{
+				{Name: "Seen Live", Count: 100},
+				{Name: "shoegaze", Count: 90},
+				{Name: "favourites", Count: 80},
+			},
+			expected: []lastfm.ArtistTag{{Name: "shoegaze", Count: 90}},
+		},
+		"artist name dropped": {
+			artist: "Slowdive",
+			tags: []lastfm.ArtistTag{
+				{Name: "slowdive", Count: 100},
+				{Name: "shoegaze", Count: 90},
+			},
+			expected: []lastfm.ArtistTag{{Name: "shoegaze", Count: 90}},
+		},
+		"unweighted and empty tags dropped": {
+			artist: "Slowdive",
+			tags: []lastfm.ArtistTag{
+				{Name: "shoegaze", Count: 90},
+				{Name: "ambient", Count: 0},
+				{Name: " ", Count: 50},
+			},
+			expected: []lastfm.ArtistTag{{Name: "shoegaze", Count: 90}},
+		},
+		"only the top tags": {
+			artist: "Slowdive",
+			tags: []lastfm.ArtistTag{
+				{Name: "shoegaze", Count: 100},
+				{Name: "dream pop", Count: 90},
+				{Name: "ambient", Count: 80},
+				{Name: "british", Count: 70},
+				{Name: "indie", Count: 60},
+				{Name: "90s", Count: 50},
+			},
+			expected: []lastfm.ArtistTag{
+				{Name: "shoegaze", Count: 100},
+				{Name: "dream pop", Count: 90},
+				{Name: "ambient", Count: 80},
+				{Name: "british", Count: 70},
+				{Name: "indie", Count: 60},
+			},
+		},
+		"no tags": {
+			artist:   "Slowdive",
+			expected: []lastfm.ArtistTag{},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if result := genreTags(tc.artist, tc.tags); !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestRankTags(t *testing.T) {
+	artists := []LastfmArtist{
+		{Name: "Slowdive", Playcount: "60"},
+		{Name: "Ride", Playcount: "30"},
+		{Name: "Radiohead", Playcount: "10"},
+	}
+	artistTags := map[string][]lastfm.ArtistTag{
+		"slowdive": {{Name: "shoegaze", Count: 50}, {Name: "dream pop", Count: 50}},
+		"ride":     {{Name: "shoegaze", Count: 100}},
+		// radiohead's tags couldn't be found, but their plays are still part of the
+		// listening shared between the tags
+	}
+
+	tags := rankTags(artists, artistTags)
+	expected := []tagShare{
+		{name: "shoegaze", plays: 60, share: 60},
+		{name: "dream pop", plays: 30, share: 30},
+	}
+	if len(tags) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, tags)
+	}
+	for i, tag := range tags {
+		if tag.name != expected[i].name ||
+			math.Abs(tag.plays-expected[i].plays) > 1e-9 ||
+			math.Abs(tag.share-expected[i].share) > 1e-9 {
+			t.Errorf("expected %v, got %v", expected[i], tag)
+		}
+	}
+
+	if tags := rankTags([]LastfmArtist{{Name: "Slowdive"}}, artistTags); tags[0].share != 0 {
+		t.Errorf("expected no share without plays, got %v", tags)
+	}
+}
+
+func TestPickTagAlbums(t *testing.T) {
+	album := func(artist, name string) LastfmAlbum {
+		a := LastfmAlbum{AlbumName: name}
+		a.Artist.ArtistName = artist
+		return a
+	}
+	albums := []LastfmAlbum{
+		album("Slowdive", "Souvlaki"),
+		album("Ride", "Nowhere"),
+		album("Radiohead", "OK Computer"),
+	}
+	artistTags := map[string][]lastfm.ArtistTag{
+		"slowdive":  {{Name: "shoegaze", Count: 50}, {Name: "dream pop", Count: 50}},
+		"ride":      {{Name: "shoegaze", Count: 100}},
+		"radiohead": {{Name: "alternative", Count: 100}},
+	}
+	tags := func(names ...string) []tagShare {
+		result := make([]tagShare, len(names))
+		for i, name := range names {
+			result[i] = tagShare{name: name}
+		}
+		return result
+	}
+
+	testCases := map[string]struct {
+		tags     []tagShare
+		expected []string
+	}{
+		"most played album": {
+			tags:     tags("shoegaze", "alternative"),
+			expected: []string{"Souvlaki", "OK Computer"},
+		},
+		"album not reused": {
+			tags:     tags("shoegaze", "shoegaze", "dream pop"),
+			expected: []string{"Souvlaki", "Nowhere", "Souvlaki"},
+		},
+		"album reused when it is the only one": {
+			tags:     tags("alternative", "alternative"),
+			expected: []string{"OK Computer", "OK Computer"},
+		},
+		"no album": {
+			tags:     tags("jazz", "shoegaze"),
+			expected: []string{"", "Souvlaki"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			picked := pickTagAlbums(tc.tags, albums, artistTags)
+			result := make([]string, len(picked))
+			for i, album := range picked {
+				if album != nil {
+					result[i] = album.AlbumName
+				}
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestGetArtistTags(t *testing.T) {
+	lookups := tagServer(t, map[string][]lastfm.ArtistTag{
+		"Slowdive": {{Name: "Shoegaze", Count: 100}, {Name: "seen live", Count: 90}},
+		"Ride":     {{Name: "Shoegaze", Count: 100}},
+	})
+	artists := []LastfmArtist{{Name: "Slowdive"}, {Name: "Ride"}, {Name: "Unknown Artist"}}
+
+	tags := getArtistTags(context.Background(), artists)
+	expected := map[string][]lastfm.ArtistTag{
+		"slowdive": {{Name: "shoegaze", Count: 100}},
+		"ride":     {{Name: "shoegaze", Count: 100}},
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %v, got %v", expected, tags)
+	}
+	if n := lookups.Load(); n != 3 {
+		t.Errorf("expected 3 lookups, got %d", n)
+	}
+}
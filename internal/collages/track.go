@@ -2,10 +2,7 @@ package collages
 
 import (
 	"context"
-	"encoding/json"
-	"io"
-	"strconv"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -30,119 +27,189 @@ type LastfmTrack struct {
 	Attr     struct {
 		Rank string `json:"rank"`
 	} `json:"@attr"`
-	Playcount string               `json:"playcount"`
-	Images    []lastfm.LastfmImage `json:"image"`
+	Playcount string                          `json:"playcount"`
+	Images    lastfm.List[lastfm.LastfmImage] `json:"image"`
+	// the album is only known for tracks from ListenBrainz, whose images are the
+	// album artwork rather than a placeholder
+	Album string `json:"-"`
 }
 
 type LastfmTopTracks struct {
 	TopTracks struct {
-		Attr   lastfm.LastfmUser `json:"@attr"`
-		Tracks []LastfmTrack     `json:"track"`
+		Attr   lastfm.LastfmUser        `json:"@attr"`
+		Tracks lastfm.List[LastfmTrack] `json:"track"`
 	} `json:"toptracks"`
 }
 
 func GetElementsForTrack(
 	ctx context.Context,
-	username string,
-	period lastfm.Period,
-	count int,
-	imageSize string,
+	options ElementOptions,
 	displayOptions DisplayOptions,
 	jobChan chan<- CollageElement,
 ) error {
 	config := config.GetConfig()
-	if count > config.MaxImages.Tracks {
+	if options.Count > config.MaxImages.Tracks {
 		return lastfm.ErrTooManyImages
 	}
-	return getTracks(ctx, username, period, count, imageSize, jobChan)
+	return getTracks(ctx, options, jobChan)
 }
 
 func getLastfmTracks(
 	ctx context.Context,
+	source ListeningSource,
 	username string,
 	period lastfm.Period,
 	count int,
+	filter *itemFilter,
 ) ([]LastfmTrack, error) {
 	tracks := []LastfmTrack{}
-	totalPages := 0
+	handler := func(page []LastfmTrack) (int, error) {
+		page = filterItems(ctx, filter, page, count-len(tracks), describeTrack)
+		tracks = append(tracks, page...)
+		return filter.fetched(len(tracks), count), nil
+	}
+	err := source.TopTracks(ctx, username, period, count, filteredPageSize(count, filter), handler)
+	if err != nil {
+		return nil, err
+	}
+	return tracks[:min(len(tracks), count)], nil
+}
 
-	handler := func(data io.Reader) (int, int, error) {
-		var lastfmTopTracks LastfmTopTracks
-		err := json.NewDecoder(data).Decode(&lastfmTopTracks)
-		if err != nil {
-			return 0, 0, err
-		}
-		tracks = append(tracks, lastfmTopTracks.TopTracks.Tracks...)
-		if totalPages == 0 {
-			total, err := strconv.Atoi(lastfmTopTracks.TopTracks.Attr.TotalPages)
-			if err != nil {
-				return 0, 0, err
-			}
-			totalPages = total
-		}
-		return len(tracks), totalPages, nil
+// getLastfmTracksForUsers fetches the top tracks of a single user, or merges the
+// top tracks of several users
+func getLastfmTracksForUsers(ctx context.Context, options ElementOptions) ([]LastfmTrack, error) {
+	filter := newItemFilter(options.Filter)
+	source := listeningSource(options.Source)
+	fetch := func(username string) ([]LastfmTrack, error) {
+		return getLastfmTracks(ctx, source, username, options.Period, options.Count, filter)
+	}
+	if len(options.Usernames) == 1 {
+		return fetch(options.Usernames[0])
 	}
-	err := lastfm.GetLastFmResponse(ctx, lastfm.MethodTrack, username, period, count, handler)
+	lists, err := fetchForUsers(ctx, options.Usernames, fetch)
 	if err != nil {
 		return nil, err
 	}
-	return tracks, nil
+	merged := mergeLists(lists, options.Count, func(track LastfmTrack) (string, string) {
+		return track.Mbid, trackKey(track)
+	}, func(track *LastfmTrack) *string {
+		return &track.Playcount
+	}, options.Aggregation)
+	return merged[:min(len(merged), options.Count)], nil
 }
+
 func getTracks(
 	ctx context.Context,
-	username string,
-	period lastfm.Period,
-	count int,
-	imageSize string,
+	options ElementOptions,
 	jobChan chan<- CollageElement,
 ) error {
-	tracks, err := getLastfmTracks(ctx, username, period, count)
-	if err != nil {
-		return err
-	}
-	cacheCount := 0
-	logger := zerolog.Ctx(ctx)
-
-	var wg sync.WaitGroup
-	wg.Add(len(tracks))
 	start := time.Now()
-	for i, track := range tracks {
-		go func(i int, lastfmTrack LastfmTrack) {
-			defer wg.Done()
-			track := parseLastfmTrack(ctx, lastfmTrack, imageSize, &cacheCount)
-			img, ext, err := DownloadImageWithRetry(ctx, track.ImageUrl)
-			if err != nil {
-				logger.Error().
-					Err(err).
-					Str("imageUrl", track.ImageUrl).
-					Msg("Error downloading image")
-			}
-			jobChan <- CollageElement{
-				Index:      i,
-				Parameters: track.Parameters(),
-				ImageBytes: img,
-				ImageExt:   ext,
-			}
-		}(i, track)
-
+	var cacheCount int64
+	if options.streamable() {
+		count, err := streamTracks(ctx, listeningSource(options.Source), options, jobChan)
+		if err != nil {
+			return err
+		}
+		cacheCount = count
+	} else {
+		tracks, err := getLastfmTracksForUsers(ctx, options)
+		if err != nil {
+			return err
+		}
+		tracks = fitItems(&options, tracks, func(track LastfmTrack) int {
+			return parsePlaycount(track.Playcount)
+		})
+		cacheCount = getTrackElements(ctx, tracks, options.ImageSize, jobChan)
 	}
-	wg.Wait()
-	logger.Info().
-		Int("cacheCount", cacheCount).
-		Str("username", username).
-		Int("totalCount", count).
+
+	zerolog.Ctx(ctx).Info().
+		Int64("cacheCount", cacheCount).
+		Strs("usernames", options.Usernames).
+		Int("totalCount", options.Count).
 		Dur("duration", time.Since(start)).
 		Str("method", "track").
 		Msg("Image URLs fetched")
-
 	return nil
 }
 
+// streamTracks resolves the artwork for the tracks of each page as it arrives,
+// so the collage is drawn while the later pages are still being fetched
+func streamTracks(
+	ctx context.Context,
+	source ListeningSource,
+	options ElementOptions,
+	jobChan chan<- CollageElement,
+) (int64, error) {
+	var cacheCount int64
+	resolve := trackResolver(ctx, options.ImageSize, jobChan, &cacheCount)
+	pool := newItemPool(options.Count, resolve)
+	filter := newItemFilter(options.Filter)
+	fetched := 0
+	handler := func(page []LastfmTrack) (int, error) {
+		page = filterItems(ctx, filter, page, options.Count-fetched, describeTrack)
+		page = page[:min(len(page), options.Count-fetched)]
+		pool.add(page)
+		fetched += len(page)
+		return filter.fetched(fetched, options.Count), nil
+	}
+	err := source.TopTracks(
+		ctx,
+		options.Usernames[0],
+		options.Period,
+		options.Count,
+		filteredPageSize(options.Count, filter),
+		handler,
+	)
+	pool.wait()
+	return atomic.LoadInt64(&cacheCount), err
+}
+
+// getTrackElements resolves the artwork for each track and sends it as an element
+// in the same order as the tracks, returning the number of image URL cache hits
+func getTrackElements(
+	ctx context.Context,
+	tracks []LastfmTrack,
+	imageSize string,
+	jobChan chan<- CollageElement,
+) int64 {
+	var cacheCount int64
+	forEachItem(tracks, trackResolver(ctx, imageSize, jobChan, &cacheCount))
+	return atomic.LoadInt64(&cacheCount)
+}
+
+// trackResolver returns a function resolving the artwork for the track at index i
+// and sending it as an element
+func trackResolver(
+	ctx context.Context,
+	imageSize string,
+	jobChan chan<- CollageElement,
+	cacheCount *int64,
+) func(i int, track LastfmTrack) {
+	logger := zerolog.Ctx(ctx)
+	return func(i int, lastfmTrack LastfmTrack) {
+		track := parseLastfmTrack(ctx, lastfmTrack, imageSize, cacheCount)
+
+		img, ext, err := DownloadImageWithRetry(ctx, track.ImageUrl)
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("imageUrl", track.ImageUrl).
+				Msg("Error downloading image")
+		}
+		jobChan <- CollageElement{
+			Index:      i,
+			Parameters: track.Parameters(),
+			ImageBytes: img,
+			ImageExt:   ext,
+		}
+	}
+}
+
 func parseLastfmTrack(
 	ctx context.Context,
 	track LastfmTrack,
 	imageSize string,
-	cacheCount *int,
+	cacheCount *int64,
 ) Track {
 	logger := zerolog.Ctx(ctx)
 	newTrack := Track{
@@ -157,10 +224,19 @@ func parseLastfmTrack(
 	if cacheEntry, ok := imageCache.Get(newTrack.Identifier()); ok {
 		newTrack.ImageUrl = cacheEntry.Url
 		newTrack.Album = cacheEntry.Album
-		(*cacheCount)++
+		atomic.AddInt64(cacheCount, 1)
 		return newTrack
 	}
 
+	if track.Album != "" {
+		if link := lastfm.ImageForSize(track.Images, imageSize); link != "" {
+			newTrack.ImageUrl = link
+			newTrack.Album = track.Album
+			imageCache.Set(newTrack.Identifier(), newTrack.CacheEntry())
+			return newTrack
+		}
+	}
+
 	trackInfo, err := getTrackInfo(ctx, newTrack.Name, newTrack.Artist, imageSize)
 	if err != nil {
 		logger.Error().
@@ -189,7 +265,12 @@ func getTrackInfo(
 		return trackInfo, nil
 	}
 	logger.Warn().Err(err).Msg("Error getting track info from lastfm")
-	trackInfo, err = getTrackInfoFromSpotify(ctx, trackName, artistName)
+	trackInfo, err = getTrackInfoFromSpotify(
+		ctx,
+		trackName,
+		artistName,
+		clients.ImageDimension(imageSize),
+	)
 	if err == nil {
 		return trackInfo, nil
 	}
@@ -216,12 +297,13 @@ func getTrackInfoFromSpotify(
 	ctx context.Context,
 	trackName string,
 	artistName string,
+	dimension int,
 ) (clients.TrackInfo, error) {
 	client, err := spotify.GetSpotifyClient()
 	if err != nil {
 		return clients.TrackInfo{}, err
 	}
-	result, err := client.GetTrackInfo(ctx, trackName, artistName)
+	result, err := client.GetTrackInfo(ctx, trackName, artistName, dimension)
 	if err != nil {
 		return clients.TrackInfo{}, err
 	}
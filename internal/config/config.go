@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -11,6 +12,16 @@ type Config struct {
 		Endpoint string
 		APIKey   string
 	}
+	// a Libre.fm or other GNU FM compatible server
+	Librefm struct {
+		Endpoint string
+		APIKey   string
+	}
+	ListenBrainz struct {
+		Endpoint string
+		// optional, authenticated requests have a higher rate limit
+		Token string
+	}
 	Fanart struct {
 		APIKey string
 	}
@@ -28,6 +39,32 @@ type Config struct {
 		Large      int
 		Medium     int
 	}
+	Concurrency struct {
+		// artwork lookups made at once for each collage
+		Workers int
+		// requests made at once to a host across all collages, by host
+		Hosts       map[string]int
+		DefaultHost int
+	}
+	Render struct {
+		// pixels a collage canvas may use, larger collages are drawn with smaller tiles
+		MaxPixels int
+		// canvases with more pixels are drawn in strips as they are encoded
+		StripPixels int
+		// largest width or height a collage can be requested at, raised along with
+		// MaxPixels for collages printed as posters
+		MaxDimension int
+	}
+	Jobs struct {
+		// collages generated at once from the job queue
+		Workers int
+		// jobs waiting to be generated before new jobs are refused
+		QueueSize int
+		// minutes a finished job and its result are kept
+		ResultTTL int
+		// megabytes of results kept before the oldest are forgotten early
+		ResultMemory int
+	}
 }
 
 var config *Config
@@ -50,6 +87,23 @@ func parseIntWithDefault(configField *int, name string, d int) error {
 	return nil
 }
 
+// parseHostLimits parses a list of host=limit pairs separated by commas into limits
+func parseHostLimits(limits map[string]int, name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	for pair := range strings.SplitSeq(v, ",") {
+		host, limit, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		value, err := strconv.Atoi(limit)
+		if !ok || host == "" || err != nil || value < 1 {
+			return fmt.Errorf("invalid '%s': %q", name, pair)
+		}
+		limits[host] = value
+	}
+	return nil
+}
+
 func Init() error {
 	c := Config{}
 
@@ -62,6 +116,22 @@ func Init() error {
 		return fmt.Errorf("'%s' is required", "LASTFM_API_KEY")
 	}
 
+	c.Librefm.Endpoint = os.Getenv("LIBREFM_ENDPOINT")
+	if c.Librefm.Endpoint == "" {
+		c.Librefm.Endpoint = "https://libre.fm/2.0/"
+	}
+	c.Librefm.APIKey = os.Getenv("LIBREFM_API_KEY")
+	if c.Librefm.APIKey == "" {
+		// GNU FM accepts any 32 character key for reading public data
+		c.Librefm.APIKey = strings.Repeat("0", 32)
+	}
+
+	c.ListenBrainz.Endpoint = os.Getenv("LISTENBRAINZ_ENDPOINT")
+	if c.ListenBrainz.Endpoint == "" {
+		c.ListenBrainz.Endpoint = "https://api.listenbrainz.org"
+	}
+	c.ListenBrainz.Token = os.Getenv("LISTENBRAINZ_TOKEN")
+
 	c.Fanart.APIKey = os.Getenv("FANART_API_KEY")
 	if c.Fanart.APIKey == "" {
 		return fmt.Errorf("'%s' is required", "FANART_API_KEY")
@@ -89,6 +159,57 @@ func Init() error {
 		return err
 	}
 
+	if err := parseIntWithDefault(&c.Concurrency.Workers, "ARTWORK_WORKERS", 16); err != nil {
+		return err
+	}
+	if err := parseIntWithDefault(&c.Concurrency.DefaultHost, "HOST_CONCURRENCY", 32); err != nil {
+		return err
+	}
+	// hosts known to rate limit bursts of requests
+	c.Concurrency.Hosts = map[string]int{
+		"api.spotify.com":      8,
+		"api.deezer.com":       8,
+		"webservice.fanart.tv": 8,
+		"en.wikipedia.org":     4,
+	}
+	if err := parseHostLimits(c.Concurrency.Hosts, "HOST_CONCURRENCY_LIMITS"); err != nil {
+		return err
+	}
+
+	if err := parseIntWithDefault(
+		&c.Render.MaxPixels,
+		"COLLAGE_MAX_PIXELS",
+		25_000_000,
+	); err != nil {
+		return err
+	}
+	if err := parseIntWithDefault(
+		&c.Render.StripPixels,
+		"COLLAGE_STRIP_PIXELS",
+		8_000_000,
+	); err != nil {
+		return err
+	}
+	if err := parseIntWithDefault(
+		&c.Render.MaxDimension,
+		"COLLAGE_MAX_DIMENSION",
+		3000,
+	); err != nil {
+		return err
+	}
+	if err := parseIntWithDefault(&c.Jobs.Workers, "JOB_WORKERS", 2); err != nil {
+		return err
+	}
+	if err := parseIntWithDefault(&c.Jobs.QueueSize, "JOB_QUEUE_SIZE", 32); err != nil {
+		return err
+	}
+	if err := parseIntWithDefault(&c.Jobs.ResultTTL, "JOB_RESULT_TTL", 15); err != nil {
+		return err
+	}
+	if err := parseIntWithDefault(&c.Jobs.ResultMemory, "JOB_RESULT_MEMORY", 256); err != nil {
+		return err
+	}
+
 	config = &c
 
 	return nil
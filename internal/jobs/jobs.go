@@ -0,0 +1,235 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrQueueFull = errors.New("job queue is full")
+	ErrPanicked  = errors.New("job panicked")
+)
+
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Result is the encoded output of a job
+type Result struct {
+	ContentType string
+	Data        []byte
+}
+
+// Work generates the result of a job
+type Work func(ctx context.Context) (Result, error)
+
+// Job is a snapshot of a queued job
+type Job struct {
+	ID       string    `json:"id"`
+	Status   Status    `json:"status"`
+	Created  time.Time `json:"created"`
+	Finished time.Time `json:"finished,omitzero"`
+	// Error is why a failed job failed
+	Error  string `json:"error,omitempty"`
+	Result Result `json:"-"`
+	Err    error  `json:"-"`
+}
+
+type job struct {
+	Job
+	ctx  context.Context
+	work Work
+}
+
+// Queue runs jobs on a fixed number of workers, keeping each result until it
+// expires. Jobs are refused once the queue is full rather than piling up, and the
+// oldest finished jobs are forgotten early once their results take up more than
+// maxBytes.
+type Queue struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	pending chan *job
+	ttl     time.Duration
+	// finished jobs that are kept, oldest first, and the size of their results
+	finished []*job
+	retained int
+	maxBytes int
+	// closed and replaced whenever a job changes
+	changed chan struct{}
+}
+
+func NewQueue(workers int, size int, ttl time.Duration, maxBytes int) *Queue {
+	q := &Queue{
+		jobs:     map[string]*job{},
+		pending:  make(chan *job, max(size, 0)),
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		changed:  make(chan struct{}),
+	}
+	for range max(workers, 1) {
+		go q.run()
+	}
+	return q
+}
+
+// Submit queues the work, returning the job ID. The context is kept for its
+// values, such as the logger, and isn't cancelled with the request submitting it.
+func (q *Queue) Submit(ctx context.Context, work Work) (string, error) {
+	j := &job{
+		Job: Job{
+			ID:      xid.New().String(),
+			Status:  StatusQueued,
+			Created: time.Now(),
+		},
+		ctx:  context.WithoutCancel(ctx),
+		work: work,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.pending <- j:
+	default:
+		return "", ErrQueueFull
+	}
+	q.jobs[j.ID] = j
+	q.notify()
+	return j.ID, nil
+}
+
+// Get returns the job with the ID, if it exists and hasn't expired
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return j.Job, true
+}
+
+// Wait returns the job once it has finished, or as it is when the context is done.
+// It returns false if the job doesn't exist or expires while waiting.
+func (q *Queue) Wait(ctx context.Context, id string) (Job, bool) {
+	for {
+		q.mu.Lock()
+		j, ok := q.jobs[id]
+		changed := q.changed
+		var job Job
+		if ok {
+			job = j.Job
+		}
+		q.mu.Unlock()
+		if !ok || job.Status == StatusDone || job.Status == StatusFailed {
+			return job, ok
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return job, ok
+		}
+	}
+}
+
+func (q *Queue) run() {
+	for j := range q.pending {
+		q.setStatus(j, StatusRunning)
+		result, err := j.do()
+		q.finish(j, result, err)
+		time.AfterFunc(q.ttl, func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.forget(j)
+		})
+	}
+}
+
+// do runs the work of the job, failing it if the work panics rather than taking
+// down the worker and leaving the job running forever
+func (j *job) do() (result Result, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			zerolog.Ctx(j.ctx).Error().
+				Interface("panic", p).
+				Bytes("stack", debug.Stack()).
+				Str("job", j.ID).
+				Msg("Job panicked")
+			err = fmt.Errorf("%w: %v", ErrPanicked, p)
+		}
+	}()
+	return j.work(j.ctx)
+}
+
+func (q *Queue) setStatus(j *job, status Status) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j.Status = status
+	q.notify()
+}
+
+// finish keeps the result of the job, forgetting the oldest finished jobs if the
+// results kept are now too large. The job itself is always kept, even if its
+// result alone is over the limit, so it can be fetched at least once.
+func (q *Queue) finish(j *job, result Result, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j.Status = StatusDone
+	j.Result = result
+	if err != nil {
+		j.Status = StatusFailed
+		j.Err = err
+		j.Error = err.Error()
+	}
+	j.Finished = time.Now()
+	j.ctx, j.work = nil, nil
+
+	q.finished = append(q.finished, j)
+	q.retained += len(result.Data)
+	for q.retained > q.maxBytes && q.finished[0] != j {
+		q.forget(q.finished[0])
+	}
+	q.notify()
+}
+
+// forget removes the finished job and its result, if it hasn't been already
+func (q *Queue) forget(j *job) {
+	i := slices.Index(q.finished, j)
+	if i < 0 {
+		return
+	}
+	q.finished = slices.Delete(q.finished, i, i+1)
+	q.retained -= len(j.Result.Data)
+	delete(q.jobs, j.ID)
+	q.notify()
+}
+
+// notify wakes anything waiting for a job to change, and must be called with the
+// lock held
+func (q *Queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+var queue *Queue
+
+// Init creates the job queue used by GetQueue
+func Init(workers int, size int, ttl time.Duration, maxBytes int) {
+	queue = NewQueue(workers, size, ttl, maxBytes)
+}
+
+func GetQueue() *Queue {
+	return queue
+}
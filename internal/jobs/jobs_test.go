@@ -0,0 +1,172 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitFor waits for the job to change until done returns true for it, or until
+// the job is gone if done is given false for ok
+func waitFor(t *testing.T, q *Queue, id string, done func(job Job, ok bool) bool) Job {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		q.mu.Lock()
+		job, ok := q.jobs[id]
+		var snapshot Job
+		if ok {
+			snapshot = job.Job
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		if done(snapshot, ok) {
+			return snapshot
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("job %s never changed as expected, last seen %+v", id, snapshot)
+		}
+	}
+}
+
+// waitForStatus waits until the job has the status
+func waitForStatus(t *testing.T, q *Queue, id string, status Status) Job {
+	t.Helper()
+	return waitFor(t, q, id, func(job Job, ok bool) bool {
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		return job.Status == status
+	})
+}
+
+// waitForExpiry waits until the job is forgotten
+func waitForExpiry(t *testing.T, q *Queue, id string) {
+	t.Helper()
+	waitFor(t, q, id, func(_ Job, ok bool) bool { return !ok })
+}
+
+func result(data string) Work {
+	return func(ctx context.Context) (Result, error) {
+		return Result{ContentType: "image/jpeg", Data: []byte(data)}, nil
+	}
+}
+
+func TestQueue(t *testing.T) {
+	q := NewQueue(1, 1, 20*time.Millisecond, 1024)
+	release := make(chan struct{})
+	errFailed := errors.New("failed")
+
+	running, err := q.Submit(context.Background(), func(ctx context.Context) (Result, error) {
+		<-release
+		return result("collage")(ctx)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitForStatus(t, q, running, StatusRunning)
+
+	queued, err := q.Submit(context.Background(), func(ctx context.Context) (Result, error) {
+		return Result{}, errFailed
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := q.Submit(context.Background(), nil); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	job, ok := q.Wait(ctx, running)
+	if !ok || job.Status != StatusDone {
+		t.Fatalf("expected job %s to be done, got %+v", running, job)
+	}
+	if string(job.Result.Data) != "collage" || job.Finished.IsZero() || job.Error != "" {
+		t.Errorf("unexpected finished job %+v", job)
+	}
+	job = waitForStatus(t, q, queued, StatusFailed)
+	if job.Err != errFailed || job.Error != errFailed.Error() {
+		t.Errorf("expected error %v, got %v (%q)", errFailed, job.Err, job.Error)
+	}
+
+	waitForExpiry(t, q, running)
+	waitForExpiry(t, q, queued)
+	if q.retained != 0 || len(q.finished) != 0 {
+		t.Errorf("expected no results to be kept, got %d bytes", q.retained)
+	}
+}
+
+func TestQueueResultLimit(t *testing.T) {
+	q := NewQueue(1, 4, time.Minute, 10)
+
+	first, _ := q.Submit(context.Background(), result("1234"))
+	second, _ := q.Submit(context.Background(), result("5678"))
+	waitForStatus(t, q, second, StatusDone)
+	if _, ok := q.Get(first); !ok {
+		t.Fatalf("expected job %s to be kept while under the limit", first)
+	}
+
+	// the oldest result is forgotten to make room
+	third, _ := q.Submit(context.Background(), result("abcd"))
+	waitForStatus(t, q, third, StatusDone)
+	if _, ok := q.Get(first); ok {
+		t.Errorf("expected job %s to be forgotten", first)
+	}
+	if _, ok := q.Get(second); !ok {
+		t.Errorf("expected job %s to be kept", second)
+	}
+
+	// a result over the limit on its own is still kept, but alone
+	large, _ := q.Submit(context.Background(), result("a result larger than the limit"))
+	waitForStatus(t, q, large, StatusDone)
+	for _, id := range []string{second, third} {
+		if _, ok := q.Get(id); ok {
+			t.Errorf("expected job %s to be forgotten", id)
+		}
+	}
+	if q.retained != len("a result larger than the limit") {
+		t.Errorf("unexpected retained bytes %d", q.retained)
+	}
+}
+
+func TestQueueContext(t *testing.T) {
+	type key struct{}
+	q := NewQueue(1, 1, time.Minute, 1024)
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+
+	id, err := q.Submit(ctx, func(ctx context.Context) (Result, error) {
+		if ctx.Value(key{}) != "value" {
+			return Result{}, errors.New("missing context value")
+		}
+		return Result{}, ctx.Err()
+	})
+	cancel()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the job outlives the request that submitted it
+	if job := waitForStatus(t, q, id, StatusDone); job.Err != nil {
+		t.Errorf("unexpected error: %v", job.Err)
+	}
+}
+
+func TestQueuePanic(t *testing.T) {
+	q := NewQueue(1, 2, time.Minute, 1024)
+
+	panicked, _ := q.Submit(context.Background(), func(ctx context.Context) (Result, error) {
+		panic("collage")
+	})
+	job := waitForStatus(t, q, panicked, StatusFailed)
+	if !errors.Is(job.Err, ErrPanicked) || job.Finished.IsZero() {
+		t.Errorf("expected the job to fail with ErrPanicked, got %+v", job)
+	}
+
+	// the worker keeps running jobs after a panic
+	next, _ := q.Submit(context.Background(), result("collage"))
+	waitForStatus(t, q, next, StatusDone)
+}
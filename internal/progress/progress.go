@@ -0,0 +1,176 @@
+package progress
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// trackers are kept for long enough for a client to follow a slow collage, and
+	// then removed whether or not the request finished
+	retention = 5 * time.Minute
+	// requests beyond this many tracked at once aren't tracked, as the IDs are
+	// chosen by clients
+	maxTrackers = 1000
+)
+
+// Progress counts the steps of generating a collage
+type Progress struct {
+	// pages of the top lists fetched from the listening service
+	Pages int `json:"pages"`
+	// tiles in the collage
+	Total int `json:"total"`
+	// tiles with their artwork resolved and downloaded
+	Resolved int `json:"resolved"`
+	// tiles drawn onto the collage
+	Rendered int  `json:"rendered"`
+	Done     bool `json:"done"`
+}
+
+// Tracker records the progress of a single request. The methods do nothing on a
+// nil Tracker, so progress can be reported whether or not it is being followed.
+type Tracker struct {
+	mu       sync.Mutex
+	progress Progress
+	changed  chan struct{}
+	expired  chan struct{}
+}
+
+var trackers = struct {
+	sync.Mutex
+	m map[xid.ID]*Tracker
+	// closed when a tracker is created, for clients waiting on a request
+	created chan struct{}
+}{m: map[xid.ID]*Tracker{}, created: make(chan struct{})}
+
+// track creates the tracker for the request ID, returning false if the ID is
+// already being tracked by another request or too many requests are tracked
+func track(id xid.ID) (*Tracker, bool) {
+	trackers.Lock()
+	defer trackers.Unlock()
+	if _, ok := trackers.m[id]; ok || len(trackers.m) >= maxTrackers {
+		return nil, false
+	}
+	t := &Tracker{changed: make(chan struct{}), expired: make(chan struct{})}
+	trackers.m[id] = t
+	close(trackers.created)
+	trackers.created = make(chan struct{})
+	time.AfterFunc(retention, func() {
+		trackers.Lock()
+		delete(trackers.m, id)
+		trackers.Unlock()
+		close(t.expired)
+	})
+	return t, true
+}
+
+// Find returns the tracker for the request ID. The client following a request
+// usually arrives before it, so Find waits up to wait for the request to arrive.
+func Find(ctx context.Context, id xid.ID, wait time.Duration) (*Tracker, bool) {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		trackers.Lock()
+		t, ok := trackers.m[id]
+		created := trackers.created
+		trackers.Unlock()
+		if ok {
+			return t, true
+		}
+
+		select {
+		case <-created:
+		case <-timeout.C:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tracker of the request, or nil if it isn't tracked
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(contextKey{}).(*Tracker)
+	return t
+}
+
+// Handler tracks the progress of requests with a request ID in the param query
+// parameter. The ID must be an xid, and is chosen by the client, so it is logged
+// alongside the request ID of the server rather than replacing it. A request with
+// the ID of a request already tracked isn't tracked, so requests never share a
+// tracker, and neither is a request once too many are tracked.
+func Handler(param string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := xid.FromString(r.URL.Query().Get(param))
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			t, ok := track(id)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			logger := zerolog.Ctx(r.Context()).With().Str(param, id.String()).Logger()
+			ctx := NewContext(logger.WithContext(r.Context()), t)
+			defer t.finish()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Snapshot returns the current progress, and a channel closed when it next changes
+func (t *Tracker) Snapshot() (Progress, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.progress, t.changed
+}
+
+// Expired returns a channel closed once the tracker is no longer kept, whether or
+// not the request finished
+func (t *Tracker) Expired() <-chan struct{} {
+	return t.expired
+}
+
+func (t *Tracker) update(f func(p *Progress)) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f(&t.progress)
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+func (t *Tracker) PageFetched() {
+	t.update(func(p *Progress) { p.Pages++ })
+}
+
+func (t *Tracker) SetTotal(total int) {
+	t.update(func(p *Progress) { p.Total = total })
+}
+
+func (t *Tracker) TileResolved() {
+	t.update(func(p *Progress) { p.Resolved++ })
+}
+
+func (t *Tracker) TileRendered() {
+	t.update(func(p *Progress) { p.Rendered++ })
+}
+
+func (t *Tracker) finish() {
+	t.update(func(p *Progress) { p.Done = true })
+}
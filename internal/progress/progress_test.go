@@ -0,0 +1,109 @@
+package progress
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/rs/zerolog/hlog"
+)
+
+func TestHandler(t *testing.T) {
+	id := xid.New()
+	handler := Handler("requestid")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the client's ID doesn't replace the request ID in the logs
+		if requestID, ok := hlog.IDFromRequest(r); ok && requestID == id {
+			t.Errorf("expected the request id not to be %s", id)
+		}
+		tracker := FromContext(r.Context())
+		tracker.SetTotal(2)
+		tracker.PageFetched()
+		tracker.TileResolved()
+		tracker.TileRendered()
+	}))
+
+	// the client following the request arrives first
+	found := make(chan *Tracker)
+	go func() {
+		tracker, _ := Find(context.Background(), id, time.Second)
+		found <- tracker
+	}()
+	request := httptest.NewRequest(http.MethodGet, "/collage?requestid="+id.String(), nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	tracker := <-found
+	if tracker == nil {
+		t.Fatal("expected the tracker to be found")
+	}
+	expected := Progress{Pages: 1, Total: 2, Resolved: 1, Rendered: 1, Done: true}
+	if p, _ := tracker.Snapshot(); p != expected {
+		t.Errorf("expected %+v, got %+v", expected, p)
+	}
+}
+
+func TestHandlerWithoutID(t *testing.T) {
+	handler := Handler("requestid")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracker := FromContext(r.Context()); tracker != nil {
+			t.Errorf("expected no tracker, got %+v", tracker)
+		}
+		// reporting progress without a tracker does nothing
+		FromContext(r.Context()).TileRendered()
+	}))
+	for _, target := range []string{"/collage", "/collage?requestid=invalid"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+}
+
+func TestHandlerRepeatedID(t *testing.T) {
+	id := xid.New()
+	if _, ok := track(id); !ok {
+		t.Fatal("expected the id to be tracked")
+	}
+	handler := Handler("requestid")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracker := FromContext(r.Context()); tracker != nil {
+			t.Error("expected the request not to share the tracker")
+		}
+	}))
+	request := httptest.NewRequest(http.MethodGet, "/collage?requestid="+id.String(), nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+}
+
+func TestFindNotFound(t *testing.T) {
+	if _, ok := Find(context.Background(), xid.New(), 10*time.Millisecond); ok {
+		t.Error("expected no tracker for an unknown id")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := Find(ctx, xid.New(), time.Minute); ok {
+		t.Error("expected no tracker once the context is cancelled")
+	}
+}
+
+func TestTrackLimit(t *testing.T) {
+	ids := []xid.ID{}
+	t.Cleanup(func() {
+		trackers.Lock()
+		defer trackers.Unlock()
+		for _, id := range ids {
+			delete(trackers.m, id)
+		}
+	})
+
+	trackers.Lock()
+	tracked := len(trackers.m)
+	trackers.Unlock()
+	for range maxTrackers - tracked {
+		id := xid.New()
+		if _, ok := track(id); !ok {
+			t.Fatalf("expected %s to be tracked", id)
+		}
+		ids = append(ids, id)
+	}
+	if _, ok := track(xid.New()); ok {
+		t.Errorf("expected no more than %d trackers", maxTrackers)
+	}
+}
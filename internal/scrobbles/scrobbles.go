@@ -0,0 +1,296 @@
+package scrobbles
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnrecognisedFormat = errors.New("unrecognised scrobble export format")
+var ErrNoScrobbles = errors.New("no scrobbles found in export")
+
+type Format string
+
+const (
+	FormatLastfmCSV    Format = "lastfm-csv"
+	FormatSpotify      Format = "spotify"
+	FormatListenBrainz Format = "listenbrainz"
+)
+
+// Spotify only counts a stream as a play after 30 seconds, the same as a scrobble
+const minSpotifyPlay = 30 * time.Second
+
+// Scrobble is a single listen from an export, the time is zero when unknown
+type Scrobble struct {
+	Artist     string
+	ArtistMbid string
+	Album      string
+	AlbumMbid  string
+	Track      string
+	TrackMbid  string
+	Time       time.Time
+}
+
+// Parse reads a Last.fm CSV, Spotify extended streaming history JSON, or
+// ListenBrainz JSON export, detecting the format from its contents
+func Parse(r io.Reader) ([]Scrobble, Format, error) {
+	reader := bufio.NewReader(r)
+	first, err := firstNonSpace(reader)
+	if err != nil {
+		return nil, "", ErrNoScrobbles
+	}
+
+	var scrobbles []Scrobble
+	var format Format
+	if first == '[' || first == '{' {
+		scrobbles, format, err = parseJSON(reader)
+	} else {
+		scrobbles, err = parseCSV(reader)
+		format = FormatLastfmCSV
+	}
+	if err != nil {
+		return nil, format, err
+	}
+	if len(scrobbles) == 0 {
+		return nil, format, ErrNoScrobbles
+	}
+	return scrobbles, format, nil
+}
+
+// InRange returns the scrobbles from within [from, to). A zero from or to leaves
+// that end of the range open, and scrobbles without a time are only kept when the
+// range is fully open.
+func InRange(scrobbles []Scrobble, from, to time.Time) []Scrobble {
+	if from.IsZero() && to.IsZero() {
+		return scrobbles
+	}
+	result := []Scrobble{}
+	for _, s := range scrobbles {
+		if s.Time.IsZero() || s.Time.Before(from) || (!to.IsZero() && !s.Time.Before(to)) {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+func firstNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		// skip whitespace and a UTF-8 byte order mark
+		if strings.IndexByte(" \t\r\n\xef\xbb\xbf", b) == -1 {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+type exportEntry struct {
+	// Spotify extended streaming history
+	Timestamp  string `json:"ts"`
+	MsPlayed   int64  `json:"ms_played"`
+	TrackName  string `json:"master_metadata_track_name"`
+	ArtistName string `json:"master_metadata_album_artist_name"`
+	AlbumName  string `json:"master_metadata_album_album_name"`
+
+	// ListenBrainz
+	ListenedAt    int64 `json:"listened_at"`
+	TrackMetadata *struct {
+		ArtistName  string `json:"artist_name"`
+		TrackName   string `json:"track_name"`
+		ReleaseName string `json:"release_name"`
+		MbidMapping struct {
+			RecordingMbid string   `json:"recording_mbid"`
+			ReleaseMbid   string   `json:"release_mbid"`
+			ArtistMbids   []string `json:"artist_mbids"`
+		} `json:"mbid_mapping"`
+	} `json:"track_metadata"`
+}
+
+// parseJSON reads either a JSON array of entries, or entries one after another as
+// in the JSON lines ListenBrainz export
+func parseJSON(reader *bufio.Reader) ([]Scrobble, Format, error) {
+	first, err := firstNonSpace(reader)
+	if err != nil {
+		return nil, "", err
+	}
+	decoder := json.NewDecoder(reader)
+	if first == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	scrobbles := []Scrobble{}
+	var format Format
+	for decoder.More() {
+		var entry exportEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, format, fmt.Errorf("invalid export entry: %w", err)
+		}
+
+		switch {
+		case entry.TrackMetadata != nil:
+			format = FormatListenBrainz
+			metadata := entry.TrackMetadata
+			if metadata.TrackName == "" || metadata.ArtistName == "" {
+				continue
+			}
+			s := Scrobble{
+				Artist:    metadata.ArtistName,
+				Album:     metadata.ReleaseName,
+				AlbumMbid: metadata.MbidMapping.ReleaseMbid,
+				Track:     metadata.TrackName,
+				TrackMbid: metadata.MbidMapping.RecordingMbid,
+			}
+			if len(metadata.MbidMapping.ArtistMbids) == 1 {
+				s.ArtistMbid = metadata.MbidMapping.ArtistMbids[0]
+			}
+			if entry.ListenedAt > 0 {
+				s.Time = time.Unix(entry.ListenedAt, 0).UTC()
+			}
+			scrobbles = append(scrobbles, s)
+		case entry.Timestamp != "":
+			format = FormatSpotify
+			// podcast episodes and skipped tracks have no track name or short plays
+			if entry.TrackName == "" || entry.ArtistName == "" ||
+				time.Duration(entry.MsPlayed)*time.Millisecond < minSpotifyPlay {
+				continue
+			}
+			s := Scrobble{
+				Artist: entry.ArtistName,
+				Album:  entry.AlbumName,
+				Track:  entry.TrackName,
+			}
+			if t, err := time.Parse(time.RFC3339, entry.Timestamp); err == nil {
+				s.Time = t
+			}
+			scrobbles = append(scrobbles, s)
+		default:
+			return nil, format, ErrUnrecognisedFormat
+		}
+	}
+	return scrobbles, format, nil
+}
+
+// column names used by the various Last.fm export tools
+var csvColumns = map[string][]string{
+	"artist":      {"artist", "artist_name", "artist name"},
+	"artist_mbid": {"artist_mbid", "artist mbid"},
+	"album":       {"album", "album_name", "album name", "release_name"},
+	"album_mbid":  {"album_mbid", "album mbid"},
+	"track":       {"track", "track_name", "track name", "title", "name"},
+	"track_mbid":  {"track_mbid", "track mbid"},
+	"date":        {"date", "uts", "utc_time", "timestamp", "time", "played_at"},
+}
+
+// parseCSV reads a Last.fm export, either with a header row naming the columns or
+// in the headerless artist, album, track, date order
+func parseCSV(reader io.Reader) ([]Scrobble, error) {
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, ErrNoScrobbles
+	}
+
+	columns := map[string]int{"artist": 0, "album": 1, "track": 2, "date": 3}
+	if header := csvHeader(records[0]); header != nil {
+		columns = header
+		records = records[1:]
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	scrobbles := make([]Scrobble, 0, len(records))
+	for _, record := range records {
+		s := Scrobble{
+			Artist:     field(record, "artist"),
+			ArtistMbid: field(record, "artist_mbid"),
+			Album:      field(record, "album"),
+			AlbumMbid:  field(record, "album_mbid"),
+			Track:      field(record, "track"),
+			TrackMbid:  field(record, "track_mbid"),
+			Time:       parseTime(field(record, "date")),
+		}
+		if s.Artist == "" || s.Track == "" {
+			continue
+		}
+		scrobbles = append(scrobbles, s)
+	}
+	return scrobbles, nil
+}
+
+// csvHeader returns the column indexes if the record is a header row
+func csvHeader(record []string) map[string]int {
+	columns := map[string]int{}
+	for i, value := range record {
+		value = strings.ToLower(strings.TrimSpace(value))
+		for name, aliases := range csvColumns {
+			if _, ok := columns[name]; ok {
+				continue
+			}
+			for _, alias := range aliases {
+				if value == alias {
+					columns[name] = i
+				}
+			}
+		}
+	}
+	_, hasArtist := columns["artist"]
+	_, hasTrack := columns["track"]
+	if !hasArtist || !hasTrack {
+		return nil
+	}
+	return columns
+}
+
+var timeLayouts = []string{
+	"02 Jan 2006 15:04",
+	"2 Jan 2006 15:04",
+	"02 Jan 2006, 15:04",
+	"2 Jan 2006, 15:04",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseTime(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if uts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// some exports use milliseconds
+		if uts > 1e11 {
+			return time.UnixMilli(uts).UTC()
+		}
+		return time.Unix(uts, 0).UTC()
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,110 @@
+package scrobbles
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	played := time.Date(2023, 5, 1, 12, 30, 0, 0, time.UTC)
+	radiohead := Scrobble{Artist: "Radiohead", Album: "Kid A", Track: "Idioteque", Time: played}
+
+	testCases := map[string]struct {
+		input    string
+		format   Format
+		expected []Scrobble
+		err      error
+	}{
+		"lastfm csv without header": {
+			input:    "Radiohead,Kid A,Idioteque,01 May 2023 12:30\n",
+			format:   FormatLastfmCSV,
+			expected: []Scrobble{radiohead},
+		},
+		"lastfm csv with header": {
+			input: "\ufeffuts,utc_time,artist,artist_mbid,album,album_mbid,track,track_mbid\n" +
+				"1682944200,\"01 May 2023, 12:30\",Radiohead,,Kid A,,Idioteque,\n" +
+				"1682944200,,,,,,Missing Artist,\n",
+			format:   FormatLastfmCSV,
+			expected: []Scrobble{radiohead},
+		},
+		"spotify": {
+			input: `[
+				{"ts": "2023-05-01T12:30:00Z", "ms_played": 250000,
+					"master_metadata_track_name": "Idioteque",
+					"master_metadata_album_artist_name": "Radiohead",
+					"master_metadata_album_album_name": "Kid A"},
+				{"ts": "2023-05-01T12:35:00Z", "ms_played": 5000,
+					"master_metadata_track_name": "Skipped",
+					"master_metadata_album_artist_name": "Radiohead",
+					"master_metadata_album_album_name": "Kid A"},
+				{"ts": "2023-05-01T12:40:00Z", "ms_played": 900000,
+					"master_metadata_track_name": null,
+					"episode_name": "A Podcast"}
+			]`,
+			format:   FormatSpotify,
+			expected: []Scrobble{radiohead},
+		},
+		"listenbrainz json lines": {
+			input: `{"listened_at": 1682944200, "track_metadata": {"artist_name": "Radiohead",` +
+				` "track_name": "Idioteque", "release_name": "Kid A",` +
+				` "mbid_mapping": {"release_mbid": "release", "artist_mbids": ["artist"]}}}` + "\n",
+			format: FormatListenBrainz,
+			expected: []Scrobble{{
+				Artist:     "Radiohead",
+				ArtistMbid: "artist",
+				Album:      "Kid A",
+				AlbumMbid:  "release",
+				Track:      "Idioteque",
+				Time:       played,
+			}},
+		},
+		"empty": {
+			input: " \n",
+			err:   ErrNoScrobbles,
+		},
+		"unrecognised json": {
+			input: `[{"name": "Idioteque"}]`,
+			err:   ErrUnrecognisedFormat,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, format, err := Parse(strings.NewReader(tc.input))
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected error %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if format != tc.format {
+				t.Errorf("expected format %s, got %s", tc.format, format)
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestInRange(t *testing.T) {
+	day := func(d int) Scrobble {
+		return Scrobble{Track: "Track", Time: time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC)}
+	}
+	history := []Scrobble{day(1), day(2), day(3), {Track: "Undated"}}
+
+	from := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC)
+	if result := InRange(history, from, to); !reflect.DeepEqual(result, []Scrobble{day(2)}) {
+		t.Errorf("expected only the second day, got %+v", result)
+	}
+	if result := InRange(history, time.Time{}, time.Time{}); len(result) != len(history) {
+		t.Errorf("expected all scrobbles for an open range, got %+v", result)
+	}
+}
@@ -11,8 +11,11 @@ import (
 	"github.com/rs/zerolog/hlog"
 
 	"github.com/SongStitch/song-stitch/internal/api"
+	"github.com/SongStitch/song-stitch/internal/clients/limiter"
 	"github.com/SongStitch/song-stitch/internal/clients/spotify"
 	"github.com/SongStitch/song-stitch/internal/config"
+	"github.com/SongStitch/song-stitch/internal/jobs"
+	"github.com/SongStitch/song-stitch/internal/progress"
 )
 
 func getLogger() zerolog.Logger {
@@ -74,11 +77,30 @@ func RunServer() {
 	c = c.Append(hlog.UserAgentHandler("user_agent"))
 	c = c.Append(hlog.RefererHandler("referer"))
 	c = c.Append(hlog.RequestIDHandler("req_id", "Request-Id"))
+	// a client following the progress of a collage chooses the ID it is tracked by
 	h := c.
+		Append(progress.Handler("requestid")).
 		ThenFunc(api.Collage)
+	mosaic := c.ThenFunc(api.Mosaic)
+	poster := c.ThenFunc(api.Poster)
+	compare := c.ThenFunc(api.Compare)
+	importCollage := c.ThenFunc(api.ImportCollage)
+	collageProgress := c.ThenFunc(api.CollageProgress)
+	submitJob := c.ThenFunc(api.SubmitJob)
+	jobStatus := c.ThenFunc(api.JobStatus)
+	jobResult := c.ThenFunc(api.JobResult)
 
 	router := http.NewServeMux()
 	router.Handle("GET /collage", h)
+	router.Handle("POST /collage", importCollage)
+	router.Handle("GET /collage/progress", collageProgress)
+	router.Handle("POST /jobs", submitJob)
+	router.Handle("GET /jobs/{id}", jobStatus)
+	router.Handle("GET /jobs/{id}/result", jobResult)
+	router.Handle("GET /mosaic", mosaic)
+	router.Handle("POST /mosaic", mosaic)
+	router.Handle("GET /poster", poster)
+	router.Handle("GET /compare", compare)
 
 	// serve files from public folder
 	fs := http.FileServer(http.Dir("./public"))
@@ -106,7 +128,16 @@ func RunServer() {
 	}
 
 	http.DefaultClient.Timeout = 60 * time.Second
+	// artwork downloads and Spotify requests share the per-host limits
+	http.DefaultClient.Transport = limiter.Transport
 	spotify.InitSpotifyClient(context.Background())
+	jobsConfig := config.GetConfig().Jobs
+	jobs.Init(
+		jobsConfig.Workers,
+		jobsConfig.QueueSize,
+		time.Duration(jobsConfig.ResultTTL)*time.Minute,
+		jobsConfig.ResultMemory<<20,
+	)
 
 	log.Info().Msg("Starting server...")
 	if err := server.ListenAndServe(); err != nil {
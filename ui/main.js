@@ -6,6 +6,7 @@ import {
 } from "./shared.js";
 
 const STORAGE_KEY = "songstitchform";
+const XID_ALPHABET = "0123456789abcdefghijklmnopqrstuv";
 const USERNAME_REGEX = /^[a-zA-Z][a-zA-Z0-9_-]{0,15}$/;
 
 const DEFAULT_VALUES = {
@@ -244,6 +245,8 @@ app.innerHTML = `
 
       <div class="loader-container">
         <div class="loader" id="form-loader" hidden></div>
+        <progress class="collage-progress" id="form-progress" max="1" value="0" hidden></progress>
+        <p class="progress-text" id="form-progress-text" hidden></p>
       </div>
 
       <div class="form-actions">
@@ -297,6 +300,8 @@ const rowsInput = document.getElementById("rows");
 const columnsInput = document.getElementById("columns");
 const submitButton = document.getElementById("submit-button");
 const loader = document.getElementById("form-loader");
+const progressBar = document.getElementById("form-progress");
+const progressText = document.getElementById("form-progress-text");
 const gridPreview = document.getElementById("grid-preview");
 const advancedContent = document.getElementById("advanced-options-content");
 
@@ -314,6 +319,7 @@ const copyButton = document.getElementById("copy-button");
 
 const state = {
   isSubmitting: false,
+  progressSource: null,
   submitted: false,
   touched: {
     username: false,
@@ -665,6 +671,75 @@ function generateUrl(values) {
   return `/collage?${params.toString()}`;
 }
 
+// newRequestId returns an xid, the request ID format used by the server
+function newRequestId() {
+  const bytes = new Uint8Array(12);
+  new DataView(bytes.buffer).setUint32(0, Math.floor(Date.now() / 1000));
+  crypto.getRandomValues(bytes.subarray(4));
+
+  let id = "";
+  let buffer = 0;
+  let bits = 0;
+  for (const byte of bytes) {
+    buffer = (buffer << 8) | byte;
+    bits += 8;
+    while (bits >= 5) {
+      bits -= 5;
+      id += XID_ALPHABET[(buffer >> bits) & 31];
+    }
+    buffer &= (1 << bits) - 1;
+  }
+  return id + XID_ALPHABET[(buffer << (5 - bits)) & 31];
+}
+
+function renderProgress(progress) {
+  progressBar.hidden = false;
+  progressText.hidden = false;
+  if (progress.total === 0) {
+    progressBar.removeAttribute("value");
+    progressText.textContent = `Fetched ${progress.pages} page(s) of listening data`;
+    return;
+  }
+  // resolving the artwork is the slow part, drawing a tile is quick once it has
+  progressBar.value =
+    (progress.resolved * 0.9 + progress.rendered * 0.1) / progress.total;
+  progressText.textContent =
+    `Fetched ${progress.pages} page(s), ${progress.resolved} of ${progress.total} ` +
+    `images downloaded, ${progress.rendered} drawn`;
+}
+
+function hideProgress() {
+  if (state.progressSource) {
+    state.progressSource.close();
+    state.progressSource = null;
+  }
+  progressBar.hidden = true;
+  progressBar.value = 0;
+  progressText.hidden = true;
+  progressText.textContent = "";
+}
+
+// followProgress shows the progress of the collage request until the collage
+// replaces the page
+function followProgress(requestId) {
+  hideProgress();
+  if (!window.EventSource) {
+    return;
+  }
+  const source = new EventSource(`/collage/progress?id=${requestId}`);
+  source.addEventListener("progress", (event) => {
+    const progress = JSON.parse(event.data);
+    renderProgress(progress);
+    if (progress.done) {
+      source.close();
+    }
+  });
+  source.addEventListener("error", () => {
+    source.close();
+  });
+  state.progressSource = source;
+}
+
 function syncState() {
   const values = getValues();
   updateComputedState(values);
@@ -770,13 +845,16 @@ form.addEventListener("submit", (event) => {
     return;
   }
 
-  const collageURL = generateUrl(values);
+  const requestId = newRequestId();
+  followProgress(requestId);
+  const collageURL = `${generateUrl(values)}&requestid=${requestId}`;
   window.open(collageURL, "_self");
 });
 
 window.addEventListener("pageshow", () => {
   state.isSubmitting = false;
   updateSubmittingState();
+  hideProgress();
 });
 
 window.addEventListener("songstitch:darkmodechange", () => {